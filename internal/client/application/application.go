@@ -1,13 +1,19 @@
 package application
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/synch"
 	"github.com/urfave/cli/v2"
 )
 
@@ -79,7 +85,7 @@ func (a *Application) setCLI() {
 		},
 		{
 			Name:  "stop",
-			Usage: "Stop the specified synchronization.",
+			Usage: "Stop the specified synchronization run (by run ID or synch name).",
 			// Flags: []cli.Flag{
 			// 	&cli.BoolFlag{
 			// 		Name:    "all",
@@ -95,6 +101,109 @@ func (a *Application) setCLI() {
 				return nil
 			},
 		},
+		{
+			Name:  "validate",
+			Usage: "Validate the database and synchronization config files.",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "server",
+					Usage: "Let the running server validate its config files.",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				var valid bool
+				if c.Bool("server") {
+					valid = a.validateOnServer()
+				} else {
+					valid = a.validate()
+				}
+
+				if !valid {
+					return cli.Exit("", 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "scaffold",
+			Usage:     "Draft a synchronization config file from the schemas of two tables.",
+			ArgsUsage: "<source database> <source table> <target database> <target table>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "name",
+					Aliases: []string{"n"},
+					Usage:   "Name of the synchronization, derived from the table names by default.",
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Aliases: []string{"f"},
+					Usage:   "Overwrite an existing config file.",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 4 {
+					return cli.Exit("ERROR: scaffold expects 4 arguments: "+c.Command.ArgsUsage+".", 1)
+				}
+				source := synch.ScaffoldTable{Database: c.Args().Get(0), Table: c.Args().Get(1)}
+				target := synch.ScaffoldTable{Database: c.Args().Get(2), Table: c.Args().Get(3)}
+
+				if err := a.scaffold(source, target, c.String("name"), c.Bool("force")); err != nil {
+					return cli.Exit("ERROR: "+err.Error(), 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show the status of the specified synchronization run or of all runs.",
+			Action: func(c *cli.Context) error {
+				a.runStatus(c.Args().Get(0))
+
+				return nil
+			},
+		},
+		{
+			Name:  "dead-letters",
+			Usage: "Manage writes, which failed permanently.",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "list",
+					Usage:     "List the dead letters of the specified synchronization or of all synchronizations.",
+					ArgsUsage: "[synch name]",
+					Action: func(c *cli.Context) error {
+						a.deadLetters(map[string]string{"synch": c.Args().Get(0)})
+
+						return nil
+					},
+				},
+				{
+					Name:      "inspect",
+					Usage:     "Show the specified dead letter with the write it holds.",
+					ArgsUsage: "<dead letter ID>",
+					Action: func(c *cli.Context) error {
+						if c.Args().Len() != 1 {
+							return cli.Exit("ERROR: inspect expects a dead letter ID.", 1)
+						}
+						a.deadLetters(map[string]string{"inspect": c.Args().Get(0)})
+
+						return nil
+					},
+				},
+				{
+					Name:      "redrive",
+					Usage:     "Repeat the write held by the specified dead letter.",
+					ArgsUsage: "<dead letter ID>",
+					Action: func(c *cli.Context) error {
+						if c.Args().Len() != 1 {
+							return cli.Exit("ERROR: redrive expects a dead letter ID.", 1)
+						}
+						a.deadLetters(map[string]string{"redrive": c.Args().Get(0)})
+
+						return nil
+					},
+				},
+			},
+		},
 	}
 }
 
@@ -121,6 +230,78 @@ func (a *Application) stopSynch(synchName string) {
 	printStopResponse(response)
 }
 
+// runStatus prepares the parameters for a run status request and invokes a GET function.
+func (a *Application) runStatus(runID string) {
+	paramMap := make(map[string]string)
+	if runID != "" {
+		paramMap["run"] = runID
+	}
+
+	response := a.makeGETRequest("http://localhost:8000/status", paramMap)
+
+	printStatusResponse(response)
+}
+
+// deadLetters requests the server to list, inspect or re-drive dead letters.
+func (a *Application) deadLetters(paramMap map[string]string) {
+	response := a.makeGETRequest("http://localhost:8000/deadLetters", paramMap)
+
+	printStatusResponse(response)
+}
+
+// validate checks the local config files and prints all problems found.
+func (a *Application) validate() bool {
+	diagnostics := cfg.ValidateConfigFiles()
+	printDiagnostics(diagnostics)
+	return len(diagnostics) == 0
+}
+
+// scaffold describes the two tables and writes a draft synch config to the synchs directory.
+func (a *Application) scaffold(source synch.ScaffoldTable, target synch.ScaffoldTable, name string, force bool) error {
+	if name == "" {
+		name = scaffoldName(source, target)
+	}
+	path := cfg.SYNCH_DIR + "/" + name + ".yaml"
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+
+	dbs := make(db.Databases)
+	dbs.Init()
+	defer dbs.Close()
+
+	draft, err := synch.Scaffold(context.Background(), dbs, name, source, target)
+	if err != nil {
+		return err
+	}
+	content, err := draft.YAML()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Draft written to %s. Review it before running the synchronization.\n", path)
+	return nil
+}
+
+// scaffoldName names a synch after its tables, e.g. "film_to_films".
+func scaffoldName(source synch.ScaffoldTable, target synch.ScaffoldTable) string {
+	tableName := func(t synch.ScaffoldTable) string {
+		table := t.Table[strings.LastIndex(t.Table, ".")+1:]
+		return strings.ToLower(strings.Trim(table, `"`))
+	}
+	return tableName(source) + "_to_" + tableName(target)
+}
+
+// validateOnServer requests the server to check its config files.
+func (a *Application) validateOnServer() bool {
+	response := a.makeGETRequest("http://localhost:8000/validate", map[string]string{})
+
+	return printValidateResponse(response)
+}
+
 func (a *Application) makeGETRequest(url string, params map[string]string) map[string]interface{} {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
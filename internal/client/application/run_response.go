@@ -2,6 +2,7 @@ package application
 
 import (
 	"fmt"
+	"strings"
 )
 
 // printRunResponse dispatches the response to the corresponding printer function.
@@ -17,18 +18,39 @@ func printRunResponse(res map[string]interface{}, resType string) {
 var runResponsePrinters map[string]func(map[string]interface{}) = map[string]func(map[string]interface{}){
 	// Error printer.
 	"error": func(res map[string]interface{}) {
+		if category, ok := res["category"].(string); ok && category != "" {
+			fmt.Printf("ERROR (%s): ", category)
+		}
 		fmt.Println(res["message"].(string))
 	},
 
 	// one-off synch printer.
 	"one-off": func(res map[string]interface{}) {
 		fmt.Println(res["payload"].(string))
+		printCounters(res)
 		fmt.Println(res["message"].(string))
 	},
 
 	// ongoing synch printer.
 	"ongoing": func(res map[string]interface{}) {
 		fmt.Println(res["payload"].(string))
+		printCounters(res)
 		fmt.Println(res["message"].(string))
 	},
 }
+
+// counterNames are the run's counters in the order they're printed.
+var counterNames = []string{"selected", "paired", "unpaired", "updated", "inserted", "failed", "skipped"}
+
+// printCounters prints the run's counters if the response has them.
+func printCounters(res map[string]interface{}) {
+	counters, ok := res["counters"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	values := make([]string, len(counterNames))
+	for i, name := range counterNames {
+		values[i] = fmt.Sprintf("%s: %v", name, counters[name])
+	}
+	fmt.Println(strings.Join(values, ", "))
+}
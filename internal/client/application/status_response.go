@@ -0,0 +1,17 @@
+package application
+
+import "fmt"
+
+// printStatusResponse prints the list of runs or dead letters or an error message.
+func printStatusResponse(res map[string]interface{}) {
+	if res["err"].(bool) {
+		runResponsePrinters["error"](res)
+		return
+	}
+	if payload := res["payload"].(string); payload != "" {
+		fmt.Println(payload)
+	}
+	if message := res["message"].(string); message != "" {
+		fmt.Println(message)
+	}
+}
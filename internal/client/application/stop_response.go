@@ -4,6 +4,11 @@ import "fmt"
 
 // printStopResponse dispatches the response to the corresponding printer function.
 func printStopResponse(res map[string]interface{}) {
+	if res["err"].(bool) {
+		runResponsePrinters["error"](res)
+		return
+	}
 	fmt.Println(res["payload"].(string))
+	printCounters(res)
 	fmt.Println(res["message"].(string))
 }
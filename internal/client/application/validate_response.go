@@ -0,0 +1,36 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+)
+
+// printDiagnostics prints the config problems one per line.
+func printDiagnostics(diagnostics []cfg.Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		fmt.Println(diagnostic.String())
+	}
+	if len(diagnostics) == 0 {
+		fmt.Println("No problems found in config files.")
+	} else {
+		fmt.Printf("%d problem(s) found in config files.\n", len(diagnostics))
+	}
+}
+
+// printValidateResponse prints the problems found by the server.
+func printValidateResponse(res map[string]interface{}) bool {
+	if !res["err"].(bool) {
+		fmt.Println(res["message"].(string))
+		return true
+	}
+
+	var diagnostics []cfg.Diagnostic
+	if err := json.Unmarshal([]byte(res["payload"].(string)), &diagnostics); err != nil {
+		runResponsePrinters["error"](res)
+		return false
+	}
+	printDiagnostics(diagnostics)
+	return false
+}
@@ -0,0 +1,83 @@
+/*
+Package apperr contains error categories shared by all server
+packages, so that errors can be reported to the clients
+in a uniform way.
+*/
+package apperr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Category groups errors by their cause.
+type Category string
+
+const (
+	CONFIG     Category = "config"
+	CONNECTION Category = "connection"
+	QUERY      Category = "query"
+	MAPPING    Category = "mapping"
+	REQUEST    Category = "request"
+	NOT_FOUND  Category = "notFound"
+	INTERNAL   Category = "internal"
+)
+
+// Categorized is implemented by all errors that belong to a category.
+type Categorized interface {
+	error
+	Category() Category
+}
+
+// Error is a generic categorized error.
+type Error struct {
+	Cat     Category
+	Context string
+	ErrMsg  string
+	Err     error
+}
+
+// New creates a categorized error with a message.
+func New(cat Category, context string, errMsg string) *Error {
+	return &Error{Cat: cat, Context: context, ErrMsg: errMsg}
+}
+
+// Wrap creates a categorized error from another error.
+func Wrap(cat Category, context string, err error) *Error {
+	return &Error{Cat: cat, Context: context, ErrMsg: err.Error(), Err: err}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s] ERROR: %s", e.Context, e.ErrMsg)
+}
+
+// Category returns the error's category.
+func (e *Error) Category() Category {
+	return e.Cat
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CategoryOf finds the category of the first categorized error in the chain.
+// Errors without a category are considered internal.
+func CategoryOf(err error) Category {
+	var categorized Categorized
+	if errors.As(err, &categorized) {
+		return categorized.Category()
+	}
+	return INTERNAL
+}
+
+// FromRecovered turns a value returned by recover() into an error.
+func FromRecovered(r interface{}) error {
+	switch r.(type) {
+	case error:
+		return r.(error)
+	case string:
+		return New(INTERNAL, "panic", r.(string))
+	default:
+		return New(INTERNAL, "panic", fmt.Sprintf("%v", r))
+	}
+}
@@ -0,0 +1,47 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type dbError struct{}
+
+func (e *dbError) Error() string {
+	return "db error"
+}
+
+func (e *dbError) Category() Category {
+	return CONNECTION
+}
+
+func TestCategoryOf(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected Category
+	}{
+		{New(CONFIG, "test", "invalid config"), CONFIG},
+		{&dbError{}, CONNECTION},
+		{fmt.Errorf("wrapped: %w", &dbError{}), CONNECTION},
+		{Wrap(QUERY, "test", &dbError{}), QUERY},
+		{errors.New("plain error"), INTERNAL},
+	}
+
+	for _, c := range cases {
+		if category := CategoryOf(c.err); category != c.expected {
+			t.Errorf("expected category %s for \"%s\", got %s", c.expected, c.err, category)
+		}
+	}
+}
+
+func TestFromRecovered(t *testing.T) {
+	if err := FromRecovered("panic message"); CategoryOf(err) != INTERNAL {
+		t.Errorf("expected an internal error, got %s", CategoryOf(err))
+	}
+
+	original := New(MAPPING, "test", "mapping not found")
+	if err := FromRecovered(original); err != original {
+		t.Errorf("expected the original error to be returned")
+	}
+}
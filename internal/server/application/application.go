@@ -5,11 +5,18 @@ I/O of the app.
 package application
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 	synchPkg "github.com/christoph-karpowicz/db_mediator/internal/server/synch"
 )
@@ -20,85 +27,186 @@ Contains all synchronization and database objects.
 Starts a web server and handles all requests.
 */
 type Application struct {
-	dbs    db.Databases
-	synchs synchPkg.Synchs
+	ctx         context.Context
+	cancel      context.CancelFunc
+	server      *http.Server
+	cfg         *cfg.ServerConfig
+	dbs         db.Databases
+	synchs      *synchPkg.Synchs
+	runs        *synchPkg.Runs
+	runStore    *runStore
+	deadLetters *synchPkg.DeadLetters
 }
 
 // Init starts the application.
 func (a *Application) Init() {
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	a.cfg = cfg.GetServerConfig()
 	a.dbs = make(db.Databases)
 	a.dbs.Init()
 	a.synchs = synchPkg.CreateSynchs()
 	a.synchs.Init()
-	a.listen()
+	a.runs = synchPkg.CreateRuns()
+	a.runStore = newRunStore(RUNNING_SYNCHS_FILE)
+	a.runStore.load()
+	a.deadLetters = synchPkg.CreateDeadLetters(DEAD_LETTERS_FILE)
+	if a.cfg.AutoResume {
+		a.resumeSynchs()
+	}
+	go a.listen()
+	a.waitForShutdown()
 }
 
 func (a *Application) listen() {
-	http.Handle("/", &frontHandler{app: a})
-	http.Handle("/ws/", &webSocketHandler{app: a})
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("front/build/static"))))
-	http.Handle("/runSynch", &runSynchHandler{app: a})
-	http.Handle("/stopSynch", &stopSynchHandler{app: a})
-	http.ListenAndServe(":8000", nil)
+	mux := http.NewServeMux()
+	mux.Handle("/", &frontHandler{app: a})
+	mux.Handle("/ws/", &webSocketHandler{app: a})
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("front/build/static"))))
+	mux.Handle("/runSynch", &runSynchHandler{app: a})
+	mux.Handle("/stopSynch", &stopSynchHandler{app: a})
+	mux.Handle("/status", &statusHandler{app: a})
+	mux.Handle("/validate", &validateHandler{app: a})
+	mux.Handle("/deadLetters", &deadLettersHandler{app: a})
+
+	a.server = &http.Server{Addr: ":8000", Handler: mux}
+	if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalln(err)
+	}
+}
+
+// waitForShutdown blocks until the process receives an interrupt signal
+// and then shuts the application down.
+func (a *Application) waitForShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	a.shutdown()
+}
+
+// shutdown cancels all active runs, saving their partial reports, and stops the web server.
+// Ongoing runs are kept in the run store, so that they can be resumed after a restart.
+func (a *Application) shutdown() {
+	log.Println("Shutting down...")
+	a.cancel()
+
+	for _, run := range a.runs.List() {
+		if run.IsActive() {
+			result := run.Stop()
+			log.Printf("[shutdown] run %s: %s\n", run.ID, result.Message)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if a.server != nil {
+		if err := a.server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+
+	a.dbs.Close()
 }
 
 // runSynch carries out a synchronization run requested by the client.
 func (a *Application) runSynch(responseChan chan *response, synchType string, synchName string, isSimulation bool) {
+	var run *synchPkg.Run
+
+	// Unexpected panics are reported to the client instead of crashing the server.
 	defer func() {
 		if r := recover(); r != nil {
-			responseChan <- createResponse(r.(error))
+			err := apperr.FromRecovered(r)
+			log.Println(err)
+			if run != nil {
+				run.Fail(err)
+			}
+			responseChan <- createResponse(err)
 		}
 	}()
 
-	synch, synchFound := a.synchs[synchName]
+	synch, synchFound := a.synchs.Get(synchName)
 	if !synchFound {
-		panic("[synchronization search] '" + synchName + "' not found.")
+		responseChan <- createResponse(apperr.New(apperr.NOT_FOUND, "synchronization search", "'"+synchName+"' not found."))
+		return
 	}
 
-	synch.SetSimulation(isSimulation)
-
-	// Initialize synchronization.
-	synchID := synch.Init(a.dbs, synchType)
+	// Initialize a new run of the synchronization.
+	run, err := synch.NewRun(a.ctx, a.dbs, a.deadLetters, synchType, isSimulation)
+	if err != nil {
+		log.Println(err)
+		responseChan <- createResponse(err)
+		return
+	}
+	a.runs.Add(run)
 
 	// Carry out all synch actions.
-	if !isSimulation && synch.GetType() == synchPkg.ONGOING {
-		go a.runSynchLoop(synch)
-		responseChan <- createResponse(fmt.Sprintf("Synch %s started with ID %s.", synchName, synchID))
+	if !isSimulation && run.GetSynch().GetType() == synchPkg.ONGOING {
+		a.runStore.add(run.ID, synchName, synchType, isSimulation)
+		go run.Loop()
+		responseChan <- createResponse(fmt.Sprintf("Synch %s started with ID %s.", synchName, run.ID))
 	} else {
-		synch.Run()
-		synchResponse := synch.Flush()
+		synchResponse, err := run.Execute()
+		if err != nil {
+			log.Println(err)
+			responseChan <- createResponse(err)
+			return
+		}
 		responseChan <- createResponse(synchResponse)
-		synch.Reset()
 	}
 }
 
-func (a *Application) runSynchLoop(synch *synchPkg.Synch) {
-	for synch.IsInitial() || synch.IsRunning() {
-		fmt.Println("run synch")
-		synch.Run()
-		synch.SetInitial(false)
-		time.Sleep(1 * time.Second)
+// resumeSynchs restarts all ongoing synchs that were running
+// when the server was last stopped. An entry is kept until its run
+// is restarted, so that a synch which failed to start is retried on the next start.
+func (a *Application) resumeSynchs() {
+	for _, entry := range a.runStore.list() {
+		if _, synchFound := a.synchs.Get(entry.Name); !synchFound {
+			log.Printf("[resume synch] ERROR: '%s' not found.\n", entry.Name)
+			a.runStore.remove(entry.ID)
+			continue
+		}
+		// Only ongoing runs are saved, other entries come from a damaged or edited file.
+		if synchType, err := synchPkg.FindSynchType(entry.Type); err != nil || synchType != synchPkg.ONGOING {
+			log.Printf("[resume synch] ERROR: run %s of '%s' isn't ongoing.\n", entry.ID, entry.Name)
+			a.runStore.remove(entry.ID)
+			continue
+		}
+
+		responseChan := createResponseChannel()
+		go a.runSynch(responseChan, entry.Type, entry.Name, entry.Simulation)
+		response := <-responseChan
+		if response.Err {
+			log.Printf("[resume synch] ERROR: run %s of '%s' couldn't be resumed: %s\n", entry.ID, entry.Name, response.Message)
+			continue
+		}
+		// The resumed run has been saved with a new ID.
+		a.runStore.remove(entry.ID)
+		log.Println("[resume synch]", response.Message)
 	}
 }
 
-// stopSynch stops a specified synchronization.
-func (a *Application) stopSynch(responseChan chan *response, synchName string) {
+// stopSynch stops a specified run. If a synch name is given instead of a run ID,
+// its only active run gets stopped.
+func (a *Application) stopSynch(responseChan chan *response, runID string) {
 	defer func() {
 		if r := recover(); r != nil {
-			responseChan <- createResponse(r.(error))
+			err := apperr.FromRecovered(r)
+			log.Println(err)
+			responseChan <- createResponse(err)
 		}
 	}()
 
 	var synchResponse interface{}
-	synch, synchFound := a.synchs[synchName]
-	if !synchFound {
-		synchResponse = fmt.Sprintf("[synchronization search] \"%s\" not found.", synchName)
-	} else if synch.IsRunning() {
-		synch.Stop()
-		synchResponse = synch.Flush()
-		synch.Reset()
+	run, runFound := a.findRun(runID)
+	if !runFound {
+		synchResponse = apperr.New(apperr.NOT_FOUND, "run search", "\""+runID+"\" not found.")
+	} else if run == nil {
+		synchResponse = apperr.New(apperr.REQUEST, "run search", "\""+runID+"\" has more than one active run, please specify a run ID.")
+	} else if run.IsActive() {
+		synchResponse = run.Stop()
+		a.runStore.remove(run.ID)
 	} else {
-		synchResponse = fmt.Sprintf("Synch \"%s\" is not running.", synchName)
+		synchResponse = fmt.Sprintf("Run \"%s\" is not running.", run.ID)
 	}
 
 	fmt.Println(synchResponse)
@@ -106,12 +214,89 @@ func (a *Application) stopSynch(responseChan chan *response, synchName string) {
 	responseChan <- createResponse(synchResponse)
 }
 
-func (a *Application) listSynchs() []string {
-	synchList := make([]string, 0)
-	for name := range a.synchs {
-		synchList = append(synchList, name)
+// findRun searches for a run by its ID or, if that fails, by the name of its synch.
+// A nil run is returned when the synch has more than one active run.
+func (a *Application) findRun(runID string) (*synchPkg.Run, bool) {
+	if run, found := a.runs.Get(runID); found {
+		return run, true
 	}
-	return synchList
+
+	activeRuns := a.runs.FindActive(runID)
+	switch len(activeRuns) {
+	case 0:
+		return nil, false
+	case 1:
+		return activeRuns[0], true
+	default:
+		return nil, true
+	}
+}
+
+// runStatus returns a summary of a specified run or of all runs
+// if the run ID is empty.
+func (a *Application) runStatus(responseChan chan *response, runID string) {
+	infos := make([]synchPkg.RunInfo, 0)
+
+	if runID == "" {
+		for _, run := range a.runs.List() {
+			infos = append(infos, run.Info())
+		}
+	} else if run, found := a.runs.Get(runID); found {
+		infos = append(infos, run.Info())
+	} else {
+		for _, run := range a.runs.List() {
+			if run.GetSynch().GetConfig().Name == runID {
+				infos = append(infos, run.Info())
+			}
+		}
+		if len(infos) == 0 {
+			responseChan <- createResponse(apperr.New(apperr.NOT_FOUND, "run search", "\""+runID+"\" not found."))
+			return
+		}
+	}
+
+	responseChan <- createResponse(infos)
+}
+
+// listDeadLetters returns the dead letters of a synch or all of them if the name is empty.
+// Their payloads are left out, they're shown when a single letter is inspected.
+func (a *Application) listDeadLetters(responseChan chan *response, synchName string) {
+	letters := make([]synchPkg.DeadLetter, 0)
+	for _, letter := range a.deadLetters.List(synchName) {
+		summary := *letter
+		summary.Payload = nil
+		letters = append(letters, summary)
+	}
+	responseChan <- createResponse(letters)
+}
+
+// inspectDeadLetter returns a single dead letter with the write it holds.
+func (a *Application) inspectDeadLetter(responseChan chan *response, id string) {
+	letter, found := a.deadLetters.Get(id)
+	if !found {
+		responseChan <- createResponse(apperr.New(apperr.NOT_FOUND, "dead letter search", "\""+id+"\" not found."))
+		return
+	}
+	responseChan <- createResponse([]synchPkg.DeadLetter{*letter})
+}
+
+// redriveDeadLetter repeats the write held by a dead letter.
+func (a *Application) redriveDeadLetter(responseChan chan *response, id string) {
+	if err := a.deadLetters.Redrive(a.ctx, a.dbs, id); err != nil {
+		log.Println(err)
+		responseChan <- createResponse(err)
+		return
+	}
+	responseChan <- createResponse(fmt.Sprintf("Dead letter %s re-driven successfully.", id))
+}
+
+// validateConfigs checks all config files and reports the problems found.
+func (a *Application) validateConfigs(responseChan chan *response) {
+	responseChan <- createResponse(cfg.ValidateConfigFiles())
+}
+
+func (a *Application) listSynchs() []string {
+	return a.synchs.Names()
 }
 
 func (a *Application) listSynchsToJSON() []byte {
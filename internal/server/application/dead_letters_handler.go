@@ -0,0 +1,26 @@
+package application
+
+import (
+	"net/http"
+)
+
+// deadLettersHandler lists dead letters, optionally of the synch given in the 'synch' param,
+// inspects the one given in the 'inspect' param or re-drives the one given in the 'redrive' param.
+type deadLettersHandler struct {
+	app *Application
+}
+
+func (h *deadLettersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	resChan := createResponseChannel()
+
+	if id := query.Get("redrive"); id != "" {
+		go h.app.redriveDeadLetter(resChan, id)
+	} else if id := query.Get("inspect"); id != "" {
+		go h.app.inspectDeadLetter(resChan, id)
+	} else {
+		go h.app.listDeadLetters(resChan, query.Get("synch"))
+	}
+
+	writeResponse(w, <-resChan)
+}
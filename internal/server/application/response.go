@@ -1,11 +1,22 @@
 package application
 
-import "github.com/christoph-karpowicz/db_mediator/internal/server/synch"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/synch"
+)
 
 type response struct {
-	Err     bool   `json:"err"`
-	Message string `json:"message"`
-	Payload string `json:"payload"`
+	Err      bool            `json:"err"`
+	Category string          `json:"category,omitempty"`
+	Message  string          `json:"message"`
+	Payload  string          `json:"payload"`
+	Counters *synch.Counters `json:"counters,omitempty"`
+	status   int
 }
 
 func createResponseChannel() chan *response {
@@ -17,10 +28,12 @@ func createResponse(synchResult interface{}) *response {
 
 	switch synchResult.(type) {
 	case error:
-		panic(synchResult.(error))
+		category := apperr.CategoryOf(synchResult.(error))
 		res = &response{
-			Err:     true,
-			Message: synchResult.(error).Error(),
+			Err:      true,
+			Category: string(category),
+			Message:  synchResult.(error).Error(),
+			status:   httpStatus(category),
 		}
 	case string:
 		res = &response{
@@ -28,12 +41,87 @@ func createResponse(synchResult interface{}) *response {
 			Message: synchResult.(string),
 		}
 	case *synch.Result:
+		res = &response{
+			Err:      false,
+			Message:  synchResult.(*synch.Result).Message,
+			Payload:  synchResult.(*synch.Result).OperationsToJSON(),
+			Counters: &synchResult.(*synch.Result).Counters,
+		}
+	case []synch.RunInfo:
+		runsJSON, err := json.MarshalIndent(synchResult, "", "	")
+		if err != nil {
+			return createResponse(apperr.Wrap(apperr.INTERNAL, "run status", err))
+		}
+		res = &response{
+			Err:     false,
+			Payload: string(runsJSON),
+		}
+	case []synch.DeadLetter:
+		lettersJSON, err := json.MarshalIndent(synchResult, "", "	")
+		if err != nil {
+			return createResponse(apperr.Wrap(apperr.INTERNAL, "dead letters", err))
+		}
 		res = &response{
 			Err:     false,
-			Message: synchResult.(*synch.Result).Message,
-			Payload: synchResult.(*synch.Result).OperationsToJSON(),
+			Message: fmt.Sprintf("%d dead letter(s).", len(synchResult.([]synch.DeadLetter))),
+			Payload: string(lettersJSON),
+		}
+	case []cfg.Diagnostic:
+		diagnostics := synchResult.([]cfg.Diagnostic)
+		if len(diagnostics) == 0 {
+			res = &response{
+				Err:     false,
+				Message: "No problems found in config files.",
+			}
+			break
+		}
+		diagnosticsJSON, err := json.MarshalIndent(diagnostics, "", "	")
+		if err != nil {
+			return createResponse(apperr.Wrap(apperr.INTERNAL, "config validation", err))
+		}
+		res = &response{
+			Err:      true,
+			Category: string(apperr.CONFIG),
+			Message:  fmt.Sprintf("%d problem(s) found in config files.", len(diagnostics)),
+			Payload:  string(diagnosticsJSON),
+			status:   httpStatus(apperr.CONFIG),
 		}
 	}
 
 	return res
 }
+
+// httpStatus maps an error category to an HTTP status code.
+func httpStatus(category apperr.Category) int {
+	switch category {
+	case apperr.REQUEST:
+		return http.StatusBadRequest
+	case apperr.NOT_FOUND:
+		return http.StatusNotFound
+	case apperr.CONFIG, apperr.MAPPING:
+		return http.StatusUnprocessableEntity
+	case apperr.CONNECTION:
+		return http.StatusServiceUnavailable
+	case apperr.QUERY:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeResponse sends a JSON response with the status code matching its error category.
+func writeResponse(w http.ResponseWriter, res *response) {
+	responseJSON, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, "Error while marshalling response.", http.StatusInternalServerError)
+		return
+	}
+
+	status := res.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,130 @@
+package application
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+)
+
+const (
+	STATE_DIR           = "./state/"
+	RUNNING_SYNCHS_FILE = STATE_DIR + "running_synchs.json"
+	DEAD_LETTERS_FILE   = STATE_DIR + "dead_letters.json"
+)
+
+// runEntry holds everything that's needed to restart
+// an ongoing synch after a server restart.
+type runEntry struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Simulation bool   `json:"simulation"`
+	StartedAt  string `json:"startedAt"`
+}
+
+// runStore persists the list of running ongoing synchs to a file.
+type runStore struct {
+	mux     sync.Mutex
+	path    string
+	entries map[string]runEntry
+}
+
+func newRunStore(path string) *runStore {
+	return &runStore{
+		path:    path,
+		entries: make(map[string]runEntry),
+	}
+}
+
+// load reads the saved entries from the state file.
+// A missing file means there's nothing to resume.
+func (rs *runStore) load() {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	byteArray, err := ioutil.ReadFile(rs.path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Println("[run store] ERROR: ", err)
+		return
+	}
+
+	var entries []runEntry
+	if err := json.Unmarshal(byteArray, &entries); err != nil {
+		log.Println("[run store] ERROR: ", err)
+		return
+	}
+	for _, entry := range entries {
+		rs.entries[entry.ID] = entry
+	}
+}
+
+func (rs *runStore) list() []runEntry {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	entries := make([]runEntry, 0, len(rs.entries))
+	for _, entry := range rs.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (rs *runStore) add(id string, name string, synchType string, simulation bool) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	rs.entries[id] = runEntry{
+		ID:         id,
+		Name:       name,
+		Type:       synchType,
+		Simulation: simulation,
+		StartedAt:  util.GetTimestamp(),
+	}
+	rs.save()
+}
+
+func (rs *runStore) remove(id string) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	if _, found := rs.entries[id]; !found {
+		return
+	}
+	delete(rs.entries, id)
+	rs.save()
+}
+
+// save writes all entries to a temporary file and moves it
+// in place of the state file, so that it's never left half-written.
+func (rs *runStore) save() {
+	entries := make([]runEntry, 0, len(rs.entries))
+	for _, entry := range rs.entries {
+		entries = append(entries, entry)
+	}
+
+	entriesJSON, err := json.MarshalIndent(entries, "", "	")
+	if err != nil {
+		log.Println("[run store] ERROR: ", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rs.path), 0755); err != nil {
+		log.Println("[run store] ERROR: ", err)
+		return
+	}
+	tmpPath := rs.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, entriesJSON, 0644); err != nil {
+		log.Println("[run store] ERROR: ", err)
+		return
+	}
+	if err := os.Rename(tmpPath, rs.path); err != nil {
+		log.Println("[run store] ERROR: ", err)
+	}
+}
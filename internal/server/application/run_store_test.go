@@ -0,0 +1,160 @@
+package application
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	synchPkg "github.com/christoph-karpowicz/db_mediator/internal/server/synch"
+)
+
+func TestRunStoreLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"missing file", "", nil},
+		{"invalid JSON", "[{", nil},
+		{"empty list", "[]", nil},
+		{"entries", `[{"id": "films-1", "name": "films", "type": "ongoing"}, {"id": "actors-2", "name": "actors", "type": "ongoing"}]`,
+			[]string{"actors-2", "films-1"}},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "running_synchs.json")
+		if test.content != "" {
+			if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		store := newRunStore(path)
+		store.load()
+		if ids := entryIDs(store.list()); !equalIDs(ids, test.expected) {
+			t.Errorf("%s: expected entries %v, got %v", test.name, test.expected, ids)
+		}
+	}
+}
+
+func TestRunStoreSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "running_synchs.json")
+	store := newRunStore(path)
+
+	tests := []struct {
+		name     string
+		change   func()
+		expected []string
+	}{
+		{"add", func() { store.add("films-1", "films", "ongoing", false) }, []string{"films-1"}},
+		{"add another", func() { store.add("actors-2", "actors", "ongoing", false) }, []string{"actors-2", "films-1"}},
+		{"remove", func() { store.remove("films-1") }, []string{"actors-2"}},
+		{"remove unknown", func() { store.remove("films-1") }, []string{"actors-2"}},
+		{"remove last", func() { store.remove("actors-2") }, nil},
+	}
+
+	for _, test := range tests {
+		test.change()
+
+		// Every change is saved, so a new store reads the same entries back.
+		saved := newRunStore(path)
+		saved.load()
+		if ids := entryIDs(saved.list()); !equalIDs(ids, test.expected) {
+			t.Errorf("%s: expected saved entries %v, got %v", test.name, test.expected, ids)
+		}
+		if ids := entryIDs(store.list()); !equalIDs(ids, test.expected) {
+			t.Errorf("%s: expected entries %v, got %v", test.name, test.expected, ids)
+		}
+	}
+
+	if _, err := ioutil.ReadFile(path + ".tmp"); err == nil {
+		t.Errorf("expected the temporary file to be moved in place of the state file")
+	}
+}
+
+func TestResumeSynchs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "running_synchs.json")
+	content := `[
+		{"id": "missing-1", "name": "missing", "type": "ongoing"},
+		{"id": "films-2", "name": "films", "type": "one-off"},
+		{"id": "films-3", "name": "films", "type": "weekly"}
+	]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Application{
+		synchs:   synchPkg.CreateSynchs(),
+		runs:     synchPkg.CreateRuns(),
+		runStore: newRunStore(path),
+	}
+	a.synchs.Add(&cfg.SynchConfig{Name: "films"})
+	a.runStore.load()
+	a.resumeSynchs()
+
+	if runs := a.runs.List(); len(runs) != 0 {
+		t.Errorf("expected unknown and non-ongoing entries to be skipped, got %d runs", len(runs))
+	}
+	saved := newRunStore(path)
+	saved.load()
+	if entries := saved.list(); len(entries) != 0 {
+		t.Errorf("expected skipped entries to be removed from the store, got %v", entries)
+	}
+}
+
+func TestResumeSynchsFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "running_synchs.json")
+	content := `[{"id": "films-1", "name": "films", "type": "ongoing"}]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Application{
+		ctx:      context.Background(),
+		dbs:      make(db.Databases),
+		synchs:   synchPkg.CreateSynchs(),
+		runs:     synchPkg.CreateRuns(),
+		runStore: newRunStore(path),
+	}
+	// The node's database isn't configured, so the run fails to start.
+	a.synchs.Add(&cfg.SynchConfig{
+		Name:  "films",
+		Nodes: []cfg.NodeConfig{{Name: "films", Database: "films_db", Table: "films", Key: "id"}},
+	})
+	a.runStore.load()
+	a.resumeSynchs()
+
+	if runs := a.runs.List(); len(runs) != 0 {
+		t.Errorf("expected the run to fail to start, got %d runs", len(runs))
+	}
+	saved := newRunStore(path)
+	saved.load()
+	if ids := entryIDs(saved.list()); !equalIDs(ids, []string{"films-1"}) {
+		t.Errorf("expected the entry of the failed run to be kept, got %v", ids)
+	}
+}
+
+func entryIDs(entries []runEntry) []string {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalIDs(ids []string, expected []string) bool {
+	if len(ids) != len(expected) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,10 @@
 package application
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
 	"strconv"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 )
 
 type runSynchHandler struct {
@@ -15,12 +14,14 @@ type runSynchHandler struct {
 func (h *runSynchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	synchType, ok := r.URL.Query()["type"]
 	if !ok || len(synchType[0]) < 1 {
-		log.Fatalln("[http request] ERROR: URL param 'type' is missing.")
+		writeResponse(w, createResponse(apperr.New(apperr.REQUEST, "http request", "URL param 'type' is missing.")))
+		return
 	}
 
 	run, ok := r.URL.Query()["run"]
 	if !ok || len(run[0]) < 1 {
-		log.Fatalln("[http request] ERROR: URL param 'run' is missing.")
+		writeResponse(w, createResponse(apperr.New(apperr.REQUEST, "http request", "URL param 'run' is missing.")))
+		return
 	}
 
 	simulationStr, ok := r.URL.Query()["simulation"]
@@ -29,21 +30,17 @@ func (h *runSynchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	simulation, err := strconv.ParseBool(simulationStr[0])
 	if err != nil {
-		log.Fatalln("[http request] ERROR: Wrong 'simulation' URL param value.")
+		writeResponse(w, createResponse(apperr.New(apperr.REQUEST, "http request", "Wrong 'simulation' URL param value.")))
+		return
 	}
 
 	if simulation && synchType[0] == "ongoing" {
-		log.Fatalln("[http request] ERROR: Cannot start an ongoing synchronization simulation.")
+		writeResponse(w, createResponse(apperr.New(apperr.REQUEST, "http request", "Cannot start an ongoing synchronization simulation.")))
+		return
 	}
 
 	resChan := createResponseChannel()
 	go h.app.runSynch(resChan, synchType[0], run[0], simulation)
 
-	response := <-resChan
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		panic("Error while marshalling response.")
-	}
-
-	fmt.Fprintf(w, "%s", responseJSON)
+	writeResponse(w, <-resChan)
 }
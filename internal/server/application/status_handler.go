@@ -0,0 +1,22 @@
+package application
+
+import (
+	"net/http"
+)
+
+type statusHandler struct {
+	app *Application
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var runID string
+	run, ok := r.URL.Query()["run"]
+	if ok {
+		runID = run[0]
+	}
+
+	resChan := createResponseChannel()
+	go h.app.runStatus(resChan, runID)
+
+	writeResponse(w, <-resChan)
+}
@@ -1,10 +1,9 @@
 package application
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 )
 
 type stopSynchHandler struct {
@@ -14,7 +13,8 @@ type stopSynchHandler struct {
 func (h *stopSynchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	stop, ok := r.URL.Query()["stop"]
 	if !ok || len(stop[0]) < 1 {
-		log.Fatalln("[http request] ERROR: URL param 'stop' is missing.")
+		writeResponse(w, createResponse(apperr.New(apperr.REQUEST, "http request", "URL param 'stop' is missing.")))
+		return
 	}
 
 	// allStr, ok := r.URL.Query()["all"]
@@ -29,11 +29,5 @@ func (h *stopSynchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	resChan := createResponseChannel()
 	go h.app.stopSynch(resChan, stop[0])
 
-	response := <-resChan
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		panic("Error while marshalling response.")
-	}
-
-	fmt.Fprintf(w, "%s", responseJSON)
+	writeResponse(w, <-resChan)
 }
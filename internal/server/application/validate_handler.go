@@ -0,0 +1,16 @@
+package application
+
+import (
+	"net/http"
+)
+
+type validateHandler struct {
+	app *Application
+}
+
+func (h *validateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resChan := createResponseChannel()
+	go h.app.validateConfigs(resChan)
+
+	writeResponse(w, <-resChan)
+}
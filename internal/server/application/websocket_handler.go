@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/gorilla/websocket"
 )
 
@@ -33,8 +34,9 @@ type wsOutbound struct {
 }
 
 type wsOutboundData struct {
-	Message string `yaml:"message"`
-	Payload string `yaml:"payload"`
+	Message  string `yaml:"message"`
+	Category string `yaml:"category"`
+	Payload  string `yaml:"payload"`
 }
 
 var wsUpgrader = websocket.Upgrader{
@@ -51,7 +53,9 @@ func (wsh *webSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	ws, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		panic(err)
+		// The upgrader has already replied with an HTTP error.
+		log.Println(err)
+		return
 	}
 	log.Println("Client Connected")
 
@@ -72,7 +76,16 @@ func (wsh *webSocketHandler) wsReader(ws *websocket.Conn) {
 		var wsReq wsInbound
 		marshalErr := json.Unmarshal(message, &wsReq)
 		if marshalErr != nil {
-			// panic(marshalErr)
+			log.Println(marshalErr)
+			wsh.writeWsResponse(ws, messageType, wsOutbound{
+				Name:    "invalidRequest",
+				Success: false,
+				Data: wsOutboundData{
+					Message:  "Invalid websocket request: " + marshalErr.Error(),
+					Category: string(apperr.REQUEST),
+				},
+			})
+			continue
 		}
 		fmt.Println(wsReq)
 		wsh.dispatchWsRequest(ws, &wsReq, messageType)
@@ -98,14 +111,16 @@ func (wsh *webSocketHandler) dispatchWsRequest(ws *websocket.Conn, wsReq *wsInbo
 
 		responseChan := createResponseChannel()
 		go wsh.app.runSynch(responseChan, "one-off", synchName, true)
-		// response := <-responseChan
+		response := <-responseChan
 
 		wsOut = wsOutbound{
 			ID:      wsReq.ID,
 			Name:    "synchStarted",
-			Success: true,
-			Data:    wsOutboundData{
-				// Message: response.(string),
+			Success: !response.Err,
+			Data: wsOutboundData{
+				Message:  response.Message,
+				Category: response.Category,
+				Payload:  response.Payload,
 			},
 		}
 	case WS_REQ_STOPSYNCH:
@@ -113,14 +128,16 @@ func (wsh *webSocketHandler) dispatchWsRequest(ws *websocket.Conn, wsReq *wsInbo
 
 		responseChan := createResponseChannel()
 		go wsh.app.stopSynch(responseChan, synchName)
-		// response := <-responseChan
+		response := <-responseChan
 
 		wsOut = wsOutbound{
 			ID:      wsReq.ID,
 			Name:    "synchStopped",
-			Success: true,
-			Data:    wsOutboundData{
-				// Message: response.(string),
+			Success: !response.Err,
+			Data: wsOutboundData{
+				Message:  response.Message,
+				Category: response.Category,
+				Payload:  response.Payload,
 			},
 		}
 	default:
@@ -129,14 +146,20 @@ func (wsh *webSocketHandler) dispatchWsRequest(ws *websocket.Conn, wsReq *wsInbo
 			Name:    "unknownRequest",
 			Success: false,
 			Data: wsOutboundData{
-				Message: "Unknown websocket request name \"" + wsReq.Name + "\".",
+				Message:  "Unknown websocket request name \"" + wsReq.Name + "\".",
+				Category: string(apperr.REQUEST),
 			},
 		}
 	}
 
+	wsh.writeWsResponse(ws, messageType, wsOut)
+}
+
+func (wsh *webSocketHandler) writeWsResponse(ws *websocket.Conn, messageType int, wsOut wsOutbound) {
 	wsOutJSON, err := json.Marshal(wsOut)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return
 	}
 
 	if err := ws.WriteMessage(messageType, wsOutJSON); err != nil {
@@ -0,0 +1,116 @@
+package cfg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ANY_INDEX is the index of a "[*]" path segment, which addresses all elements of an array.
+const ANY_INDEX = -1
+
+// ColumnRef is a reference to a node's column like:
+// example_node.example_column
+// A path can follow the column to address a value nested in a document, e.g.
+// example_node.meta.rating or example_node.tags[*].name
+type ColumnRef struct {
+	Node   string
+	Column string
+	Path   []PathSegment
+	// Pos is the 1-based column in the source string the reference starts at.
+	Pos int
+}
+
+func (c *ColumnRef) String() string {
+	return quoteName(c.Node) + "." + quoteName(c.Column) + FormatPath(c.Path)
+}
+
+// PathSegment is a field of an embedded document or an index of an array.
+type PathSegment struct {
+	Field   string
+	Index   int
+	IsIndex bool
+}
+
+func (s PathSegment) String() string {
+	switch {
+	case !s.IsIndex:
+		return "." + quoteName(s.Field)
+	case s.Index == ANY_INDEX:
+		return "[*]"
+	}
+	return "[" + strconv.Itoa(s.Index) + "]"
+}
+
+// FormatPath formats a path the way it's written after a column.
+func FormatPath(path []PathSegment) string {
+	var formatted strings.Builder
+	for _, segment := range path {
+		formatted.WriteString(segment.String())
+	}
+	return formatted.String()
+}
+
+// WhereClause holds the conditions of a link's endpoint.
+type WhereClause struct {
+	Raw    string
+	Filter Filter
+	Pos    int
+}
+
+// LinkEndpoint is a source or target of a link like:
+// [example_node.example_column WHERE ...]
+type LinkEndpoint struct {
+	Ref   *ColumnRef
+	Where *WhereClause
+	Pos   int
+}
+
+// GetFilter returns the parsed conditions of the endpoint or
+// nil if there aren't any.
+func (e *LinkEndpoint) GetFilter() Filter {
+	if e.Where == nil {
+		return nil
+	}
+	return e.Where.Filter
+}
+
+func (e *LinkEndpoint) String() string {
+	if e.Where == nil {
+		return "[" + e.Ref.String() + "]"
+	}
+	return "[" + e.Ref.String() + " " + WHERE_CLAUSE + " " + e.Where.Raw + "]"
+}
+
+// LinkStmt is a parsed link like:
+// [example_node1.example_column1 WHERE ...] TO [example_node2.example_column2 WHERE ...]
+type LinkStmt struct {
+	Cmd    string
+	Source *LinkEndpoint
+	Target *LinkEndpoint
+}
+
+func (l *LinkStmt) String() string {
+	return l.Source.String() + " " + TO_CLAUSE + " " + l.Target.String()
+}
+
+// MappingStmt is a parsed mapping like:
+// example_node1.example_column1 TO example_node2.example_column2
+type MappingStmt struct {
+	Cmd    string
+	Source *ColumnRef
+	Target *ColumnRef
+}
+
+func (m *MappingStmt) String() string {
+	return m.Source.String() + " " + TO_CLAUSE + " " + m.Target.String()
+}
+
+// quoteName puts a name in double quotes if it can't be written as is.
+// Numeric names are quoted too, so that "1.2" isn't read as a number.
+func quoteName(name string) string {
+	if isPlainName(name) && !isDigits(name) {
+		return name
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name)
+	return `"` + escaped + `"`
+}
@@ -1,8 +1,10 @@
 package cfg
 
 const (
-	SYNCH_DIR   = "./config/synch"
-	WATCHER_DIR = "./config/watch"
+	SYNCH_DIR       = "./config/synch"
+	WATCHER_DIR     = "./config/watch"
+	SERVER_CFG_PATH = "./config/server.yaml"
+	DB_CFG_PATH     = "./config/databases.yaml"
 )
 
 type Config interface {
@@ -36,6 +36,8 @@ func ImportYAMLDir(dirPath string) []Config {
 }
 
 // ImportYAMLFile imports a configuration file into a Config struct.
+// In database configs environment variables referenced in values like ${NAME}
+// are substituted and passwords are read from files or commands.
 func ImportYAMLFile(cfg Config, filePath string) {
 	fp, _ := filepath.Abs(filePath)
 
@@ -51,13 +53,30 @@ func ImportYAMLFile(cfg Config, filePath string) {
 		panic(err)
 	}
 
+	var document yaml.Node
+	if err := yaml.Unmarshal(byteArray, &document); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	// An empty file leaves the config with its default values.
+	if document.Kind == 0 {
+		return
+	}
+
 	var marshalErr error
 
 	switch cfg.(type) {
 	case *DbConfigArray:
-		marshalErr = yaml.Unmarshal(byteArray, cfg.(*DbConfigArray))
+		for _, err := range interpolateNode(&document) {
+			log.Fatalf("error in %s: %v", fp, err)
+		}
+		marshalErr = document.Decode(cfg.(*DbConfigArray))
+		if marshalErr == nil {
+			marshalErr = cfg.(*DbConfigArray).resolveSecrets()
+		}
 	case *SynchConfig:
-		marshalErr = yaml.Unmarshal(byteArray, cfg.(*SynchConfig))
+		marshalErr = document.Decode(cfg.(*SynchConfig))
+	case *ServerConfig:
+		marshalErr = document.Decode(cfg.(*ServerConfig))
 	}
 
 	if marshalErr != nil {
@@ -0,0 +1,114 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("DB_MEDIATOR_TEST_HOST", "db.example.com")
+	os.Setenv("DB_MEDIATOR_TEST_EMPTY", "")
+	defer os.Unsetenv("DB_MEDIATOR_TEST_HOST")
+	defer os.Unsetenv("DB_MEDIATOR_TEST_EMPTY")
+
+	cases := map[string]string{
+		"${DB_MEDIATOR_TEST_HOST}":                          "db.example.com",
+		"http://${DB_MEDIATOR_TEST_HOST}:80":                "http://db.example.com:80",
+		"${DB_MEDIATOR_TEST_UNSET:-localhost}":              "localhost",
+		"${DB_MEDIATOR_TEST_EMPTY:-localhost}":              "localhost",
+		"$${DB_MEDIATOR_TEST_HOST}":                         "${DB_MEDIATOR_TEST_HOST}",
+		`{"_id": {"$lt": 3}}`:                               `{"_id": {"$lt": 3}}`,
+		"${DB_MEDIATOR_TEST_HOST}${DB_MEDIATOR_TEST_EMPTY}": "db.example.com",
+	}
+	for value, expected := range cases {
+		interpolated, err := interpolate(value)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", value, err)
+		} else if interpolated != expected {
+			t.Errorf("expected %s to be interpolated as %s, got %s", value, expected, interpolated)
+		}
+	}
+
+	for _, value := range []string{"${DB_MEDIATOR_TEST_UNSET}", "${DB_MEDIATOR_TEST_HOST", "${:-x}"} {
+		if _, err := interpolate(value); err == nil {
+			t.Errorf("expected an error for %s", value)
+		}
+	}
+}
+
+func TestImportYAMLFileSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg_parser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DB_MEDIATOR_TEST_PORT", "5433")
+	defer os.Unsetenv("DB_MEDIATOR_TEST_PORT")
+
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dbCfgPath := filepath.Join(dir, "databases.yaml")
+	dbCfg := `databases:
+    -
+        name          : dvdrental
+        type          : postgres
+        host          : localhost
+        port          : ${DB_MEDIATOR_TEST_PORT}
+        user          : postgres
+        password_file : ` + passwordFile + `
+    -
+        name          : msamp
+        type          : mongo
+        host          : localhost
+        port          : 27017
+        user          : mongo
+        password_cmd  : echo "from command"
+`
+	if err := ioutil.WriteFile(dbCfgPath, []byte(dbCfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var dbCfgs DbConfigArray
+	ImportYAMLFile(&dbCfgs, dbCfgPath)
+	dbCfgs.Validate()
+
+	if port := dbCfgs.Databases[0].Port; port != 5433 {
+		t.Errorf("expected port 5433, got %d", port)
+	}
+	if password := dbCfgs.Databases[0].Password; password != "from file" {
+		t.Errorf("expected the password to be read from file, got \"%s\"", password)
+	}
+	if password := dbCfgs.Databases[1].Password; password != "from command" {
+		t.Errorf("expected the password to be read from command's output, got \"%s\"", password)
+	}
+}
+
+func TestImportYAMLFileSynchNotInterpolated(t *testing.T) {
+	os.Setenv("DB_MEDIATOR_TEST_SALT", "from env")
+	defer os.Unsetenv("DB_MEDIATOR_TEST_SALT")
+
+	synchCfgPath := filepath.Join(t.TempDir(), "films.yaml")
+	synchCfg := `name: films
+mask:
+    -
+        column   : films.title
+        function : hash
+        salt     : ${DB_MEDIATOR_TEST_SALT}
+`
+	if err := ioutil.WriteFile(synchCfgPath, []byte(synchCfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var synchConfig SynchConfig
+	ImportYAMLFile(&synchConfig, synchCfgPath)
+
+	// Only database configs are interpolated.
+	if salt := synchConfig.Mask[0].Salt; salt != "${DB_MEDIATOR_TEST_SALT}" {
+		t.Errorf("expected the salt to be left as is, got \"%s\"", salt)
+	}
+}
@@ -0,0 +1,33 @@
+package cfg
+
+import "time"
+
+// DEFAULT_TIME_PRECISION is the precision times are compared with by default.
+// MongoDB stores times in milliseconds, PostgreSQL in microseconds.
+const DEFAULT_TIME_PRECISION = time.Millisecond
+
+// Compare holds the rules of comparing and converting values of linked columns.
+type Compare struct {
+	// FloatTolerance is the greatest difference of two numbers, which are still equal.
+	FloatTolerance float64 `yaml:"float_tolerance,omitempty"`
+	// TimePrecision is the unit times are truncated to before they're compared.
+	TimePrecision time.Duration `yaml:"time_precision,omitempty"`
+	// TimeZone is the zone of times stored without one, e.g. PostgreSQL's timestamp.
+	TimeZone string `yaml:"time_zone,omitempty"`
+}
+
+// GetTimePrecision returns the time precision, which defaults to DEFAULT_TIME_PRECISION.
+func (c *Compare) GetTimePrecision() time.Duration {
+	if c.TimePrecision <= 0 {
+		return DEFAULT_TIME_PRECISION
+	}
+	return c.TimePrecision
+}
+
+// GetLocation loads the time zone, which defaults to UTC.
+func (c *Compare) GetLocation() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(c.TimeZone)
+}
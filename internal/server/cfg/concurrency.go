@@ -0,0 +1,29 @@
+package cfg
+
+// DEFAULT_WORKERS synchronizes one pair at a time.
+const DEFAULT_WORKERS = 1
+
+// Concurrency bounds how many links and pairs of a synch are synchronized at the same time.
+// Workers is the number of pairs synchronized at once, which is also the number of links
+// read at once. PerTarget limits how many of the workers write to the same target node.
+type Concurrency struct {
+	Workers   int `yaml:"workers,omitempty"`
+	PerTarget int `yaml:"per_target,omitempty"`
+}
+
+// GetWorkers returns the number of workers, which defaults to DEFAULT_WORKERS.
+func (c *Concurrency) GetWorkers() int {
+	if c.Workers <= 0 {
+		return DEFAULT_WORKERS
+	}
+	return c.Workers
+}
+
+// GetPerTarget returns the number of workers writing to the same target node,
+// which defaults to all of them.
+func (c *Concurrency) GetPerTarget() int {
+	if c.PerTarget <= 0 || c.PerTarget > c.GetWorkers() {
+		return c.GetWorkers()
+	}
+	return c.PerTarget
+}
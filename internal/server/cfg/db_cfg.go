@@ -1,10 +1,47 @@
 package cfg
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
 	validationUtil "github.com/christoph-karpowicz/db_mediator/internal/util/validation"
 )
 
-var dbNullableFields = []string{"alias"}
+var dbNullableFields = []string{"alias", "password_file", "password_cmd", "dsn", "auth_source", "replica_set", "search_path", "pool_size", "rate_limit", "mode", "writable_tables"}
+
+// dbConnectionFields are only required if a database doesn't have a DSN.
+var dbConnectionFields = []string{"host", "port", "user", "password"}
+
+const (
+	TLS_DISABLE     = "disable"
+	TLS_REQUIRE     = "require"
+	TLS_VERIFY_CA   = "verify-ca"
+	TLS_VERIFY_FULL = "verify-full"
+)
+
+var tlsModes = []string{TLS_DISABLE, TLS_REQUIRE, TLS_VERIFY_CA, TLS_VERIFY_FULL}
+
+// Databases in DB_MODE_READ_ONLY are never written to, whatever synchs are configured.
+const (
+	DB_MODE_WRITE     = "write"
+	DB_MODE_READ_ONLY = "read-only"
+)
+
+var dbModes = []string{DB_MODE_WRITE, DB_MODE_READ_ONLY}
+
+// PASSWORD_CMD_TIMEOUT limits how long a password command can run.
+const PASSWORD_CMD_TIMEOUT = 10 * time.Second
+
+const (
+	DEFAULT_POOL_SIZE             = 10
+	DEFAULT_HEALTH_CHECK_INTERVAL = 30 * time.Second
+)
 
 // DbConfigArray is an array of YAML database configs.
 type DbConfigArray struct {
@@ -13,13 +50,105 @@ type DbConfigArray struct {
 
 // DbConfig represents an individual YAML database config.
 type DbConfig struct {
-	Name     string `yaml:"name"`
-	Alias    string `yaml:"alias"`
-	Type     string `yaml:"type"`
+	Name  string `yaml:"name"`
+	Alias string `yaml:"alias"`
+	Type  string `yaml:"type"`
+	// DSN is a full connection string or URI. Other connection
+	// fields set along with it override its options.
+	DSN      string `yaml:"dsn"`
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
+	// PasswordFile and PasswordCmd are alternatives to Password,
+	// the password is read from the file or the command's output.
+	PasswordFile string     `yaml:"password_file"`
+	PasswordCmd  string     `yaml:"password_cmd"`
+	TLS          *TLSConfig `yaml:"tls"`
+	// AuthSource and ReplicaSet are MongoDB specific.
+	AuthSource string `yaml:"auth_source"`
+	ReplicaSet string `yaml:"replica_set"`
+	// SearchPath is PostgreSQL specific.
+	SearchPath     string        `yaml:"search_path"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	QueryTimeout   time.Duration `yaml:"query_timeout"`
+	// PoolSize is the maximum number of open connections.
+	PoolSize            int           `yaml:"pool_size"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// AllowRawFilters enables native conditions in links' WHERE clauses.
+	AllowRawFilters bool `yaml:"allow_raw_filters"`
+	// RateLimit throttles the writes of all synchs to the database.
+	RateLimit *RateLimit `yaml:"rate_limit"`
+	// Mode is "write" (the default) or "read-only". WritableTables limits writes
+	// to the listed tables, all tables are writable if it's empty.
+	Mode           string   `yaml:"mode"`
+	WritableTables []string `yaml:"writable_tables"`
+}
+
+// TLSConfig holds the TLS options of a database connection.
+// Mode is one of: disable, require, verify-ca, verify-full.
+// The modes have the same meaning as PostgreSQL's sslmode.
+type TLSConfig struct {
+	Mode     string `yaml:"mode"`
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// GetMode returns the TLS mode, which defaults to verify-full.
+func (t *TLSConfig) GetMode() string {
+	if t.Mode == "" {
+		return TLS_VERIFY_FULL
+	}
+	return t.Mode
+}
+
+// GetNullableFields returns the fields, which can be left empty.
+func (d *DbConfig) GetNullableFields() []string {
+	nullableFields := make([]string, len(dbNullableFields))
+	copy(nullableFields, dbNullableFields)
+	if d.DSN != "" {
+		nullableFields = append(nullableFields, dbConnectionFields...)
+	}
+	return nullableFields
+}
+
+// GetPoolSize returns the connection pool size, which defaults to DEFAULT_POOL_SIZE.
+func (d *DbConfig) GetPoolSize() int {
+	if d.PoolSize <= 0 {
+		return DEFAULT_POOL_SIZE
+	}
+	return d.PoolSize
+}
+
+// GetHealthCheckInterval returns how often the connection is checked,
+// which defaults to DEFAULT_HEALTH_CHECK_INTERVAL.
+func (d *DbConfig) GetHealthCheckInterval() time.Duration {
+	if d.HealthCheckInterval <= 0 {
+		return DEFAULT_HEALTH_CHECK_INTERVAL
+	}
+	return d.HealthCheckInterval
+}
+
+// GetMode returns the database's mode, which defaults to DB_MODE_WRITE.
+func (d *DbConfig) GetMode() string {
+	if d.Mode == "" {
+		return DB_MODE_WRITE
+	}
+	return d.Mode
+}
+
+// HasKnownMode tells whether the database's mode is one of the known modes.
+func (d *DbConfig) HasKnownMode() bool {
+	return util.StringSliceContains(dbModes, d.GetMode())
+}
+
+// IsWritable tells whether the table can be written to. Databases with an unknown mode aren't writable.
+func (d *DbConfig) IsWritable(tableName string) bool {
+	if d.GetMode() != DB_MODE_WRITE {
+		return false
+	}
+	return len(d.WritableTables) == 0 || util.StringSliceContains(d.WritableTables, tableName)
 }
 
 // GetName returns the DB's name if an alias hasn't been provided.
@@ -33,13 +162,48 @@ func (d *DbConfig) GetName() string {
 // Validate calls a validation function on itself.
 func (d *DbConfigArray) Validate() {
 	for _, dbCfg := range d.Databases {
-		validationUtil.YAMLStruct(dbCfg, dbNullableFields)
+		validationUtil.YAMLStruct(dbCfg, dbCfg.GetNullableFields())
+	}
+}
+
+// resolveSecrets reads the passwords of databases, which
+// have a password file or command configured.
+func (d *DbConfigArray) resolveSecrets() error {
+	for i := range d.Databases {
+		if err := d.Databases[i].resolvePassword(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DbConfig) resolvePassword() error {
+	switch {
+	case d.PasswordFile != "":
+		password, err := ioutil.ReadFile(d.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("database %s: couldn't read the password file: %v", d.GetName(), err)
+		}
+		d.Password = strings.TrimRight(string(password), "\r\n")
+	case d.PasswordCmd != "":
+		ctx, cancel := context.WithTimeout(context.Background(), PASSWORD_CMD_TIMEOUT)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", d.PasswordCmd)
+		cmd.Stderr = &stderr
+		password, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("database %s: password command failed: %v %s", d.GetName(), err, strings.TrimSpace(stderr.String()))
+		}
+		d.Password = strings.TrimRight(string(password), "\r\n")
 	}
+	return nil
 }
 
 // GetDbConfigs loads the database configs from databases.yaml file.
 func GetDbConfigs() *DbConfigArray {
 	var dbDataArr DbConfigArray = DbConfigArray{}
-	ImportYAMLFile(&dbDataArr, "./config/databases.yaml")
+	ImportYAMLFile(&dbDataArr, DB_CFG_PATH)
 	return &dbDataArr
 }
@@ -0,0 +1,402 @@
+package cfg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Grammar of the WHERE clause conditions:
+//
+//   conditions = "RAW" "(" native query ")" | or
+//   or         = and { "OR" and }
+//   and        = not { "AND" not }
+//   not        = "NOT" not | "(" or ")" | predicate
+//   predicate  = column ( operator value
+//                       | [ "NOT" ] "IN" "(" value { "," value } ")"
+//                       | "IS" [ "NOT" ] "NULL"
+//                       | [ "NOT" ] "LIKE" string )
+//   operator   = "=" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+//   value      = string | [ "-" ] number | "TRUE" | "FALSE"
+//
+// Values are kept as string, int64, float64 or bool.
+
+const (
+	FILTER_AND = "AND"
+	FILTER_OR  = "OR"
+	FILTER_RAW = "RAW"
+)
+
+var rawFilterHint = "native conditions have to be wrapped in " + FILTER_RAW + "(...)"
+
+var filterKeywords = []string{"AND", "OR", "NOT", "IN", "IS", "NULL", "LIKE", "TRUE", "FALSE", FILTER_RAW}
+
+var filterOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<>": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// Filter is a database agnostic condition, which every
+// database translates to its native query language.
+type Filter interface {
+	String() string
+}
+
+// Comparison compares a column with a value.
+type Comparison struct {
+	Column   string
+	Operator string
+	Value    interface{}
+}
+
+func (f *Comparison) String() string {
+	return quoteColumn(f.Column) + " " + f.Operator + " " + formatValue(f.Value)
+}
+
+// LogicalFilter joins its operands with AND or OR.
+type LogicalFilter struct {
+	Operator string
+	Operands []Filter
+}
+
+func (f *LogicalFilter) String() string {
+	operands := make([]string, len(f.Operands))
+	for i, operand := range f.Operands {
+		operands[i] = operand.String()
+	}
+	return "(" + strings.Join(operands, " "+f.Operator+" ") + ")"
+}
+
+// NotFilter negates its operand.
+type NotFilter struct {
+	Operand Filter
+}
+
+func (f *NotFilter) String() string {
+	return "NOT " + f.Operand.String()
+}
+
+// InFilter checks whether a column's value is one of the given values.
+type InFilter struct {
+	Column  string
+	Values  []interface{}
+	Negated bool
+}
+
+func (f *InFilter) String() string {
+	values := make([]string, len(f.Values))
+	for i, value := range f.Values {
+		values[i] = formatValue(value)
+	}
+	return quoteColumn(f.Column) + negation(f.Negated, " NOT") + " IN (" + strings.Join(values, ", ") + ")"
+}
+
+// NullFilter checks whether a column's value is null.
+type NullFilter struct {
+	Column  string
+	Negated bool
+}
+
+func (f *NullFilter) String() string {
+	return quoteColumn(f.Column) + " IS" + negation(f.Negated, " NOT") + " NULL"
+}
+
+// LikeFilter matches a column's value against a pattern,
+// in which "%" matches any sequence of characters and "_" any single character.
+type LikeFilter struct {
+	Column  string
+	Pattern string
+	Negated bool
+}
+
+func (f *LikeFilter) String() string {
+	return quoteColumn(f.Column) + negation(f.Negated, " NOT") + " LIKE " + formatValue(f.Pattern)
+}
+
+// RawFilter is a query in the database's native language passed as is,
+// e.g. SQL for PostgreSQL or Extended JSON for MongoDB.
+type RawFilter struct {
+	Query string
+}
+
+func (f *RawFilter) String() string {
+	return FILTER_RAW + "(" + f.Query + ")"
+}
+
+// FilterColumns returns the columns used in a filter's conditions.
+// Raw filters are opaque, so they don't return any columns.
+func FilterColumns(filter Filter) []string {
+	switch f := filter.(type) {
+	case *Comparison:
+		return []string{f.Column}
+	case *InFilter:
+		return []string{f.Column}
+	case *NullFilter:
+		return []string{f.Column}
+	case *LikeFilter:
+		return []string{f.Column}
+	case *NotFilter:
+		return FilterColumns(f.Operand)
+	case *LogicalFilter:
+		columns := make([]string, 0)
+		for _, operand := range f.Operands {
+			columns = append(columns, FilterColumns(operand)...)
+		}
+		return columns
+	}
+	return nil
+}
+
+func negation(negated bool, keyword string) string {
+	if negated {
+		return keyword
+	}
+	return ""
+}
+
+func quoteColumn(column string) string {
+	for _, keyword := range filterKeywords {
+		if strings.EqualFold(column, keyword) {
+			return `"` + column + `"`
+		}
+	}
+	return quoteName(column)
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	}
+	return ""
+}
+
+// parseFilter parses the tokens of a WHERE clause.
+func (p *parser) parseFilter() (Filter, error) {
+	if tok := p.peek(); tok.is(FILTER_RAW) && p.tokens[p.pos+1].typ == TOKEN_LPAREN {
+		return p.parseRawFilter()
+	}
+
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != TOKEN_EOF {
+		return nil, p.errorf(tok, "unexpected %s in the conditions (%s)", tok, rawFilterHint)
+	}
+	return filter, nil
+}
+
+func (p *parser) parseRawFilter() (Filter, error) {
+	rawTok := p.advance()
+	lparen := p.advance()
+
+	// The where clause's brackets are balanced, so the matching parenthesis is always found.
+	depth := 1
+	for depth > 0 {
+		switch tok := p.advance(); tok.typ {
+		case TOKEN_LPAREN:
+			depth++
+		case TOKEN_RPAREN:
+			depth--
+		case TOKEN_EOF:
+			return nil, p.errorf(lparen, "%s is never closed", lparen)
+		}
+	}
+	rparen := p.tokens[p.pos-1]
+
+	query := strings.TrimSpace(p.src[lparen.end:rparen.pos])
+	if query == "" {
+		return nil, p.errorf(rawTok, "%s conditions can't be empty", FILTER_RAW)
+	}
+	if tok := p.peek(); tok.typ != TOKEN_EOF {
+		return nil, p.errorf(tok, "unexpected %s after %s conditions", tok, FILTER_RAW)
+	}
+	return &RawFilter{Query: query}, nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	return p.parseLogical(FILTER_OR, p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	return p.parseLogical(FILTER_AND, p.parseNot)
+}
+
+func (p *parser) parseLogical(operator string, parseOperand func() (Filter, error)) (Filter, error) {
+	first, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Filter{first}
+	for p.peek().is(operator) {
+		p.advance()
+		operand, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &LogicalFilter{Operator: operator, Operands: operands}, nil
+}
+
+func (p *parser) parseNot() (Filter, error) {
+	tok := p.peek()
+	if tok.is("NOT") {
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotFilter{Operand: operand}, nil
+	}
+
+	if tok.typ == TOKEN_LPAREN {
+		p.advance()
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if rparen := p.advance(); rparen.typ != TOKEN_RPAREN {
+			return nil, p.errorf(rparen, "expected \")\", got %s", rparen)
+		}
+		return filter, nil
+	}
+
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (Filter, error) {
+	columnTok := p.advance()
+	if !isColumnToken(columnTok) {
+		return nil, p.errorf(columnTok, "expected a column name, got %s", columnTok)
+	}
+	column := columnTok.val
+
+	tok := p.advance()
+	if operator, found := filterOperators[tok.val]; found && tok.typ == TOKEN_OPERATOR {
+		valueTok := p.peek()
+		if valueTok.is("NULL") {
+			return nil, p.errorf(valueTok, "NULL can't be compared with \"%s\", use IS NULL or IS NOT NULL instead", tok.val)
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Column: column, Operator: operator, Value: value}, nil
+	}
+
+	if tok.is("IS") {
+		negated := p.peek().is("NOT")
+		if negated {
+			p.advance()
+		}
+		if null := p.advance(); !null.is("NULL") {
+			return nil, p.errorf(null, "expected NULL, got %s", null)
+		}
+		return &NullFilter{Column: column, Negated: negated}, nil
+	}
+
+	negated := tok.is("NOT")
+	if negated {
+		tok = p.advance()
+	}
+	switch {
+	case tok.is("IN"):
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		return &InFilter{Column: column, Values: values, Negated: negated}, nil
+	case tok.is("LIKE"):
+		patternTok := p.advance()
+		if patternTok.typ != TOKEN_STRING {
+			return nil, p.errorf(patternTok, "LIKE has to be followed by a pattern in single quotes, got %s", patternTok)
+		}
+		return &LikeFilter{Column: column, Pattern: patternTok.val, Negated: negated}, nil
+	}
+
+	return nil, p.errorf(tok, "expected a comparison operator, IN, IS or LIKE after column \"%s\", got %s (%s)", column, tok, rawFilterHint)
+}
+
+func (p *parser) parseValueList() ([]interface{}, error) {
+	if lparen := p.advance(); lparen.typ != TOKEN_LPAREN {
+		return nil, p.errorf(lparen, "IN has to be followed by a list of values in parentheses, got %s", lparen)
+	}
+
+	values := make([]interface{}, 0)
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.advance()
+		if tok.typ == TOKEN_RPAREN {
+			return values, nil
+		}
+		if tok.typ != TOKEN_COMMA {
+			return nil, p.errorf(tok, "expected \",\" or \")\" in the list of values, got %s", tok)
+		}
+	}
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	tok := p.advance()
+	switch {
+	case tok.typ == TOKEN_STRING:
+		return tok.val, nil
+	case tok.typ == TOKEN_NUMBER:
+		return parseNumber(tok.val), nil
+	case tok.typ == TOKEN_OTHER && tok.val == "-":
+		number := p.advance()
+		if number.typ != TOKEN_NUMBER {
+			return nil, p.errorf(number, "expected a number after \"-\", got %s", number)
+		}
+		return parseNumber("-" + number.val), nil
+	case tok.is("TRUE"):
+		return true, nil
+	case tok.is("FALSE"):
+		return false, nil
+	}
+	return nil, p.errorf(tok, "expected a value, got %s", tok)
+}
+
+// parseNumber keeps integers as int64, other numbers become float64.
+func parseNumber(number string) interface{} {
+	if integer, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return integer
+	}
+	float, _ := strconv.ParseFloat(number, 64)
+	return float
+}
+
+func isColumnToken(tok token) bool {
+	if tok.typ == TOKEN_QUOTED_IDENT {
+		return tok.val != ""
+	}
+	if tok.typ != TOKEN_IDENT {
+		return false
+	}
+	for _, keyword := range filterKeywords {
+		if tok.is(keyword) {
+			return false
+		}
+	}
+	return true
+}
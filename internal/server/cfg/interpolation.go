@@ -0,0 +1,99 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolationError is returned if a config value references
+// an environment variable, which isn't set and has no default.
+type interpolationError struct {
+	node   *yaml.Node
+	errMsg string
+}
+
+func (e *interpolationError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.node.Line, e.errMsg)
+}
+
+// interpolateNode replaces environment variable references in all scalar
+// values of a YAML node tree. Mapping keys are left as they are.
+func interpolateNode(node *yaml.Node) []*interpolationError {
+	errs := make([]*interpolationError, 0)
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			errs = append(errs, interpolateNode(child)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, interpolateNode(node.Content[i])...)
+		}
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return append(errs, &interpolationError{node: node, errMsg: err.Error()})
+		}
+		if value != node.Value {
+			node.Value = value
+			// Plain scalars get their type resolved again, so that e.g. a port can be an env variable.
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+	return errs
+}
+
+// interpolate replaces ${NAME} and ${NAME:-default} with the value of
+// the environment variable NAME. "$$" is an escaped dollar sign.
+func interpolate(value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		switch value[i+1] {
+		case '$':
+			result.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("environment variable reference \"%s\" isn't closed with \"}\"", value[i:])
+			}
+			reference := value[i+2 : i+end]
+			name, defaultValue, hasDefault := reference, "", false
+			if separator := strings.Index(reference, ":-"); separator != -1 {
+				name, defaultValue, hasDefault = reference[:separator], reference[separator+2:], true
+			}
+			if name == "" {
+				return "", fmt.Errorf("environment variable name is missing in \"${%s}\"", reference)
+			}
+
+			envValue, found := os.LookupEnv(name)
+			switch {
+			case found && (envValue != "" || !hasDefault):
+				result.WriteString(envValue)
+			case hasDefault:
+				result.WriteString(defaultValue)
+			default:
+				return "", fmt.Errorf("environment variable \"%s\" isn't set", name)
+			}
+			i += end
+		default:
+			result.WriteByte('$')
+		}
+	}
+	return result.String(), nil
+}
@@ -0,0 +1,236 @@
+package cfg
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	TOKEN_EOF tokenType = iota
+	TOKEN_IDENT
+	TOKEN_QUOTED_IDENT
+	TOKEN_STRING
+	TOKEN_NUMBER
+	TOKEN_LBRACKET
+	TOKEN_RBRACKET
+	TOKEN_LPAREN
+	TOKEN_RPAREN
+	TOKEN_LBRACE
+	TOKEN_RBRACE
+	TOKEN_DOT
+	TOKEN_COMMA
+	TOKEN_OPERATOR
+	TOKEN_OTHER
+)
+
+// token is a single lexeme of the link language.
+// pos and end are byte offsets of the token in the source string.
+type token struct {
+	typ tokenType
+	val string
+	pos int
+	end int
+}
+
+// is checks whether the token is the given keyword.
+// Keywords are case insensitive.
+func (t token) is(keyword string) bool {
+	return t.typ == TOKEN_IDENT && strings.EqualFold(t.val, keyword)
+}
+
+func (t token) String() string {
+	if t.typ == TOKEN_EOF {
+		return "end of input"
+	}
+	return "\"" + t.val + "\""
+}
+
+type lexerError struct {
+	errMsg string
+	pos    int
+}
+
+func (e *lexerError) Error() string {
+	return e.errMsg
+}
+
+var punctuation = map[rune]tokenType{
+	'[': TOKEN_LBRACKET,
+	']': TOKEN_RBRACKET,
+	'(': TOKEN_LPAREN,
+	')': TOKEN_RPAREN,
+	'{': TOKEN_LBRACE,
+	'}': TOKEN_RBRACE,
+	'.': TOKEN_DOT,
+	',': TOKEN_COMMA,
+}
+
+// lexer splits a link, mapping or filter into tokens.
+type lexer struct {
+	src    string
+	pos    int
+	tokens []token
+}
+
+// tokenize returns all tokens of the source string followed by an EOF token.
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: src}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		l.tokens = append(l.tokens, tok)
+		if tok.typ == TOKEN_EOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peekRune(offset int) (rune, int) {
+	if l.pos+offset >= len(l.src) {
+		return utf8.RuneError, 0
+	}
+	return utf8.DecodeRuneInString(l.src[l.pos+offset:])
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r, size := l.peekRune(0)
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+	if l.pos >= len(l.src) {
+		return token{typ: TOKEN_EOF, pos: len(l.src), end: len(l.src)}, nil
+	}
+
+	start := l.pos
+	r, size := l.peekRune(0)
+
+	switch {
+	case r == '"':
+		return l.quoted(TOKEN_QUOTED_IDENT, '"', true)
+	case r == '\'':
+		return l.quoted(TOKEN_STRING, '\'', false)
+	case isIdentRune(r):
+		return l.word(), nil
+	case strings.ContainsRune("=!<>", r):
+		l.pos += size
+		if next, nextSize := l.peekRune(0); next == '=' || (r == '<' && next == '>') {
+			l.pos += nextSize
+		}
+		return l.emit(TOKEN_OPERATOR, start), nil
+	}
+
+	l.pos += size
+	if typ, found := punctuation[r]; found {
+		return l.emit(typ, start), nil
+	}
+	return l.emit(TOKEN_OTHER, start), nil
+}
+
+func (l *lexer) emit(typ tokenType, start int) token {
+	return token{typ: typ, val: l.src[start:l.pos], pos: start, end: l.pos}
+}
+
+// word lexes identifiers and numbers. A fraction is only
+// consumed if the number isn't a part of a dotted name like "node.1".
+func (l *lexer) word() token {
+	start := l.pos
+	for l.pos < len(l.src) {
+		r, size := l.peekRune(0)
+		if !isIdentRune(r) {
+			break
+		}
+		l.pos += size
+	}
+
+	val := l.src[start:l.pos]
+	if !isDigits(val) {
+		return l.emit(TOKEN_IDENT, start)
+	}
+
+	afterDot := len(l.tokens) > 0 && l.tokens[len(l.tokens)-1].typ == TOKEN_DOT
+	if dot, _ := l.peekRune(0); dot == '.' && !afterDot {
+		if digit, _ := l.peekRune(1); digit >= '0' && digit <= '9' {
+			l.pos++
+			for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+				l.pos++
+			}
+		}
+	}
+	return l.emit(TOKEN_NUMBER, start)
+}
+
+// quoted lexes a quoted identifier or string. The quote character can be
+// escaped by doubling it and, if backslash escapes are allowed, with a backslash.
+// Strings follow the SQL rules, so backslashes in LIKE patterns are kept.
+func (l *lexer) quoted(typ tokenType, quote rune, backslash bool) (token, error) {
+	start := l.pos
+	l.pos++
+
+	var value strings.Builder
+	for l.pos < len(l.src) {
+		r, size := l.peekRune(0)
+		switch {
+		case r == '\\' && backslash:
+			escaped, escapedSize := l.peekRune(size)
+			if escapedSize == 0 {
+				return token{}, &lexerError{errMsg: "unterminated quote", pos: start}
+			}
+			value.WriteRune(escaped)
+			l.pos += size + escapedSize
+		case r == quote:
+			if next, _ := l.peekRune(size); next == quote {
+				value.WriteRune(quote)
+				l.pos += 2 * size
+				continue
+			}
+			l.pos += size
+			return token{typ: typ, val: value.String(), pos: start, end: l.pos}, nil
+		default:
+			value.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return token{}, &lexerError{errMsg: "unterminated quote", pos: start}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isPlainName checks whether a name can be written without quotes.
+func isPlainName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !isIdentRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// columnOf converts a byte offset into a 1-based column counted in characters.
+func columnOf(src string, pos int) int {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	return utf8.RuneCountInString(src[:pos]) + 1
+}
@@ -0,0 +1,46 @@
+package cfg
+
+// Masking functions anonymizing values written to target columns.
+// MASK_HASH replaces a value with its salted SHA-256 hash, MASK_REDACT replaces
+// its characters with asterisks, MASK_FAKE_NAME and MASK_FAKE_EMAIL generate
+// a fake name or email seeded by the value, so the same value always gets
+// the same replacement, MASK_TRUNCATE_DATE truncates times to a unit.
+const (
+	MASK_HASH          = "hash"
+	MASK_REDACT        = "redact"
+	MASK_FAKE_NAME     = "fake_name"
+	MASK_FAKE_EMAIL    = "fake_email"
+	MASK_TRUNCATE_DATE = "truncate_date"
+)
+
+// Units times are truncated to by MASK_TRUNCATE_DATE.
+const (
+	TRUNCATE_YEAR  = "year"
+	TRUNCATE_MONTH = "month"
+	TRUNCATE_DAY   = "day"
+)
+
+var maskFunctions = []string{MASK_HASH, MASK_REDACT, MASK_FAKE_NAME, MASK_FAKE_EMAIL, MASK_TRUNCATE_DATE}
+var truncateUnits = []string{TRUNCATE_YEAR, TRUNCATE_MONTH, TRUNCATE_DAY}
+
+// Mask anonymizes the values written to a target column,
+// e.g. when production data is synchronized to a test database.
+type Mask struct {
+	// Column is the masked target column, e.g. "node.column".
+	Column   string `yaml:"column"`
+	Function string `yaml:"function"`
+	// Salt is mixed into hashes and the seeds of fake values.
+	Salt string `yaml:"salt,omitempty"`
+	// Keep is the number of trailing characters left by MASK_REDACT.
+	Keep int `yaml:"keep,omitempty"`
+	// Unit is the unit times are truncated to by MASK_TRUNCATE_DATE.
+	Unit string `yaml:"unit,omitempty"`
+}
+
+// GetUnit returns the unit of truncated times, which defaults to TRUNCATE_DAY.
+func (m *Mask) GetUnit() string {
+	if m.Unit == "" {
+		return TRUNCATE_DAY
+	}
+	return m.Unit
+}
@@ -5,4 +5,28 @@ type NodeConfig struct {
 	Database string `yaml:"database"`
 	Table    string `yaml:"table"`
 	Key      string `yaml:"key"`
+	// Children are embedded in the node's records as arrays of documents.
+	Children []ChildConfig `yaml:"children,omitempty"`
+}
+
+// ChildConfig declares a child table, whose records reference the node's records
+// by the ParentKey column holding the node's key. A record's children are embedded
+// in it under Name, sorted by their Key. Columns limits the embedded columns,
+// all columns except the parent key are embedded by default.
+type ChildConfig struct {
+	Name      string   `yaml:"name"`
+	Table     string   `yaml:"table"`
+	ParentKey string   `yaml:"parent_key"`
+	Key       string   `yaml:"key"`
+	Columns   []string `yaml:"columns,omitempty"`
+}
+
+// GetChild returns the child embedded under the given name or nil if there isn't one.
+func (n *NodeConfig) GetChild(name string) *ChildConfig {
+	for i := range n.Children {
+		if n.Children[i].Name == name {
+			return &n.Children[i]
+		}
+	}
+	return nil
 }
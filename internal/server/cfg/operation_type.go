@@ -3,5 +3,7 @@ package cfg
 const (
 	OPERATION_INSERT string = "insert"
 	OPERATION_UPDATE        = "update"
+	OPERATION_UPSERT        = "upsert"
 	OPERATION_IDLE          = "idle"
+	OPERATION_FAILED        = "failed"
 )
@@ -2,10 +2,10 @@ package cfg
 
 import (
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
 
-	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 )
 
 const (
@@ -13,20 +13,54 @@ const (
 	WHERE_CLAUSE = "WHERE"
 )
 
+// Grammar of links and mappings:
+//
+//   link     = endpoint "TO" endpoint
+//   endpoint = "[" ref [ "WHERE" conditions ] "]"
+//   mapping  = ref "TO" ref
+//   ref      = name "." name { "." name | "[" ( "*" | digits ) "]" }
+//   name     = identifier | number | quoted identifier
+//
+// Keywords are case insensitive. Brackets, parentheses and braces in the
+// conditions have to be balanced and quotes closed, so a "]" inside of a string
+// doesn't end the clause. The conditions themselves are described in filter.go.
+
 type linkParserError struct {
 	errMsg string
+	input  string
+	column int
 }
 
 func (e *linkParserError) Error() string {
-	return fmt.Sprintf("[link parser] %s", e.errMsg)
+	return fmt.Sprintf("[link parser] column %d: %s\nerror in: %s", e.column, e.errMsg, e.input)
+}
+
+func (e *linkParserError) Category() apperr.Category {
+	return apperr.CONFIG
+}
+
+// Position returns the 1-based column in the link the error occurred at.
+func (e *linkParserError) Position() int {
+	return e.column
 }
 
 type mappingParserError struct {
 	errMsg string
+	input  string
+	column int
 }
 
 func (e *mappingParserError) Error() string {
-	return fmt.Sprintf("[mapping parser] %s", e.errMsg)
+	return fmt.Sprintf("[mapping parser] column %d: %s\nerror in: %s", e.column, e.errMsg, e.input)
+}
+
+func (e *mappingParserError) Category() apperr.Category {
+	return apperr.CONFIG
+}
+
+// Position returns the 1-based column in the mapping the error occurred at.
+func (e *mappingParserError) Position() int {
+	return e.column
 }
 
 type matcherParserError struct {
@@ -37,197 +71,299 @@ func (e *matcherParserError) Error() string {
 	return fmt.Sprintf("['match by' parser] %s", e.errMsg)
 }
 
-// ParseLink uses regexp to split the link string into smaller parts.
-func ParseLink(link string) (map[string]string, error) {
-	result := make(map[string]string)
-	ptrn := `(?iU)^\s*` +
-		`\[(?P<` + PSUBEXP_SOURCE_NODE + `>[^\.,\s]+)\.(?P<` + PSUBEXP_SOURCE_COLUMN + `>[^\.,\s]+|"[^\.,]+")(\s+)?(?P<` + PSUBEXP_SOURCE_WHERE + `>` + WHERE_CLAUSE + `\s+[^\s]+.+)?\]` +
-		`\s+` + TO_CLAUSE + `\s+` +
-		`\[(?P<` + PSUBEXP_TARGET_NODE + `>[^\.,\s]+)\.(?P<` + PSUBEXP_TARGET_COLUMN + `>[^\.,\s]+|"[^\.,]+")(\s+)?(?P<` + PSUBEXP_TARGET_WHERE + `>` + WHERE_CLAUSE + `\s+[^\s]+.+)?\]` +
-		`\s*$`
-	compiledPtrn := regexp.MustCompile(ptrn)
-	matches := compiledPtrn.FindStringSubmatch(link)
-	subNames := compiledPtrn.SubexpNames()
+func (e *matcherParserError) Category() apperr.Category {
+	return apperr.CONFIG
+}
 
-	if len(matches) == 0 {
-		return nil, validateLink(link)
-	}
+// syntaxError is returned by the parser before it gets
+// wrapped in an error specific to the parsed statement.
+type syntaxError struct {
+	errMsg string
+	pos    int
+}
 
-	for i, match := range matches {
-		// Skip the first, empty element.
-		if i == 0 {
-			continue
-		}
+func (e *syntaxError) Error() string {
+	return e.errMsg
+}
 
-		if util.StringSliceContains([]string{PSUBEXP_SOURCE_WHERE, PSUBEXP_TARGET_WHERE}, subNames[i]) {
-			parsedWhere := ParseLinkWhere(match)
-			result[subNames[i]] = parsedWhere
-		} else {
-			result[subNames[i]] = match
-		}
+// parser is a recursive descent parser of links and mappings.
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func newParser(src string) (*parser, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		lexErr := err.(*lexerError)
+		return nil, &syntaxError{errMsg: lexErr.errMsg, pos: lexErr.pos}
 	}
-	result["cmd"] = link
+	return &parser{src: src, tokens: tokens}, nil
+}
 
-	return result, nil
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
 }
 
-func validateLink(link string) error {
-	errorsArr := make([]string, 0)
-	errorsArr = append(errorsArr, "error in: "+link)
-	var err error = nil
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != TOKEN_EOF {
+		p.pos++
+	}
+	return tok
+}
 
-	linkTrimmed := strings.Trim(link, " ")
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &syntaxError{errMsg: fmt.Sprintf(format, args...), pos: tok.pos}
+}
 
-	// Source part of the link.
-	sourcePartPtrn := regexp.MustCompile(`^\[.+\].*`)
-	sourcePartPtrnMatched := sourcePartPtrn.MatchString(linkTrimmed)
-	if !sourcePartPtrnMatched {
-		errorsArr = append(errorsArr, "a link has to start with a source in square brackets")
-	}
-	sourceWherePtrn := regexp.MustCompile(`^\[.+\s+` + WHERE_CLAUSE + `\s*\]\s+` + TO_CLAUSE + `.+`)
-	if sourcePartPtrnMatched && sourceWherePtrn.MatchString(linkTrimmed) {
-		errorsArr = append(errorsArr, "where clause in the source has to be followed by one or more conditions")
-	}
-	sourceColumnPtrn := regexp.MustCompile(`^\[([^\.,\s]+)\.([^\.,\s]+|"[^\.,]+").*\]\s+` + TO_CLAUSE + `.+`)
-	if sourcePartPtrnMatched && !sourceColumnPtrn.MatchString(linkTrimmed) {
-		errorsArr = append(errorsArr, "source node or column name is missing")
+func (p *parser) expectEOF(what string) error {
+	if tok := p.peek(); tok.typ != TOKEN_EOF {
+		return p.errorf(tok, "unexpected %s after the %s", tok, what)
 	}
+	return nil
+}
 
-	// Middle part of the link.
-	middlePartPtrn := regexp.MustCompile(`^\[.+\]\s+` + TO_CLAUSE + `\s+\[.+\]$`)
-	if !middlePartPtrn.MatchString(linkTrimmed) {
-		errorsArr = append(errorsArr, "there has to be a '"+TO_CLAUSE+"' keyword between the source and target")
+func (p *parser) parseLink() (*LinkStmt, error) {
+	source, err := p.parseEndpoint("source")
+	if err != nil {
+		return nil, err
 	}
-
-	// Target part of the link.
-	targetPartPtrn := regexp.MustCompile(`.*\[.+\]$`)
-	targetPartPtrnMatched := targetPartPtrn.MatchString(linkTrimmed)
-	if !targetPartPtrnMatched {
-		errorsArr = append(errorsArr, "a link has to end with a target in square brackets")
+	if tok := p.advance(); !tok.is(TO_CLAUSE) {
+		return nil, p.errorf(tok, "there has to be a '%s' keyword between the source and target, got %s", TO_CLAUSE, tok)
 	}
-	targetWherePtrn := regexp.MustCompile(`.*` + TO_CLAUSE + `\s+\[.+\s+` + WHERE_CLAUSE + `\s*\]$`)
-	if targetPartPtrnMatched && targetWherePtrn.MatchString(linkTrimmed) {
-		errorsArr = append(errorsArr, "where clause in the target has to be followed by one or more conditions")
+	target, err := p.parseEndpoint("target")
+	if err != nil {
+		return nil, err
 	}
-	targetColumnPtrn := regexp.MustCompile(`.+\[([^\.,\s]+)\.([^\.,\s]+|"[^\.,]+").*\]\s*$`)
-	if sourcePartPtrnMatched && !targetColumnPtrn.MatchString(linkTrimmed) {
-		errorsArr = append(errorsArr, "target node or column name is missing")
+	if err := p.expectEOF("target"); err != nil {
+		return nil, err
 	}
 
-	// no specific erros found
-	if len(errorsArr) == 1 {
-		errorsArr = append(errorsArr, "there's a syntax error in the link")
+	return &LinkStmt{Cmd: p.src, Source: source, Target: target}, nil
+}
+
+func (p *parser) parseEndpoint(role string) (*LinkEndpoint, error) {
+	lbracket := p.advance()
+	if lbracket.typ != TOKEN_LBRACKET {
+		return nil, p.errorf(lbracket, "the %s has to be in square brackets, expected \"[\", got %s", role, lbracket)
 	}
 
-	if len(errorsArr) > 1 {
-		errorsArrJoined := strings.Join(errorsArr, "\n")
-		err = &linkParserError{errMsg: errorsArrJoined}
+	ref, err := p.parseColumnRef(role)
+	if err != nil {
+		return nil, err
 	}
-	return err
-}
+	endpoint := &LinkEndpoint{Ref: ref, Pos: columnOf(p.src, lbracket.pos)}
 
-// ParseLinkWhere uses regexp to split the link's where clause into smaller parts.
-func ParseLinkWhere(where string) string {
-	ptrn := `(?iU)^\s*` + WHERE_CLAUSE + `\s+`
-	compiledPtrn := regexp.MustCompile(ptrn)
-	result := compiledPtrn.ReplaceAll([]byte(where), []byte(""))
-	resultAsString := string(result)
+	if p.peek().is(WHERE_CLAUSE) {
+		where, err := p.parseWhere(role)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.Where = where
+	}
 
-	return resultAsString
+	if rbracket := p.advance(); rbracket.typ != TOKEN_RBRACKET {
+		return nil, p.errorf(rbracket, "expected \"]\" or '%s' after the %s column, got %s", WHERE_CLAUSE, role, rbracket)
+	}
+	return endpoint, nil
 }
 
-// ParseMapping uses regexp to split the mapping string into smaller parts.
-func ParseMapping(mapping string) (map[string]string, error) {
-	result := make(map[string]string)
-	ptrn := `(?iU)^\s*` +
-		`(?P<` + PSUBEXP_SOURCE_NODE + `>[^\.,]+)\.(?P<` + PSUBEXP_SOURCE_COLUMN + `>[^\.,]+)` +
-		`\s+` + TO_CLAUSE + `\s+` +
-		`(?P<` + PSUBEXP_TARGET_NODE + `>[^\.,]+)\.(?P<` + PSUBEXP_TARGET_COLUMN + `>[^\.,]+)` +
-		`\s*$`
-	compiledPtrn := regexp.MustCompile(ptrn)
-	matches := compiledPtrn.FindStringSubmatch(mapping)
-	subNames := compiledPtrn.SubexpNames()
-
-	if len(matches) == 0 {
-		return nil, validateMapping(mapping)
+// parseWhere consumes all tokens up to the "]" closing the endpoint.
+func (p *parser) parseWhere(role string) (*WhereClause, error) {
+	whereTok := p.advance()
+	closers := map[tokenType]tokenType{
+		TOKEN_LBRACKET: TOKEN_RBRACKET,
+		TOKEN_LPAREN:   TOKEN_RPAREN,
+		TOKEN_LBRACE:   TOKEN_RBRACE,
 	}
+	openers := make([]token, 0)
+	first := p.pos
+
+	for {
+		tok := p.peek()
+		if tok.typ == TOKEN_EOF {
+			if len(openers) > 0 {
+				opener := openers[len(openers)-1]
+				return nil, p.errorf(opener, "%s is never closed", opener)
+			}
+			return nil, p.errorf(tok, "expected \"]\" at the end of the %s, got %s", role, tok)
+		}
+		if len(openers) == 0 && tok.typ == TOKEN_RBRACKET {
+			break
+		}
 
-	for i, match := range matches {
-		// Skip the first, empty element.
-		if i == 0 {
-			continue
+		switch tok.typ {
+		case TOKEN_LBRACKET, TOKEN_LPAREN, TOKEN_LBRACE:
+			openers = append(openers, tok)
+		case TOKEN_RBRACKET, TOKEN_RPAREN, TOKEN_RBRACE:
+			if len(openers) == 0 {
+				return nil, p.errorf(tok, "unexpected %s in the %s's where clause", tok, role)
+			}
+			opener := openers[len(openers)-1]
+			if closers[opener.typ] != tok.typ {
+				return nil, p.errorf(opener, "%s is never closed", opener)
+			}
+			openers = openers[:len(openers)-1]
 		}
-		result[subNames[i]] = removeQuotes(match)
+		p.advance()
 	}
 
-	return result, nil
-}
-
-func removeQuotes(match string) string {
-	result := match
-	targetColumnPtrn := regexp.MustCompile(`^".+"$`)
-	if len(match) > 0 && targetColumnPtrn.MatchString(match) {
-		result = match[1 : len(match)-1]
+	if p.pos == first {
+		return nil, p.errorf(whereTok, "where clause in the %s has to be followed by one or more conditions", role)
+	}
+	start := p.tokens[first].pos
+	end := p.tokens[p.pos-1].end
+
+	// The conditions are parsed on their own, as if the closing bracket was the end of input.
+	conditionTokens := make([]token, 0, p.pos-first+1)
+	conditionTokens = append(conditionTokens, p.tokens[first:p.pos]...)
+	conditionTokens = append(conditionTokens, token{typ: TOKEN_EOF, pos: p.peek().pos, end: p.peek().pos})
+	conditionParser := &parser{src: p.src, tokens: conditionTokens}
+	filter, err := conditionParser.parseFilter()
+	if err != nil {
+		return nil, err
 	}
-	return result
+
+	return &WhereClause{Raw: p.src[start:end], Filter: filter, Pos: columnOf(p.src, start)}, nil
 }
 
-func validateMapping(mapping string) error {
-	errorsArr := make([]string, 0)
-	errorsArr = append(errorsArr, "error in: "+mapping)
-	var err error = nil
+func (p *parser) parseColumnRef(role string) (*ColumnRef, error) {
+	nodeTok := p.advance()
+	node, ok := nameOf(nodeTok)
+	if !ok {
+		return nil, p.errorf(nodeTok, "%s node name is missing, got %s", role, nodeTok)
+	}
+	if dot := p.advance(); dot.typ != TOKEN_DOT {
+		return nil, p.errorf(dot, "expected \".\" between the %s node and column names, got %s", role, dot)
+	}
+	columnTok := p.advance()
+	column, ok := nameOf(columnTok)
+	if !ok {
+		return nil, p.errorf(columnTok, "%s column name is missing, got %s", role, columnTok)
+	}
+	path, err := p.parsePath(role)
+	if err != nil {
+		return nil, err
+	}
 
-	mappingTrimmed := strings.Trim(mapping, " ")
-	spacePtrn := regexp.MustCompile(`\s+`)
-	mappingSplit := spacePtrn.Split(mappingTrimmed, -1)
+	return &ColumnRef{Node: node, Column: column, Path: path, Pos: columnOf(p.src, nodeTok.pos)}, nil
+}
 
-	if len(mappingSplit) > 2 && mappingSplit[1] != TO_CLAUSE {
-		errorsArr = append(errorsArr, "there has to be a '"+TO_CLAUSE+"' keyword between the source and target nodes")
+// parsePath parses the fields and array indexes following a column.
+func (p *parser) parsePath(role string) ([]PathSegment, error) {
+	var path []PathSegment
+	for {
+		switch p.peek().typ {
+		case TOKEN_DOT:
+			p.advance()
+			fieldTok := p.advance()
+			field, ok := nameOf(fieldTok)
+			if !ok {
+				return nil, p.errorf(fieldTok, "%s field name is missing after \".\", got %s", role, fieldTok)
+			}
+			path = append(path, PathSegment{Field: field})
+		case TOKEN_LBRACKET:
+			p.advance()
+			indexTok := p.advance()
+			segment := PathSegment{IsIndex: true, Index: ANY_INDEX}
+			if indexTok.typ == TOKEN_NUMBER && isDigits(indexTok.val) {
+				index, err := strconv.Atoi(indexTok.val)
+				if err != nil {
+					return nil, p.errorf(indexTok, "%s array index %s is too big", role, indexTok)
+				}
+				segment.Index = index
+			} else if indexTok.val != "*" {
+				return nil, p.errorf(indexTok, "expected an array index or \"*\" in the %s path, got %s", role, indexTok)
+			}
+			if rbracket := p.advance(); rbracket.typ != TOKEN_RBRACKET {
+				return nil, p.errorf(rbracket, "expected \"]\" after the array index in the %s path, got %s", role, rbracket)
+			}
+			path = append(path, segment)
+		default:
+			return path, nil
+		}
 	}
-	if len(mappingSplit) == 2 && mappingSplit[0] == TO_CLAUSE {
-		errorsArr = append(errorsArr, "there has to be a source column before the '"+TO_CLAUSE+"' keyword")
+}
+
+func (p *parser) parseMapping() (*MappingStmt, error) {
+	source, err := p.parseColumnRef("source")
+	if err != nil {
+		return nil, err
 	}
-	if len(mappingSplit) == 2 && mappingSplit[1] == TO_CLAUSE {
-		errorsArr = append(errorsArr, "there has to be a target column after the '"+TO_CLAUSE+"' keyword")
+	if tok := p.advance(); !tok.is(TO_CLAUSE) {
+		return nil, p.errorf(tok, "there has to be a '%s' keyword between the source and target columns, got %s", TO_CLAUSE, tok)
 	}
-	if len(mappingSplit) > 3 {
-		errorsArr = append(errorsArr, "there's redundant data in the mapping")
+	target, err := p.parseColumnRef("target")
+	if err != nil {
+		return nil, err
 	}
-	if len(mappingSplit) < 3 && len(errorsArr) == 1 {
-		errorsArr = append(errorsArr, "there's too little data in the mapping")
+	if err := p.expectEOF("target column"); err != nil {
+		return nil, err
 	}
 
-	// no specific erros found
-	if len(errorsArr) == 1 {
-		errorsArr = append(errorsArr, "there's a syntax error in the mapping")
-	}
+	return &MappingStmt{Cmd: p.src, Source: source, Target: target}, nil
+}
 
-	if len(errorsArr) > 1 {
-		errorsArrJoined := strings.Join(errorsArr, "\n")
-		err = &mappingParserError{errMsg: errorsArrJoined}
+// nameOf returns the name a token represents, if it can be used as one.
+func nameOf(tok token) (string, bool) {
+	switch tok.typ {
+	case TOKEN_IDENT, TOKEN_NUMBER:
+		return tok.val, true
+	case TOKEN_QUOTED_IDENT:
+		return tok.val, tok.val != ""
 	}
-	return err
+	return "", false
 }
 
-// ParseIdsMatcherMethod prepares "ids" method's arguments.
-func ParseIdsMatcherMethod(args []string) ([][]string, error) {
-	argsSplt := make([][]string, 0)
-	for _, arg := range args {
-		argSplt := strings.Split(arg, ".")
-		argsSplt = append(argsSplt, argSplt)
+// ParseLink parses a link string into a LinkStmt.
+func ParseLink(link string) (*LinkStmt, error) {
+	p, err := newParser(link)
+	if err == nil {
+		var stmt *LinkStmt
+		stmt, err = p.parseLink()
+		if err == nil {
+			return stmt, nil
+		}
 	}
+	synErr := err.(*syntaxError)
+	return nil, &linkParserError{errMsg: synErr.errMsg, input: link, column: columnOf(link, synErr.pos)}
+}
 
-	validationErr := validateIdsMatcherMethod(args, argsSplt)
-	if validationErr != nil {
-		return nil, validationErr
+// ParseMapping parses a mapping string into a MappingStmt.
+func ParseMapping(mapping string) (*MappingStmt, error) {
+	p, err := newParser(mapping)
+	if err == nil {
+		var stmt *MappingStmt
+		stmt, err = p.parseMapping()
+		if err == nil {
+			return stmt, nil
+		}
 	}
+	synErr := err.(*syntaxError)
+	return nil, &mappingParserError{errMsg: synErr.errMsg, input: mapping, column: columnOf(mapping, synErr.pos)}
+}
 
-	return argsSplt, nil
+// ParseColumnRef parses a single "node.column" reference.
+func ParseColumnRef(ref string) (*ColumnRef, error) {
+	p, err := newParser(ref)
+	if err != nil {
+		return nil, err
+	}
+	columnRef, err := p.parseColumnRef("referenced")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF("column"); err != nil {
+		return nil, err
+	}
+	return columnRef, nil
 }
 
-func validateIdsMatcherMethod(args []string, argsSplt [][]string) error {
+// ParseIdsMatcherMethod prepares "ids" method's arguments.
+func ParseIdsMatcherMethod(args []string) ([]*ColumnRef, error) {
 	errorsArr := make([]string, 0)
-	var err error = nil
 
 	if len(args) > 2 {
 		errorsArr = append(errorsArr, "too many arguments given for this match method")
@@ -235,16 +371,30 @@ func validateIdsMatcherMethod(args []string, argsSplt [][]string) error {
 	if len(args) < 2 {
 		errorsArr = append(errorsArr, "too few arguments given for this match method")
 	}
-	if len(args) == 2 && (len(argsSplt[0]) != 2 || len(argsSplt[1]) != 2) {
-		errorsArr = append(errorsArr, "each argument has to consist of node name and ID column name separated by a dot")
+	if len(errorsArr) > 0 {
+		return nil, &matcherParserError{errMsg: strings.Join(errorsArr, "\n")}
+	}
+
+	refs := make([]*ColumnRef, 0, len(args))
+	for _, arg := range args {
+		ref, err := ParseColumnRef(arg)
+		if err != nil {
+			errorsArr = append(errorsArr, "each argument has to consist of node name and ID column name separated by a dot: "+err.Error())
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	for _, ref := range refs {
+		if len(ref.Path) > 0 {
+			errorsArr = append(errorsArr, "external ID column "+ref.String()+" can't be a nested path")
+		}
 	}
-	if len(errorsArr) == 0 && argsSplt[0][0] == argsSplt[1][0] {
+	if len(errorsArr) == 0 && refs[0].Node == refs[1].Node {
 		errorsArr = append(errorsArr, "\"ids\" match method accepts only external ID column names from different nodes")
 	}
 
 	if len(errorsArr) > 0 {
-		errorsArrJoined := strings.Join(errorsArr, "\n")
-		err = &matcherParserError{errMsg: errorsArrJoined}
+		return nil, &matcherParserError{errMsg: strings.Join(errorsArr, "\n")}
 	}
-	return err
+	return refs, nil
 }
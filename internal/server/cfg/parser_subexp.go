@@ -1,10 +0,0 @@
-package cfg
-
-const (
-	PSUBEXP_SOURCE_NODE   string = "sourceNode"
-	PSUBEXP_SOURCE_COLUMN        = "sourceColumn"
-	PSUBEXP_SOURCE_WHERE         = "sourceWhere"
-	PSUBEXP_TARGET_NODE          = "targetNode"
-	PSUBEXP_TARGET_COLUMN        = "targetColumn"
-	PSUBEXP_TARGET_WHERE         = "targetWhere"
-)
@@ -3,7 +3,9 @@ package cfg
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestParser(t *testing.T) {
@@ -30,3 +32,219 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLink(t *testing.T) {
+	cases := []struct {
+		link   string
+		source string
+		target string
+		where  string
+	}{
+		{`[films.title] TO [docs.Title]`, "films.title", "docs.Title", ""},
+		{`  [films.title where film_id > 30] to [docs."Title"]  `, "films.title", "docs.Title", "film_id > 30"},
+		{`[films.title WHERE tags = '[a]'] TO [docs.Title]`, "films.title", "docs.Title", "tags = '[a]'"},
+		{`[films."rental.rate" WHERE x IN (1, 2)] TO [docs.rate]`, `films."rental.rate"`, "docs.rate", ""},
+		{`[films.title] TO [docs.Title WHERE RAW({"tags": {"$in": ["a]", "b"]}})]`, "films.title", "docs.Title", ""},
+		{`[films.rating] TO [docs.meta.rating]`, "films.rating", "docs.meta.rating", ""},
+		{`[docs.tags[*]."first name" WHERE x = 1] TO [films.names[0]]`, `docs.tags[*]."first name"`, "films.names[0]", "x = 1"},
+	}
+
+	for _, c := range cases {
+		link, err := ParseLink(c.link)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", c.link, err)
+			continue
+		}
+		if link.Source.Ref.String() != c.source || link.Target.Ref.String() != c.target {
+			t.Errorf("wrong columns parsed from %s: %s, %s", c.link, link.Source.Ref, link.Target.Ref)
+		}
+		if c.where != "" && link.Source.Where.Raw != c.where {
+			t.Errorf("wrong where clause parsed from %s: %s", c.link, link.Source.Where.Raw)
+		}
+	}
+
+	link, _ := ParseLink(`[films.title] TO [docs.Title WHERE RAW({"tags": {"$in": ["a]", "b"]}})]`)
+	if raw, ok := link.Target.GetFilter().(*RawFilter); !ok || raw.Query != `{"tags": {"$in": ["a]", "b"]}}` {
+		t.Errorf("wrong raw filter parsed: %v", link.Target.GetFilter())
+	}
+}
+
+func TestParseLinkErrors(t *testing.T) {
+	cases := []struct {
+		link     string
+		position int
+		message  string
+	}{
+		{`films.title] TO [docs.Title]`, 1, "square brackets"},
+		{`[films.title WHERE] TO [docs.Title]`, 14, "one or more conditions"},
+		{`[films.title] [docs.Title]`, 15, "'TO' keyword"},
+		{`[films] TO [docs.Title]`, 7, "expected \".\""},
+		{`[films.title] TO [docs.Title WHERE (a = 1]`, 36, "never closed"},
+		{`[films.title] TO [docs.Title WHERE a = 'b]`, 40, "unterminated quote"},
+		{`[films.title] TO [docs.Title] x`, 31, "unexpected"},
+		{`[fïlms.tïtle] TO [docs.Title WHERE]`, 30, "one or more conditions"},
+		{`[films.title] TO [docs.tags[x]]`, 29, "array index"},
+		{`[films.title] TO [docs.tags[*]`, 31, "\"]\" or 'WHERE'"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseLink(c.link)
+		if err == nil {
+			t.Errorf("expected an error for %s", c.link)
+			continue
+		}
+		parserErr := err.(*linkParserError)
+		if parserErr.Position() != c.position || !strings.Contains(parserErr.errMsg, c.message) {
+			t.Errorf("expected \"%s\" at column %d for %s, got: %s", c.message, c.position, c.link, err)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	cases := []struct {
+		where    string
+		expected string
+	}{
+		{`film_id > 30 AND film_id <= 50`, `(film_id > 30 AND film_id <= 50)`},
+		{`a = 1 OR b = 2 AND NOT c = 'x'`, `(a = 1 OR (b = 2 AND NOT c = 'x'))`},
+		{`(a = 1 OR b <> 2) and "Rental Rate" >= 2.99`, `((a = 1 OR b != 2) AND "Rental Rate" >= 2.99)`},
+		{`rating not in ('G', 'it''s') AND x IS NOT NULL`, `(rating NOT IN ('G', 'it''s') AND x IS NOT NULL)`},
+		{`title LIKE 'A\_%' AND "not" = FALSE AND y = -3`, `(title LIKE 'A\_%' AND "not" = FALSE AND y = -3)`},
+		{`RAW(film_id % 2 = 0)`, `RAW(film_id % 2 = 0)`},
+	}
+
+	for _, c := range cases {
+		link, err := ParseLink("[films.title WHERE " + c.where + "] TO [docs.Title]")
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", c.where, err)
+			continue
+		}
+		if filter := link.Source.GetFilter(); filter.String() != c.expected {
+			t.Errorf("expected %s to be parsed as %s, got %s", c.where, c.expected, filter)
+		}
+	}
+
+	errorCases := []struct {
+		link     string
+		position int
+		message  string
+	}{
+		{`[films.title WHERE film_id % 2 = 0] TO [docs.Title]`, 28, "RAW"},
+		{`[films.title WHERE a = NULL] TO [docs.Title]`, 24, "IS NULL"},
+		{`[films.title WHERE a IN 1] TO [docs.Title]`, 25, "list of values"},
+		{`[films.title WHERE a = 1 AND] TO [docs.Title]`, 29, "column name"},
+		{`[films.title WHERE RAW() ] TO [docs.Title]`, 20, "can't be empty"},
+		{`[films.title WHERE RAW(a) b] TO [docs.Title]`, 27, "after RAW"},
+	}
+	for _, c := range errorCases {
+		_, err := ParseLink(c.link)
+		if err == nil {
+			t.Errorf("expected an error for %s", c.link)
+			continue
+		}
+		parserErr := err.(*linkParserError)
+		if parserErr.Position() != c.position || !strings.Contains(parserErr.errMsg, c.message) {
+			t.Errorf("expected \"%s\" at column %d for %s, got: %s", c.message, c.position, c.link, err)
+		}
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping(`  films."Rental Duration" TO docs.rental_duration `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Source.Column != "Rental Duration" || mapping.Target.Node != "docs" {
+		t.Errorf("wrong mapping parsed: %s", mapping)
+	}
+
+	cases := []struct {
+		mapping  string
+		position int
+	}{
+		{`films.title docs.Title`, 13},
+		{`films.title TO`, 15},
+		{`films.title TO docs.Title docs`, 27},
+		{`.title TO docs.Title`, 1},
+		{`films.title TO docs.meta.`, 26},
+	}
+	for _, c := range cases {
+		_, err := ParseMapping(c.mapping)
+		if err == nil {
+			t.Errorf("expected an error for %s", c.mapping)
+			continue
+		}
+		if position := err.(*mappingParserError).Position(); position != c.position {
+			t.Errorf("expected an error at column %d for %s, got: %s", c.position, c.mapping, err)
+		}
+	}
+}
+
+func FuzzParseLink(f *testing.F) {
+	f.Add(`[films.title] TO [docs.Title]`)
+	f.Add(`[films.title WHERE film_id > 30 AND rating IN ('G', 'PG')] TO [docs."Title" WHERE RAW({"_id": {"$lt": 3}})]`)
+	f.Add(`[films.title WHERE NOT (a IS NULL OR b NOT LIKE 'x\_%') AND c != -1.5] TO [docs.Title WHERE d = TRUE]`)
+	f.Add(`[films."a.b" where x = ']'] to [docs.c]`)
+	f.Add(`[films.title WHERE (a = 1] TO [docs.Title]`)
+	f.Add(`[docs.tags[*].name] TO [films."a.b"[0]]`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if !utf8.ValidString(input) {
+			return
+		}
+		link, err := ParseLink(input)
+		if err != nil {
+			if position := err.(*linkParserError).Position(); position < 1 || position > utf8.RuneCountInString(input)+1 {
+				t.Fatalf("error position %d out of range for %q", position, input)
+			}
+			return
+		}
+
+		// A parsed link written back as a string has to parse to the same link.
+		reparsed, err := ParseLink(link.String())
+		if err != nil {
+			t.Fatalf("failed to reparse %q (from %q): %s", link.String(), input, err)
+		}
+		if reparsed.String() != link.String() {
+			t.Fatalf("reparsed link %q differs from %q", reparsed.String(), link.String())
+		}
+
+		// So does a filter written back as a string.
+		if filter := link.Source.GetFilter(); filter != nil {
+			filterLink, err := ParseLink("[a.b WHERE " + filter.String() + "] TO [c.d]")
+			if err != nil {
+				t.Fatalf("failed to reparse filter %q (from %q): %s", filter.String(), input, err)
+			}
+			if filterLink.Source.GetFilter().String() != filter.String() {
+				t.Fatalf("reparsed filter %q differs from %q", filterLink.Source.GetFilter().String(), filter.String())
+			}
+		}
+	})
+}
+
+func FuzzParseMapping(f *testing.F) {
+	f.Add(`films.title TO docs.Title`)
+	f.Add(`films."Rental Duration" to docs.rental_duration`)
+	f.Add(`films.title docs.Title`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if !utf8.ValidString(input) {
+			return
+		}
+		mapping, err := ParseMapping(input)
+		if err != nil {
+			if position := err.(*mappingParserError).Position(); position < 1 || position > utf8.RuneCountInString(input)+1 {
+				t.Fatalf("error position %d out of range for %q", position, input)
+			}
+			return
+		}
+
+		reparsed, err := ParseMapping(mapping.String())
+		if err != nil {
+			t.Fatalf("failed to reparse %q (from %q): %s", mapping.String(), input, err)
+		}
+		if reparsed.String() != mapping.String() {
+			t.Fatalf("reparsed mapping %q differs from %q", reparsed.String(), mapping.String())
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package cfg
+
+import "time"
+
+// Defaults of adaptive rate limiting.
+const (
+	DEFAULT_ADAPTIVE_MAX_LATENCY        = 1 * time.Second
+	DEFAULT_ADAPTIVE_MAX_ERROR_RATE     = 0.1
+	DEFAULT_ADAPTIVE_MIN_OPS_PER_SECOND = 1.0
+)
+
+// RateLimit throttles writes. OpsPerSecond limits how many writes start per second
+// and MaxConcurrent how many run at the same time, zero means no limit.
+// Adaptive rate limiting lowers the rate when writes get slow or fail.
+type RateLimit struct {
+	OpsPerSecond  float64       `yaml:"ops_per_second,omitempty"`
+	MaxConcurrent int           `yaml:"max_concurrent,omitempty"`
+	Adaptive      *AdaptiveRate `yaml:"adaptive,omitempty"`
+}
+
+// AdaptiveRate holds the thresholds of adaptive rate limiting. When the average latency
+// of recent writes exceeds MaxLatency or the share of failed ones exceeds MaxErrorRate,
+// the rate is halved, but not below MinOpsPerSecond. Otherwise it's raised step by step
+// back to the configured rate.
+type AdaptiveRate struct {
+	MaxLatency      time.Duration `yaml:"max_latency,omitempty"`
+	MaxErrorRate    float64       `yaml:"max_error_rate,omitempty"`
+	MinOpsPerSecond float64       `yaml:"min_ops_per_second,omitempty"`
+}
+
+// GetMaxLatency returns the latency threshold, which defaults to DEFAULT_ADAPTIVE_MAX_LATENCY.
+func (a *AdaptiveRate) GetMaxLatency() time.Duration {
+	if a.MaxLatency <= 0 {
+		return DEFAULT_ADAPTIVE_MAX_LATENCY
+	}
+	return a.MaxLatency
+}
+
+// GetMaxErrorRate returns the error rate threshold, which defaults to DEFAULT_ADAPTIVE_MAX_ERROR_RATE.
+func (a *AdaptiveRate) GetMaxErrorRate() float64 {
+	if a.MaxErrorRate <= 0 {
+		return DEFAULT_ADAPTIVE_MAX_ERROR_RATE
+	}
+	return a.MaxErrorRate
+}
+
+// GetMinOpsPerSecond returns the lowest rate, which defaults to DEFAULT_ADAPTIVE_MIN_OPS_PER_SECOND.
+func (a *AdaptiveRate) GetMinOpsPerSecond() float64 {
+	if a.MinOpsPerSecond <= 0 {
+		return DEFAULT_ADAPTIVE_MIN_OPS_PER_SECOND
+	}
+	return a.MinOpsPerSecond
+}
@@ -0,0 +1,57 @@
+package cfg
+
+import "time"
+
+// Defaults of the retry policy.
+const (
+	DEFAULT_RETRY_ATTEMPTS    = 3
+	DEFAULT_RETRY_BACKOFF     = 500 * time.Millisecond
+	DEFAULT_RETRY_MAX_BACKOFF = 10 * time.Second
+)
+
+// DEFAULT_RETRY_ON lists the categories of errors, which are retried by default.
+// Databases classify lost connections, timeouts and deadlocks as connection errors.
+var DEFAULT_RETRY_ON = []string{"connection"}
+
+// Retry holds the policy of repeating failed writes. A write is attempted up to
+// Attempts times with pauses doubling from Backoff up to MaxBackoff, as long as its
+// errors belong to one of the RetryOn categories. Writes, which fail permanently,
+// are stored as dead letters.
+type Retry struct {
+	Attempts   int           `yaml:"attempts,omitempty"`
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+	RetryOn    []string      `yaml:"retry_on,omitempty"`
+}
+
+// GetAttempts returns the number of attempts, which defaults to DEFAULT_RETRY_ATTEMPTS.
+func (r *Retry) GetAttempts() int {
+	if r.Attempts <= 0 {
+		return DEFAULT_RETRY_ATTEMPTS
+	}
+	return r.Attempts
+}
+
+// GetBackoff returns the first pause, which defaults to DEFAULT_RETRY_BACKOFF.
+func (r *Retry) GetBackoff() time.Duration {
+	if r.Backoff <= 0 {
+		return DEFAULT_RETRY_BACKOFF
+	}
+	return r.Backoff
+}
+
+// GetMaxBackoff returns the longest pause, which defaults to DEFAULT_RETRY_MAX_BACKOFF.
+func (r *Retry) GetMaxBackoff() time.Duration {
+	if r.MaxBackoff <= 0 {
+		return DEFAULT_RETRY_MAX_BACKOFF
+	}
+	return r.MaxBackoff
+}
+
+// GetRetryOn returns the retried error categories, which default to DEFAULT_RETRY_ON.
+func (r *Retry) GetRetryOn() []string {
+	if len(r.RetryOn) == 0 {
+		return DEFAULT_RETRY_ON
+	}
+	return r.RetryOn
+}
@@ -0,0 +1,28 @@
+package cfg
+
+import (
+	"os"
+)
+
+// ServerConfig represents the optional server.yaml config file.
+type ServerConfig struct {
+	AutoResume bool `yaml:"auto_resume"`
+}
+
+// Validate data from the YAML file.
+func (s *ServerConfig) Validate() {}
+
+// GetServerConfig loads the server config from server.yaml file.
+// If the file doesn't exist, default values are used.
+func GetServerConfig() *ServerConfig {
+	var serverCfg ServerConfig = ServerConfig{
+		AutoResume: true,
+	}
+
+	if _, err := os.Stat(SERVER_CFG_PATH); os.IsNotExist(err) {
+		return &serverCfg
+	}
+
+	ImportYAMLFile(&serverCfg, SERVER_CFG_PATH)
+	return &serverCfg
+}
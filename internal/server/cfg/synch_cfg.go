@@ -6,13 +6,20 @@ import (
 	validationUtil "github.com/christoph-karpowicz/db_mediator/internal/util/validation"
 )
 
-var synchNullableFields = []string{}
+var synchNullableFields = []string{"page_size", "compare", "children", "columns", "retry", "rate_limit", "concurrency", "mask", "salt", "keep", "unit"}
 
+// DB_UPSERT updates paired records like DB_UPDATE, but writes missing
+// records with upserts keyed by the match column instead of inserts,
+// so records created by other writers in the meantime aren't duplicated.
 const (
 	DB_INSERT = "INSERT"
 	DB_UPDATE = "UPDATE"
+	DB_UPSERT = "UPSERT"
 )
 
+// DEFAULT_PAGE_SIZE is the number of records read from a table at once.
+const DEFAULT_PAGE_SIZE = 1000
+
 // SynchConfig holds raw data from the YAML config file.
 type SynchConfig struct {
 	Name  string       `yaml:"name"`
@@ -21,6 +28,26 @@ type SynchConfig struct {
 	Link  []string     `yaml:"link"`
 	Match Match        `yaml:"match"`
 	Do    []string     `yaml:"do"`
+	// Compare holds the rules of comparing values of linked columns.
+	Compare Compare `yaml:"compare,omitempty"`
+	// Retry holds the policy of repeating failed writes.
+	Retry Retry `yaml:"retry,omitempty"`
+	// RateLimit throttles the synch's writes.
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty"`
+	// Concurrency bounds how many pairs are synchronized at the same time.
+	Concurrency Concurrency `yaml:"concurrency,omitempty"`
+	// Mask anonymizes the values written to target columns.
+	Mask []Mask `yaml:"mask,omitempty"`
+	// PageSize limits how many records of a table are kept in memory.
+	PageSize int `yaml:"page_size,omitempty"`
+}
+
+// GetPageSize returns the page size, which defaults to DEFAULT_PAGE_SIZE.
+func (s *SynchConfig) GetPageSize() int {
+	if s.PageSize <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+	return s.PageSize
 }
 
 // Validate data from the YAML file.
@@ -30,6 +57,9 @@ func (s *SynchConfig) Validate() {
 	for _, node := range s.Nodes {
 		validationUtil.YAMLStruct(node, synchNullableFields)
 	}
+	for _, mask := range s.Mask {
+		validationUtil.YAMLStruct(mask, synchNullableFields)
+	}
 }
 
 // GetSynchConfigs loads configs from the synchs directory.
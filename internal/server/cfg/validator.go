@@ -0,0 +1,766 @@
+package cfg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"gopkg.in/yaml.v3"
+)
+
+var dbTypes = []string{"mongo", "postgres"}
+var matchMethods = []string{"ids"}
+var doValues = []string{DB_INSERT, DB_UPDATE, DB_UPSERT}
+
+// retryCategories are the categories of write errors, which can be retried.
+var retryCategories = []string{"connection", "query"}
+
+// Diagnostic is a single problem found in a config file.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// positioned is implemented by parser errors
+// which know where in the parsed string they occurred.
+type positioned interface {
+	Position() int
+}
+
+// configValidator collects diagnostics from all config files.
+type configValidator struct {
+	diagnostics  []Diagnostic
+	dbCfgLoaded  bool
+	dbNames      map[string]bool
+	rawFilterDbs map[string]bool
+	dbTypes      map[string]string
+	dbCfgs       map[string]DbConfig
+	synchNames   map[string]*yaml.Node
+}
+
+// ValidateConfigFiles loads the databases config and all synch configs
+// and reports every problem found, with its position in the file.
+func ValidateConfigFiles() []Diagnostic {
+	return validateConfigFiles(DB_CFG_PATH, SYNCH_DIR)
+}
+
+func validateConfigFiles(dbCfgPath string, synchDir string) []Diagnostic {
+	v := &configValidator{
+		diagnostics:  make([]Diagnostic, 0),
+		dbNames:      make(map[string]bool),
+		rawFilterDbs: make(map[string]bool),
+		dbTypes:      make(map[string]string),
+		dbCfgs:       make(map[string]DbConfig),
+		synchNames:   make(map[string]*yaml.Node),
+	}
+
+	v.validateDbFile(dbCfgPath)
+
+	configFiles, err := ioutil.ReadDir(synchDir)
+	if err != nil {
+		v.report(synchDir, nil, err.Error())
+		return v.diagnostics
+	}
+	for _, configFile := range configFiles {
+		if configFile.IsDir() {
+			continue
+		}
+		v.validateSynchFile(filepath.Join(synchDir, configFile.Name()))
+	}
+
+	return v.diagnostics
+}
+
+func (v *configValidator) report(file string, node *yaml.Node, msg string) {
+	diagnostic := Diagnostic{File: file, Message: msg}
+	if node != nil {
+		diagnostic.Line = node.Line
+		diagnostic.Column = node.Column
+	}
+	v.diagnostics = append(v.diagnostics, diagnostic)
+}
+
+// reportAt reports a problem found at the given 1-based position
+// inside of a scalar's value, e.g. a syntax error in a link.
+func (v *configValidator) reportAt(file string, node *yaml.Node, pos int, msg string) {
+	v.report(file, node, msg)
+	diagnostic := &v.diagnostics[len(v.diagnostics)-1]
+	if pos < 1 {
+		return
+	}
+	diagnostic.Column += pos - 1
+	// The node's column points at the opening quote.
+	if node.Style == yaml.SingleQuotedStyle || node.Style == yaml.DoubleQuotedStyle {
+		diagnostic.Column++
+	}
+}
+
+// loadFile reads a YAML file into a node tree.
+// Syntax errors are reported with the line number given by the YAML parser.
+func (v *configValidator) loadFile(file string) *yaml.Node {
+	byteArray, err := ioutil.ReadFile(file)
+	if err != nil {
+		v.report(file, nil, err.Error())
+		return nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(byteArray, &document); err != nil {
+		v.diagnostics = append(v.diagnostics, yamlErrorToDiagnostic(file, err))
+		return nil
+	}
+	if len(document.Content) == 0 {
+		v.report(file, nil, "file is empty")
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		v.report(file, root, "expected a mapping at the top level")
+		return nil
+	}
+	return root
+}
+
+var yamlErrLinePtrn = regexp.MustCompile(`line (\d+): (.+)`)
+
+func yamlErrorToDiagnostic(file string, err error) Diagnostic {
+	diagnostic := Diagnostic{File: file, Message: err.Error()}
+	if matches := yamlErrLinePtrn.FindStringSubmatch(err.Error()); matches != nil {
+		diagnostic.Line, _ = strconv.Atoi(matches[1])
+		diagnostic.Message = matches[2]
+	}
+	return diagnostic
+}
+
+func (v *configValidator) validateDbFile(file string) {
+	root := v.loadFile(file)
+	if root == nil {
+		return
+	}
+	// Environment variables are only substituted in database configs.
+	for _, interpolationErr := range interpolateNode(root) {
+		v.report(file, interpolationErr.node, interpolationErr.errMsg)
+	}
+	v.dbCfgLoaded = true
+	v.checkKeys(file, root, reflect.TypeOf(DbConfigArray{}))
+
+	_, databases := mappingValue(root, "databases")
+	if databases == nil {
+		v.report(file, root, "\"databases\" list is missing")
+		return
+	}
+	if databases.Kind != yaml.SequenceNode {
+		v.report(file, databases, "\"databases\" has to be a list")
+		return
+	}
+
+	for _, dbNode := range databases.Content {
+		var dbCfg DbConfig
+		if !v.decode(file, dbNode, &dbCfg) {
+			continue
+		}
+		v.checkKeys(file, dbNode, reflect.TypeOf(dbCfg))
+		v.checkRequired(file, dbNode, dbCfg, append(dbCfg.GetNullableFields(), "password"))
+		v.checkPassword(file, dbNode, dbCfg)
+		v.checkTLS(file, dbNode, dbCfg)
+		v.checkPositive(file, dbNode, "pool_size", dbCfg.PoolSize)
+		v.validateRateLimit(file, dbNode, dbCfg.RateLimit)
+		v.checkMode(file, dbNode, dbCfg)
+
+		if _, typeNode := mappingValue(dbNode, "type"); typeNode != nil && !util.StringSliceContains(dbTypes, dbCfg.Type) {
+			v.report(file, typeNode, fmt.Sprintf("unknown database type \"%s\", expected one of: %s", dbCfg.Type, strings.Join(dbTypes, ", ")))
+		}
+
+		name := dbCfg.GetName()
+		if name == "" {
+			continue
+		}
+		if v.dbNames[name] {
+			v.report(file, dbNode, fmt.Sprintf("database \"%s\" is defined more than once", name))
+		}
+		v.dbNames[name] = true
+		v.rawFilterDbs[name] = dbCfg.AllowRawFilters
+		v.dbTypes[name] = dbCfg.Type
+		v.dbCfgs[name] = dbCfg
+	}
+}
+
+func (v *configValidator) validateSynchFile(file string) {
+	root := v.loadFile(file)
+	if root == nil {
+		return
+	}
+
+	var synchCfg SynchConfig
+	if !v.decode(file, root, &synchCfg) {
+		return
+	}
+	v.checkKeys(file, root, reflect.TypeOf(synchCfg))
+	v.checkRequired(file, root, synchCfg, synchNullableFields)
+	v.checkPositive(file, root, "page_size", synchCfg.PageSize)
+
+	if _, nameNode := mappingValue(root, "name"); nameNode != nil && synchCfg.Name != "" {
+		if previous, found := v.synchNames[synchCfg.Name]; found {
+			v.report(file, nameNode, fmt.Sprintf("synch name \"%s\" is already used (line %d of another file)", synchCfg.Name, previous.Line))
+		}
+		v.synchNames[synchCfg.Name] = nameNode
+	}
+
+	nodeNames := v.validateNodes(file, root)
+	v.validateMappings(file, root, nodeNames)
+	v.validateLinks(file, root, nodeNames)
+	v.validateMatch(file, root, nodeNames)
+	v.validateDo(file, root)
+	v.validateWriteTargets(file, root, synchCfg)
+	v.validateCompare(file, root, synchCfg.Compare)
+	v.validateRetry(file, root, synchCfg.Retry)
+	v.validateRateLimit(file, root, synchCfg.RateLimit)
+	v.validateConcurrency(file, root, synchCfg.Concurrency)
+	v.validateMasks(file, root, nodeNames)
+}
+
+// validateNodes checks the nodes' fields and database references
+// and returns the set of declared node names.
+func (v *configValidator) validateNodes(file string, root *yaml.Node) map[string]string {
+	// Maps node names to their databases.
+	nodeNames := make(map[string]string)
+
+	_, nodesNode := mappingValue(root, "nodes")
+	if nodesNode == nil || nodesNode.Kind != yaml.SequenceNode {
+		return nodeNames
+	}
+
+	for _, nodeNode := range nodesNode.Content {
+		var nodeCfg NodeConfig
+		if !v.decode(file, nodeNode, &nodeCfg) {
+			continue
+		}
+		v.checkKeys(file, nodeNode, reflect.TypeOf(nodeCfg))
+		v.checkRequired(file, nodeNode, nodeCfg, synchNullableFields)
+
+		// Database references can only be checked if the databases config is readable.
+		if _, dbNode := mappingValue(nodeNode, "database"); v.dbCfgLoaded && dbNode != nil && nodeCfg.Database != "" && !v.dbNames[nodeCfg.Database] {
+			v.report(file, dbNode, fmt.Sprintf("database \"%s\" hasn't been configured", nodeCfg.Database))
+		}
+
+		v.validateChildren(file, nodeNode)
+
+		if nodeCfg.Name == "" {
+			continue
+		}
+		if _, found := nodeNames[nodeCfg.Name]; found {
+			v.report(file, nodeNode, fmt.Sprintf("node \"%s\" is defined more than once", nodeCfg.Name))
+		}
+		nodeNames[nodeCfg.Name] = nodeCfg.Database
+	}
+
+	return nodeNames
+}
+
+// validateChildren checks the child tables declared on a node.
+func (v *configValidator) validateChildren(file string, nodeNode *yaml.Node) {
+	_, childrenNode := mappingValue(nodeNode, "children")
+	if childrenNode == nil || childrenNode.Kind != yaml.SequenceNode {
+		return
+	}
+
+	childNames := make(map[string]bool)
+	for _, childNode := range childrenNode.Content {
+		var childCfg ChildConfig
+		if !v.decode(file, childNode, &childCfg) {
+			continue
+		}
+		v.checkKeys(file, childNode, reflect.TypeOf(childCfg))
+		v.checkRequired(file, childNode, childCfg, synchNullableFields)
+
+		if childCfg.Name != "" && childNames[childCfg.Name] {
+			v.report(file, childNode, fmt.Sprintf("child \"%s\" is defined more than once", childCfg.Name))
+		}
+		childNames[childCfg.Name] = true
+	}
+}
+
+func (v *configValidator) validateMappings(file string, root *yaml.Node, nodeNames map[string]string) {
+	for _, mappingNode := range sequenceItems(root, "map") {
+		mapping, err := ParseMapping(mappingNode.Value)
+		if err != nil {
+			v.reportAt(file, mappingNode, err.(positioned).Position(), err.Error())
+			continue
+		}
+		v.checkColumnRef(file, mappingNode, nodeNames, mapping.Source)
+		v.checkColumnRef(file, mappingNode, nodeNames, mapping.Target)
+	}
+}
+
+func (v *configValidator) validateLinks(file string, root *yaml.Node, nodeNames map[string]string) {
+	for _, linkNode := range sequenceItems(root, "link") {
+		link, err := ParseLink(linkNode.Value)
+		if err != nil {
+			v.reportAt(file, linkNode, err.(positioned).Position(), err.Error())
+			continue
+		}
+		v.checkColumnRef(file, linkNode, nodeNames, link.Source.Ref)
+		v.checkColumnRef(file, linkNode, nodeNames, link.Target.Ref)
+		v.checkRawFilter(file, linkNode, nodeNames, link.Source)
+		v.checkRawFilter(file, linkNode, nodeNames, link.Target)
+	}
+}
+
+// validateWriteTargets makes sure the synch doesn't write to read-only databases or to tables
+// missing from their databases' allow-lists. Link targets are always written to, mapping
+// targets only when records are inserted. Children are written to their own tables.
+func (v *configValidator) validateWriteTargets(file string, root *yaml.Node, synchCfg SynchConfig) {
+	if !v.dbCfgLoaded {
+		return
+	}
+	nodes := make(map[string]NodeConfig, len(synchCfg.Nodes))
+	for _, nodeCfg := range synchCfg.Nodes {
+		nodes[nodeCfg.Name] = nodeCfg
+	}
+
+	for _, linkNode := range sequenceItems(root, "link") {
+		if link, err := ParseLink(linkNode.Value); err == nil {
+			v.checkWritable(file, linkNode, nodes, link.Target.Ref)
+		}
+	}
+	if !util.StringSliceContains(synchCfg.Do, DB_INSERT) && !util.StringSliceContains(synchCfg.Do, DB_UPSERT) {
+		return
+	}
+	for _, mappingNode := range sequenceItems(root, "map") {
+		if mapping, err := ParseMapping(mappingNode.Value); err == nil {
+			v.checkWritable(file, mappingNode, nodes, mapping.Target)
+		}
+	}
+}
+
+// checkWritable reports a column reference, which the synch would write to,
+// if the database of its node doesn't allow it.
+func (v *configValidator) checkWritable(file string, node *yaml.Node, nodes map[string]NodeConfig, ref *ColumnRef) {
+	nodeCfg, found := nodes[ref.Node]
+	if !found {
+		return
+	}
+	dbCfg, found := v.dbCfgs[nodeCfg.Database]
+	if !found {
+		return
+	}
+
+	target, table := fmt.Sprintf("node \"%s\"", nodeCfg.Name), nodeCfg.Table
+	if child := nodeCfg.GetChild(ref.Column); child != nil {
+		target, table = fmt.Sprintf("child \"%s\" of node \"%s\"", child.Name, nodeCfg.Name), child.Table
+	}
+	if dbCfg.GetMode() == DB_MODE_READ_ONLY {
+		v.reportAt(file, node, ref.Pos, fmt.Sprintf("%s can't be written to, because database \"%s\" is read-only", target, nodeCfg.Database))
+	} else if !dbCfg.IsWritable(table) {
+		v.reportAt(file, node, ref.Pos, fmt.Sprintf("%s can't be written to, because table %s isn't in \"writable_tables\" of database \"%s\"", target, table, nodeCfg.Database))
+	}
+}
+
+func (v *configValidator) validateMatch(file string, root *yaml.Node, nodeNames map[string]string) {
+	_, matchNode := mappingValue(root, "match")
+	if matchNode == nil {
+		return
+	}
+	v.checkKeys(file, matchNode, reflect.TypeOf(Match{}))
+
+	_, methodNode := mappingValue(matchNode, "method")
+	if methodNode == nil {
+		v.report(file, matchNode, "match method is missing")
+		return
+	}
+	if !util.StringSliceContains(matchMethods, methodNode.Value) {
+		v.report(file, methodNode, fmt.Sprintf("unknown match method \"%s\", expected one of: %s", methodNode.Value, strings.Join(matchMethods, ", ")))
+		return
+	}
+
+	argNodes := sequenceItems(matchNode, "args")
+	args := make([]string, len(argNodes))
+	for i, argNode := range argNodes {
+		args[i] = argNode.Value
+	}
+
+	parsedArgs, err := ParseIdsMatcherMethod(args)
+	if err != nil {
+		_, argsNode := mappingValue(matchNode, "args")
+		if argsNode == nil {
+			argsNode = matchNode
+		}
+		v.report(file, argsNode, err.Error())
+		return
+	}
+	for i, arg := range parsedArgs {
+		v.checkColumnRef(file, argNodes[i], nodeNames, arg)
+	}
+}
+
+func (v *configValidator) validateDo(file string, root *yaml.Node) {
+	doNodes := sequenceItems(root, "do")
+	values := make([]string, len(doNodes))
+	for i, doNode := range doNodes {
+		values[i] = doNode.Value
+	}
+	for _, doNode := range doNodes {
+		if !util.StringSliceContains(doValues, doNode.Value) {
+			v.report(file, doNode, fmt.Sprintf("unknown \"do\" value \"%s\", expected one of: %s", doNode.Value, strings.Join(doValues, ", ")))
+		} else if doNode.Value != DB_UPSERT && util.StringSliceContains(values, DB_UPSERT) {
+			v.report(file, doNode, fmt.Sprintf("\"%s\" is redundant, \"%s\" already updates and inserts records", doNode.Value, DB_UPSERT))
+		}
+	}
+}
+
+func (v *configValidator) checkColumnRef(file string, node *yaml.Node, nodeNames map[string]string, ref *ColumnRef) {
+	database, found := nodeNames[ref.Node]
+	if !found {
+		v.reportAt(file, node, ref.Pos, fmt.Sprintf("node \"%s\" hasn't been declared in \"nodes\"", ref.Node))
+		return
+	}
+	// Only documents have nested values.
+	if dbType := v.dbTypes[database]; len(ref.Path) > 0 && v.dbCfgLoaded && v.dbNames[database] && dbType != "mongo" {
+		v.reportAt(file, node, ref.Pos, fmt.Sprintf("nested path %s can't be used on node \"%s\", because its database \"%s\" isn't MongoDB", ref, ref.Node, database))
+	}
+}
+
+// checkMode checks the database's write policy.
+func (v *configValidator) checkMode(file string, node *yaml.Node, dbCfg DbConfig) {
+	_, modeNode := mappingValue(node, "mode")
+	if modeNode != nil && !util.StringSliceContains(dbModes, dbCfg.Mode) {
+		v.report(file, modeNode, fmt.Sprintf("unknown mode \"%s\", expected one of: %s", dbCfg.Mode, strings.Join(dbModes, ", ")))
+	}
+	if keyNode, _ := mappingValue(node, "writable_tables"); keyNode != nil && dbCfg.GetMode() == DB_MODE_READ_ONLY && len(dbCfg.WritableTables) > 0 {
+		v.report(file, keyNode, "\"writable_tables\" can't be set on a read-only database")
+	}
+}
+
+// checkPassword makes sure exactly one way of providing the password is used.
+// Password files and commands are only checked, not read or executed.
+func (v *configValidator) checkPassword(file string, node *yaml.Node, dbCfg DbConfig) {
+	passwordSources := 0
+	for _, source := range []string{dbCfg.Password, dbCfg.PasswordFile, dbCfg.PasswordCmd} {
+		if source != "" {
+			passwordSources++
+		}
+	}
+
+	switch {
+	case passwordSources == 0 && dbCfg.DSN == "":
+		v.report(file, node, "field \"password\" is missing, alternatively \"password_file\" or \"password_cmd\" can be used")
+	case passwordSources > 1:
+		v.report(file, node, "only one of \"password\", \"password_file\" and \"password_cmd\" can be set")
+	case dbCfg.PasswordFile != "":
+		if _, err := os.Stat(dbCfg.PasswordFile); err != nil {
+			_, fileNode := mappingValue(node, "password_file")
+			v.report(file, fileNode, fmt.Sprintf("password file can't be read: %v", err))
+		}
+	}
+}
+
+func (v *configValidator) checkTLS(file string, node *yaml.Node, dbCfg DbConfig) {
+	_, tlsNode := mappingValue(node, "tls")
+	if tlsNode == nil || dbCfg.TLS == nil {
+		return
+	}
+	v.checkKeys(file, tlsNode, reflect.TypeOf(TLSConfig{}))
+
+	if _, modeNode := mappingValue(tlsNode, "mode"); modeNode != nil && !util.StringSliceContains(tlsModes, dbCfg.TLS.Mode) {
+		v.report(file, modeNode, fmt.Sprintf("unknown TLS mode \"%s\", expected one of: %s", dbCfg.TLS.Mode, strings.Join(tlsModes, ", ")))
+	}
+	if (dbCfg.TLS.CertFile == "") != (dbCfg.TLS.KeyFile == "") {
+		v.report(file, tlsNode, "\"cert_file\" and \"key_file\" have to be set together")
+	}
+	for _, key := range []string{"ca_file", "cert_file", "key_file"} {
+		if _, fileNode := mappingValue(tlsNode, key); fileNode != nil {
+			if _, err := os.Stat(fileNode.Value); err != nil {
+				v.report(file, fileNode, fmt.Sprintf("TLS file can't be read: %v", err))
+			}
+		}
+	}
+}
+
+// validateCompare checks the rules of comparing values.
+func (v *configValidator) validateCompare(file string, root *yaml.Node, compare Compare) {
+	_, compareNode := mappingValue(root, "compare")
+	if compareNode == nil {
+		return
+	}
+	v.checkKeys(file, compareNode, reflect.TypeOf(compare))
+
+	if _, toleranceNode := mappingValue(compareNode, "float_tolerance"); toleranceNode != nil && compare.FloatTolerance < 0 {
+		v.report(file, toleranceNode, "\"float_tolerance\" can't be negative")
+	}
+	if _, precisionNode := mappingValue(compareNode, "time_precision"); precisionNode != nil && compare.TimePrecision <= 0 {
+		v.report(file, precisionNode, "\"time_precision\" has to be a positive duration")
+	}
+	if _, zoneNode := mappingValue(compareNode, "time_zone"); zoneNode != nil {
+		if _, err := compare.GetLocation(); err != nil {
+			v.report(file, zoneNode, fmt.Sprintf("unknown time zone \"%s\"", compare.TimeZone))
+		}
+	}
+}
+
+// validateRetry checks the policy of repeating failed writes.
+func (v *configValidator) validateRetry(file string, root *yaml.Node, retry Retry) {
+	_, retryNode := mappingValue(root, "retry")
+	if retryNode == nil {
+		return
+	}
+	v.checkKeys(file, retryNode, reflect.TypeOf(retry))
+	v.checkPositive(file, retryNode, "attempts", retry.Attempts)
+
+	if _, backoffNode := mappingValue(retryNode, "backoff"); backoffNode != nil && retry.Backoff <= 0 {
+		v.report(file, backoffNode, "\"backoff\" has to be a positive duration")
+	}
+	if _, maxBackoffNode := mappingValue(retryNode, "max_backoff"); maxBackoffNode != nil && retry.MaxBackoff < retry.GetBackoff() {
+		v.report(file, maxBackoffNode, "\"max_backoff\" can't be shorter than \"backoff\"")
+	}
+	for _, categoryNode := range sequenceItems(retryNode, "retry_on") {
+		if !util.StringSliceContains(retryCategories, categoryNode.Value) {
+			v.report(file, categoryNode, fmt.Sprintf("errors of category \"%s\" can't be retried, expected one of: %s", categoryNode.Value, strings.Join(retryCategories, ", ")))
+		}
+	}
+}
+
+// validateRateLimit checks the throttling of writes.
+func (v *configValidator) validateRateLimit(file string, parent *yaml.Node, limit *RateLimit) {
+	_, limitNode := mappingValue(parent, "rate_limit")
+	if limitNode == nil || limit == nil {
+		return
+	}
+	v.checkKeys(file, limitNode, reflect.TypeOf(*limit))
+	v.checkPositive(file, limitNode, "max_concurrent", limit.MaxConcurrent)
+
+	opsKeyNode, opsNode := mappingValue(limitNode, "ops_per_second")
+	if opsNode != nil && limit.OpsPerSecond <= 0 {
+		v.report(file, opsNode, "\"ops_per_second\" has to be a positive number")
+	}
+
+	adaptiveKeyNode, adaptiveNode := mappingValue(limitNode, "adaptive")
+	if adaptiveNode == nil || limit.Adaptive == nil {
+		return
+	}
+	adaptive := limit.Adaptive
+	v.checkKeys(file, adaptiveNode, reflect.TypeOf(*adaptive))
+	if opsKeyNode == nil {
+		v.report(file, adaptiveKeyNode, "adaptive rate limiting needs \"ops_per_second\" to start from")
+	}
+	if _, latencyNode := mappingValue(adaptiveNode, "max_latency"); latencyNode != nil && adaptive.MaxLatency <= 0 {
+		v.report(file, latencyNode, "\"max_latency\" has to be a positive duration")
+	}
+	if _, errorRateNode := mappingValue(adaptiveNode, "max_error_rate"); errorRateNode != nil && (adaptive.MaxErrorRate <= 0 || adaptive.MaxErrorRate > 1) {
+		v.report(file, errorRateNode, "\"max_error_rate\" has to be between 0 and 1")
+	}
+	if _, minOpsNode := mappingValue(adaptiveNode, "min_ops_per_second"); minOpsNode != nil {
+		if adaptive.MinOpsPerSecond <= 0 {
+			v.report(file, minOpsNode, "\"min_ops_per_second\" has to be a positive number")
+		} else if limit.OpsPerSecond > 0 && adaptive.MinOpsPerSecond > limit.OpsPerSecond {
+			v.report(file, minOpsNode, "\"min_ops_per_second\" can't be greater than \"ops_per_second\"")
+		}
+	}
+}
+
+// validateConcurrency checks the numbers of workers.
+func (v *configValidator) validateConcurrency(file string, root *yaml.Node, concurrency Concurrency) {
+	_, concurrencyNode := mappingValue(root, "concurrency")
+	if concurrencyNode == nil {
+		return
+	}
+	v.checkKeys(file, concurrencyNode, reflect.TypeOf(concurrency))
+	v.checkPositive(file, concurrencyNode, "workers", concurrency.Workers)
+	v.checkPositive(file, concurrencyNode, "per_target", concurrency.PerTarget)
+
+	if _, perTargetNode := mappingValue(concurrencyNode, "per_target"); perTargetNode != nil && concurrency.PerTarget > concurrency.GetWorkers() {
+		v.report(file, perTargetNode, fmt.Sprintf("\"per_target\" can't be greater than the number of workers (%d)", concurrency.GetWorkers()))
+	}
+}
+
+// validateMasks checks the masking functions of target columns.
+func (v *configValidator) validateMasks(file string, root *yaml.Node, nodeNames map[string]string) {
+	_, masksNode := mappingValue(root, "mask")
+	if masksNode == nil || masksNode.Kind != yaml.SequenceNode {
+		return
+	}
+
+	maskedColumns := make(map[string]bool)
+	for _, maskNode := range masksNode.Content {
+		var mask Mask
+		if !v.decode(file, maskNode, &mask) {
+			continue
+		}
+		v.checkKeys(file, maskNode, reflect.TypeOf(mask))
+		v.checkRequired(file, maskNode, mask, synchNullableFields)
+
+		if _, columnNode := mappingValue(maskNode, "column"); columnNode != nil && mask.Column != "" {
+			ref, err := ParseColumnRef(mask.Column)
+			if err != nil {
+				v.reportAt(file, columnNode, err.(positioned).Position(), err.Error())
+			} else {
+				v.checkColumnRef(file, columnNode, nodeNames, ref)
+				if maskedColumns[ref.String()] {
+					v.report(file, columnNode, fmt.Sprintf("column %s is masked more than once", ref))
+				}
+				maskedColumns[ref.String()] = true
+			}
+		}
+
+		if _, functionNode := mappingValue(maskNode, "function"); functionNode != nil && mask.Function != "" && !util.StringSliceContains(maskFunctions, mask.Function) {
+			v.report(file, functionNode, fmt.Sprintf("unknown masking function \"%s\", expected one of: %s", mask.Function, strings.Join(maskFunctions, ", ")))
+		}
+		if mask.Function == MASK_HASH && mask.Salt == "" {
+			v.report(file, maskNode, fmt.Sprintf("masking function \"%s\" needs a \"salt\"", MASK_HASH))
+		}
+		if keepKeyNode, keepNode := mappingValue(maskNode, "keep"); keepNode != nil {
+			if mask.Function != MASK_REDACT {
+				v.report(file, keepKeyNode, fmt.Sprintf("\"keep\" is only used by masking function \"%s\"", MASK_REDACT))
+			} else if mask.Keep < 0 {
+				v.report(file, keepNode, "\"keep\" can't be negative")
+			}
+		}
+		if unitKeyNode, unitNode := mappingValue(maskNode, "unit"); unitNode != nil {
+			if mask.Function != MASK_TRUNCATE_DATE {
+				v.report(file, unitKeyNode, fmt.Sprintf("\"unit\" is only used by masking function \"%s\"", MASK_TRUNCATE_DATE))
+			} else if !util.StringSliceContains(truncateUnits, mask.Unit) {
+				v.report(file, unitNode, fmt.Sprintf("unknown unit \"%s\", expected one of: %s", mask.Unit, strings.Join(truncateUnits, ", ")))
+			}
+		}
+	}
+}
+
+// checkPositive reports an optional number, which is set but isn't positive.
+func (v *configValidator) checkPositive(file string, node *yaml.Node, key string, value int) {
+	if _, valueNode := mappingValue(node, key); valueNode != nil && value <= 0 {
+		v.report(file, valueNode, fmt.Sprintf("\"%s\" has to be a positive number", key))
+	}
+}
+
+// checkRawFilter reports native conditions used on
+// a database, which doesn't allow them.
+func (v *configValidator) checkRawFilter(file string, node *yaml.Node, nodeNames map[string]string, endpoint *LinkEndpoint) {
+	if _, isRaw := endpoint.GetFilter().(*RawFilter); !isRaw || !v.dbCfgLoaded {
+		return
+	}
+	database, found := nodeNames[endpoint.Ref.Node]
+	if !found || !v.dbNames[database] || v.rawFilterDbs[database] {
+		return
+	}
+	v.reportAt(file, node, endpoint.Where.Pos, fmt.Sprintf("%s conditions aren't allowed on database \"%s\", set \"allow_raw_filters\" to true in its config to enable them", FILTER_RAW, database))
+}
+
+// decode decodes a node into a config struct reporting type errors.
+func (v *configValidator) decode(file string, node *yaml.Node, out interface{}) bool {
+	if node.Kind != yaml.MappingNode {
+		v.report(file, node, "expected a mapping")
+		return false
+	}
+	if err := node.Decode(out); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				v.diagnostics = append(v.diagnostics, yamlErrorToDiagnostic(file, fmt.Errorf("%s", msg)))
+			}
+		} else {
+			v.report(file, node, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// checkKeys reports keys that don't match any field of the config struct.
+func (v *configValidator) checkKeys(file string, node *yaml.Node, structType reflect.Type) {
+	knownKeys := yamlKeys(structType)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		if !util.StringSliceContains(knownKeys, keyNode.Value) {
+			v.report(file, keyNode, fmt.Sprintf("unknown field \"%s\", expected one of: %s", keyNode.Value, strings.Join(knownKeys, ", ")))
+		}
+	}
+}
+
+// checkRequired reports empty fields, that aren't nullable.
+func (v *configValidator) checkRequired(file string, node *yaml.Node, structure interface{}, nullableFields []string) {
+	structValue := reflect.ValueOf(structure)
+	structType := structValue.Type()
+
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structType.Field(i)
+		key := yamlKey(field)
+		if field.Tag == "" || util.StringSliceContains(nullableFields, key) {
+			continue
+		}
+		if isEmptyYAMLValue(structValue.Field(i)) {
+			keyNode, valueNode := mappingValue(node, key)
+			if keyNode == nil {
+				v.report(file, node, fmt.Sprintf("field \"%s\" is missing", key))
+			} else {
+				v.report(file, valueNode, fmt.Sprintf("field \"%s\" is invalid", key))
+			}
+		}
+	}
+}
+
+func isEmptyYAMLValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int:
+		return value.Int() <= 0
+	case reflect.String, reflect.Slice:
+		return value.Len() == 0
+	}
+	return false
+}
+
+// mappingValue finds a key and its value in a YAML mapping node.
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// sequenceItems returns the scalar items of a YAML list stored under a key.
+func sequenceItems(node *yaml.Node, key string) []*yaml.Node {
+	items := make([]*yaml.Node, 0)
+	_, seqNode := mappingValue(node, key)
+	if seqNode == nil || seqNode.Kind != yaml.SequenceNode {
+		return items
+	}
+	for _, item := range seqNode.Content {
+		if item.Kind == yaml.ScalarNode {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// yamlKeys lists the YAML keys of a config struct.
+func yamlKeys(structType reflect.Type) []string {
+	keys := make([]string, 0, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		keys = append(keys, yamlKey(structType.Field(i)))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func yamlKey(field reflect.StructField) string {
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if tag == "" {
+		return strings.ToLower(field.Name)
+	}
+	return tag
+}
@@ -0,0 +1,180 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDbCfg = `databases:
+    -
+        name     : dvdrental
+        type     : postgres
+        host     : ${DB_MEDIATOR_TEST_UNSET}
+        port     : 5432
+        user     : postgres
+        password : postgres
+        mode     : read-only
+        writable_tables: [film]
+    -
+        name     : msamp
+        type     : mysql
+        host     : localhost
+        port     : 27017
+        user     : mongo
+        pasword  : mongo
+        tls      :
+            mode : verify-host
+`
+
+const testSynchCfg = `name: test
+
+nodes:
+    -
+        name        : films
+        database    : dvdrental
+        table       : film
+        key         : film_id
+    -
+        name        : docs
+        database    : missing
+        table       : Sakila_films
+        key         : _id
+        children    :
+            -
+                name       : actors
+                table      : film_actor
+                parent_key : film_id
+            -
+                name       : actors
+                table      : film_category
+                parent_key : film_id
+                key        : category_id
+
+map:
+    - 'docs.Title TO films.meta.title'
+    - 'films.title docs.Title'
+
+link:
+    - '[films.title WHERE film_id > 30] TO [other.Title]'
+    - '[films.title WHERE RAW(film_id % 2 = 0)] TO [docs.Title]'
+
+match:
+    method: ids
+    args:
+        - 'films.film_id'
+        - 'docs.ext_id'
+
+do:
+    - 'UPDATE'
+    - 'DELETE'
+    - 'UPSERT'
+
+compare:
+    float_tolerance: 0.001
+    time_zone: Mars/Olympus
+
+retry:
+    attempts: 0
+    backoff: 2s
+    max_backoff: 1s
+    retry_on: ['connection', 'mapping']
+
+rate_limit:
+    max_concurrent: 0
+    adaptive:
+        max_error_rate: 2
+
+concurrency:
+    workers: 2
+    per_target: 4
+
+mask:
+    -
+        column   : docs.Title
+        function : hash
+    -
+        column   : docs.Title
+        function : redact
+        unit     : day
+    -
+        column   : films.rating
+        function : scramble
+`
+
+func TestValidateConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg_validator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbCfgPath := filepath.Join(dir, "databases.yaml")
+	synchDir := filepath.Join(dir, "synch")
+	synchCfgPath := filepath.Join(synchDir, "test.yaml")
+	if err := os.Mkdir(synchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dbCfgPath, []byte(testDbCfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(synchCfgPath, []byte(testSynchCfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		file    string
+		line    int
+		column  int
+		message string
+	}{
+		{dbCfgPath, 5, 20, "environment variable \"DB_MEDIATOR_TEST_UNSET\" isn't set"},
+		{dbCfgPath, 10, 9, "\"writable_tables\" can't be set on a read-only database"},
+		{dbCfgPath, 17, 0, "unknown field \"pasword\""},
+		{dbCfgPath, 12, 0, "field \"password\" is missing"},
+		{dbCfgPath, 13, 0, "unknown database type \"mysql\""},
+		{dbCfgPath, 19, 20, "unknown TLS mode \"verify-host\""},
+		{synchCfgPath, 11, 0, "database \"missing\" hasn't been configured"},
+		{synchCfgPath, 16, 0, "field \"key\" is missing"},
+		{synchCfgPath, 20, 0, "child \"actors\" is defined more than once"},
+		{synchCfgPath, 26, 0, "nested path films.meta.title can't be used on node \"films\""},
+		{synchCfgPath, 26, 0, "node \"films\" can't be written to, because database \"dvdrental\" is read-only"},
+		{synchCfgPath, 27, 20, "mapping parser"},
+		{synchCfgPath, 30, 45, "node \"other\" hasn't been declared"},
+		{synchCfgPath, 31, 27, "RAW conditions aren't allowed on database \"dvdrental\""},
+		{synchCfgPath, 40, 0, "\"UPDATE\" is redundant"},
+		{synchCfgPath, 41, 0, "unknown \"do\" value \"DELETE\""},
+		{synchCfgPath, 46, 16, "unknown time zone \"Mars/Olympus\""},
+		{synchCfgPath, 49, 15, "\"attempts\" has to be a positive number"},
+		{synchCfgPath, 51, 18, "\"max_backoff\" can't be shorter than \"backoff\""},
+		{synchCfgPath, 52, 30, "errors of category \"mapping\" can't be retried"},
+		{synchCfgPath, 55, 21, "\"max_concurrent\" has to be a positive number"},
+		{synchCfgPath, 56, 5, "adaptive rate limiting needs \"ops_per_second\""},
+		{synchCfgPath, 57, 25, "\"max_error_rate\" has to be between 0 and 1"},
+		{synchCfgPath, 61, 17, "\"per_target\" can't be greater than the number of workers (2)"},
+		{synchCfgPath, 65, 0, "masking function \"hash\" needs a \"salt\""},
+		{synchCfgPath, 68, 20, "column docs.Title is masked more than once"},
+		{synchCfgPath, 70, 9, "\"unit\" is only used by masking function \"truncate_date\""},
+		{synchCfgPath, 73, 20, "unknown masking function \"scramble\""},
+	}
+
+	diagnostics := validateConfigFiles(dbCfgPath, synchDir)
+	if len(diagnostics) != len(expected) {
+		t.Errorf("expected %d diagnostics, got %d: %v", len(expected), len(diagnostics), diagnostics)
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, d := range diagnostics {
+			if d.File == e.file && d.Line == e.line && (e.column == 0 || d.Column == e.column) && strings.Contains(d.Message, e.message) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected diagnostic \"%s\" in line %d of %s, got: %v", e.message, e.line, e.file, diagnostics)
+		}
+	}
+}
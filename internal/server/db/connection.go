@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// postgresConnectionString builds a lib/pq connection string.
+// Options set in the config are appended to the DSN, so they take precedence.
+func postgresConnectionString(dbCfg *cfg.DbConfig) (string, error) {
+	params := make([]string, 0)
+
+	if dbCfg.DSN != "" {
+		dsn := dbCfg.DSN
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			converted, err := pq.ParseURL(dsn)
+			if err != nil {
+				return "", err
+			}
+			dsn = converted
+		}
+		params = append(params, dsn)
+	} else {
+		params = append(params, postgresParam("dbname", dbCfg.Name))
+	}
+
+	if dbCfg.Host != "" {
+		params = append(params, postgresParam("host", dbCfg.Host))
+	}
+	if dbCfg.Port > 0 {
+		params = append(params, postgresParam("port", strconv.Itoa(dbCfg.Port)))
+	}
+	if dbCfg.User != "" {
+		params = append(params, postgresParam("user", dbCfg.User))
+	}
+	if dbCfg.Password != "" {
+		params = append(params, postgresParam("password", dbCfg.Password))
+	}
+
+	switch {
+	case dbCfg.TLS != nil:
+		params = append(params, postgresParam("sslmode", dbCfg.TLS.GetMode()))
+		if dbCfg.TLS.CAFile != "" {
+			params = append(params, postgresParam("sslrootcert", dbCfg.TLS.CAFile))
+		}
+		if dbCfg.TLS.CertFile != "" {
+			params = append(params, postgresParam("sslcert", dbCfg.TLS.CertFile), postgresParam("sslkey", dbCfg.TLS.KeyFile))
+		}
+	case dbCfg.DSN == "":
+		// Without any TLS options plain connections are kept as the default.
+		params = append(params, postgresParam("sslmode", cfg.TLS_DISABLE))
+	}
+
+	if dbCfg.ConnectTimeout > 0 {
+		seconds := int(dbCfg.ConnectTimeout.Round(time.Second) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		params = append(params, postgresParam("connect_timeout", strconv.Itoa(seconds)))
+	}
+	// Unknown options are sent to the server as run-time parameters.
+	if dbCfg.SearchPath != "" {
+		params = append(params, postgresParam("search_path", dbCfg.SearchPath))
+	}
+
+	return strings.Join(params, " "), nil
+}
+
+// postgresParam formats a key=value pair, quoting the value if needed.
+func postgresParam(key string, value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return key + "=" + value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return key + "='" + escaped + "'"
+}
+
+// mongoClientOptions builds the client options from the URI and the other connection fields.
+func mongoClientOptions(dbCfg *cfg.DbConfig) (*options.ClientOptions, error) {
+	uri := dbCfg.DSN
+	if uri == "" {
+		uri = fmt.Sprintf(`mongodb://%s:%d/%s`, dbCfg.Host, dbCfg.Port, dbCfg.Name)
+	}
+	clientOptions := options.Client().ApplyURI(uri)
+	clientOptions.SetMaxPoolSize(uint64(dbCfg.GetPoolSize()))
+
+	if dbCfg.User != "" {
+		credential := options.Credential{Username: dbCfg.User, Password: dbCfg.Password, AuthSource: dbCfg.AuthSource}
+		clientOptions.SetAuth(credential)
+	} else if dbCfg.AuthSource != "" && clientOptions.Auth != nil {
+		clientOptions.Auth.AuthSource = dbCfg.AuthSource
+	}
+	if dbCfg.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(dbCfg.ReplicaSet)
+	}
+	if dbCfg.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(dbCfg.ConnectTimeout)
+		clientOptions.SetServerSelectionTimeout(dbCfg.ConnectTimeout)
+	}
+
+	if dbCfg.TLS != nil && dbCfg.TLS.GetMode() != cfg.TLS_DISABLE {
+		tlsConfig, err := createTLSConfig(dbCfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	return clientOptions, nil
+}
+
+// createTLSConfig translates the TLS options into a crypto/tls config.
+// Modes follow PostgreSQL's sslmode: "require" doesn't verify the server's
+// certificate, "verify-ca" verifies it without checking the host name.
+func createTLSConfig(tlsCfg *cfg.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if tlsCfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCfg.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if tlsCfg.CertFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	switch tlsCfg.GetMode() {
+	case cfg.TLS_REQUIRE:
+		tlsConfig.InsecureSkipVerify = true
+	case cfg.TLS_VERIFY_CA:
+		// Host name verification is skipped, so the chain has to be verified manually.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, tlsConfig.RootCAs)
+		}
+	case cfg.TLS_VERIFY_FULL:
+	default:
+		return nil, fmt.Errorf("unknown TLS mode \"%s\"", tlsCfg.Mode)
+	}
+
+	return tlsConfig, nil
+}
+
+func verifyCertificateChain(rawCerts [][]byte, rootCAs *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server didn't present a certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: rootCAs, Intermediates: intermediates})
+	return err
+}
+
+// withQueryTimeout limits the query's context with the database's query timeout.
+func withQueryTimeout(ctx context.Context, dbCfg *cfg.DbConfig) (context.Context, context.CancelFunc) {
+	if dbCfg.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, dbCfg.QueryTimeout)
+}
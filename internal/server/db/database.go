@@ -1,27 +1,58 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 )
 
 // Database interface is the blueprint for all structs for specific databases.
+// All querying methods take a context, cancelling it aborts the query.
+// Select takes a database agnostic filter, which is nil if all records are selected.
+// Iterate selects records page by page for tables too big to be kept in memory.
+// Describe returns the schema of a table, which is used to validate mappings.
+// Upsert updates the record with the InsertDto's match key or inserts it, if there isn't one.
+// ReplaceChildren replaces all records of a child table, which reference a parent record.
+// Init opens the database's pooled connection, Close releases it.
 type Database interface {
 	GetConfig() *cfg.DbConfig
-	Init()
-	Select(tableName string, conditions string) []map[string]interface{}
-	TestConnection()
-	Insert(inDto InsertDto) error
-	Update(upDto UpdateDto) error
+	Init() error
+	Close() error
+	Select(ctx context.Context, tableName string, filter cfg.Filter) ([]map[string]interface{}, error)
+	Iterate(ctx context.Context, tableName string, opts SelectOptions) (RecordIterator, error)
+	Describe(ctx context.Context, tableName string) (*TableSchema, error)
+	TestConnection() error
+	Insert(ctx context.Context, inDto InsertDto) error
+	Update(ctx context.Context, upDto UpdateDto) error
+	Upsert(ctx context.Context, inDto InsertDto) error
+	ReplaceChildren(ctx context.Context, chDto ChildrenDto) error
+}
+
+// RecordIterator returns selected records one page at a time.
+// Next returns an empty page once all records have been read.
+type RecordIterator interface {
+	Next(ctx context.Context) ([]map[string]interface{}, error)
+	Close() error
 }
 
 // DatabaseError is a custom db error.
+// Errors without a category are considered query errors.
 type DatabaseError struct {
-	DBName   string      `json:"dbName"`
-	ErrMsg   string      `json:"errMsg"`
-	KeyName  string      `json:"keyName"`
-	KeyValue interface{} `json:"keyVal"`
+	DBName   string          `json:"dbName"`
+	ErrMsg   string          `json:"errMsg"`
+	KeyName  string          `json:"keyName"`
+	KeyValue interface{}     `json:"keyVal"`
+	Cat      apperr.Category `json:"category"`
+}
+
+// Category returns the error's category.
+func (e *DatabaseError) Category() apperr.Category {
+	if e.Cat == "" {
+		return apperr.QUERY
+	}
+	return e.Cat
 }
 
 func (e *DatabaseError) Error() string {
@@ -31,3 +62,27 @@ func (e *DatabaseError) Error() string {
 
 	return fmt.Sprintf("[ERROR] database %s: %s", e.DBName, e.ErrMsg)
 }
+
+// checkWritable makes sure writes never reach read-only databases, databases
+// with a mistyped mode or tables missing from a database's allow-list.
+func checkWritable(dbCfg *cfg.DbConfig, tableName string) error {
+	if !dbCfg.HasKnownMode() {
+		return &DatabaseError{DBName: dbCfg.Name, ErrMsg: fmt.Sprintf("unknown mode \"%s\", table %s can't be written to", dbCfg.Mode, tableName), Cat: apperr.CONFIG}
+	}
+	if dbCfg.GetMode() == cfg.DB_MODE_READ_ONLY {
+		return &DatabaseError{DBName: dbCfg.Name, ErrMsg: "database is read-only, table " + tableName + " can't be written to", Cat: apperr.CONFIG}
+	}
+	if !dbCfg.IsWritable(tableName) {
+		return &DatabaseError{DBName: dbCfg.Name, ErrMsg: "table " + tableName + " isn't in \"writable_tables\", so it can't be written to", Cat: apperr.CONFIG}
+	}
+	return nil
+}
+
+// checkRawFilter makes sure native conditions are only
+// passed to databases, which explicitly allow them.
+func checkRawFilter(dbCfg *cfg.DbConfig, filter cfg.Filter) error {
+	if _, isRaw := filter.(*cfg.RawFilter); isRaw && !dbCfg.AllowRawFilters {
+		return &DatabaseError{DBName: dbCfg.Name, ErrMsg: "raw conditions are disabled for this database, set \"allow_raw_filters\" to true to enable them", Cat: apperr.CONFIG}
+	}
+	return nil
+}
@@ -1,16 +1,71 @@
 package db
 
+import "github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+
+// UpdateDto describes an update of a single column.
+// UpdatedPath addresses a value nested in the column, it's only supported by MongoDB.
 type UpdateDto struct {
 	TableName         string
 	KeyName           string
 	KeyValue          interface{}
 	UpdatedColumnName string
+	UpdatedPath       []cfg.PathSegment
 	NewValue          interface{}
 }
 
+// InsertDto describes a record to be created. KeyName is the match column, which
+// upserts look the record up by, so its value is written together with Values.
 type InsertDto struct {
 	TableName string
 	KeyName   string
 	KeyValue  interface{}
 	Values    map[string]interface{}
 }
+
+// GetKeyedValues returns the values with the match column's value set.
+func (i InsertDto) GetKeyedValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(i.Values)+1)
+	for column, value := range i.Values {
+		values[column] = value
+	}
+	values[i.KeyName] = i.KeyValue
+	return values
+}
+
+// ChildrenDto holds the records of a child table, which reference one parent record.
+type ChildrenDto struct {
+	TableName      string
+	ParentKeyName  string
+	ParentKeyValue interface{}
+	Children       []map[string]interface{}
+}
+
+// GetChildren returns the children with their parent key set.
+func (c ChildrenDto) GetChildren() []map[string]interface{} {
+	children := make([]map[string]interface{}, len(c.Children))
+	for i, child := range c.Children {
+		children[i] = make(map[string]interface{}, len(child)+1)
+		for column, value := range child {
+			children[i][column] = value
+		}
+		children[i][c.ParentKeyName] = c.ParentKeyValue
+	}
+	return children
+}
+
+// SelectOptions control how Iterate selects records.
+// Records are sorted by the OrderBy columns in ascending order with nulls first,
+// strings are compared byte by byte. The columns have to identify records uniquely.
+type SelectOptions struct {
+	Filter   cfg.Filter
+	OrderBy  []string
+	PageSize int
+}
+
+// GetPageSize returns the page size, which defaults to cfg.DEFAULT_PAGE_SIZE.
+func (o SelectOptions) GetPageSize() int {
+	if o.PageSize <= 0 {
+		return cfg.DEFAULT_PAGE_SIZE
+	}
+	return o.PageSize
+}
@@ -5,6 +5,8 @@ methods for querying.
 package db
 
 import (
+	"log"
+
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 )
 
@@ -31,6 +33,9 @@ func (d *Databases) getConfigs() *cfg.DbConfigArray {
 		default:
 			database = nil
 		}
+		if database != nil && dbCfgs.Databases[i].RateLimit != nil {
+			database = &limitedDatabase{database, NewRateLimiter(dbCfgs.Databases[i].GetName(), dbCfgs.Databases[i].RateLimit)}
+		}
 
 		(*d)[dbCfgs.Databases[i].GetName()] = &database
 		// fmt.Printf("val: %s\n", dbDataArr.Databases[i].Name)
@@ -43,3 +48,15 @@ func (d *Databases) getConfigs() *cfg.DbConfigArray {
 func (d *Databases) validateConfigs(dbCfgs *cfg.DbConfigArray) {
 	dbCfgs.Validate()
 }
+
+// Close closes the connections of all databases.
+func (d *Databases) Close() {
+	for name, database := range *d {
+		if database == nil || *database == nil {
+			continue
+		}
+		if err := (*database).Close(); err != nil {
+			log.Printf("[shutdown] database %s: %s\n", name, err)
+		}
+	}
+}
@@ -1,13 +1,432 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var dbs Databases
 
+func TestPostgresFilter(t *testing.T) {
+	filter := &cfg.LogicalFilter{
+		Operator: cfg.FILTER_OR,
+		Operands: []cfg.Filter{
+			&cfg.Comparison{Column: "film_id", Operator: ">", Value: int64(30)},
+			&cfg.NotFilter{Operand: &cfg.InFilter{Column: "rating", Values: []interface{}{"G", "PG"}}},
+			&cfg.NullFilter{Column: "description", Negated: true},
+			&cfg.LikeFilter{Column: "title", Pattern: "A%"},
+		},
+	}
+
+	var args []interface{}
+	condition, err := postgresFilter(filter, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `("film_id" > $1 OR NOT ("rating" IN ($2, $3)) OR "description" IS NOT NULL OR "title" LIKE $4)`
+	if condition != expected {
+		t.Errorf("expected %s, got %s", expected, condition)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(30), "G", "PG", "A%"}) {
+		t.Errorf("wrong query arguments: %v", args)
+	}
+}
+
+func TestQuoteTableName(t *testing.T) {
+	cases := map[string]string{
+		`film`:                `"film"`,
+		`Sakila_films`:        `"Sakila_films"`,
+		`public.film`:         `"public"."film"`,
+		`"public"."my.table"`: `"public"."my.table"`,
+		`film"; DROP TABLE x`: `"film; DROP TABLE x"`,
+	}
+	for tableName, expected := range cases {
+		if quoted := quoteTableName(tableName); quoted != expected {
+			t.Errorf("expected %s to be quoted as %s, got %s", tableName, expected, quoted)
+		}
+	}
+}
+
+func TestUpsertQuery(t *testing.T) {
+	inDto := InsertDto{TableName: "public.film", KeyName: "ext_id", KeyValue: "5f1", Values: map[string]interface{}{"title": "Alien", "length": int64(117)}}
+	query, args := upsertQuery(inDto.TableName, inDto.KeyName, inDto.GetKeyedValues())
+	expected := `INSERT INTO "public"."film"("ext_id", "length", "title") VALUES($1, $2, $3) ON CONFLICT ("ext_id") DO UPDATE SET "length" = EXCLUDED."length", "title" = EXCLUDED."title"`
+	if query != expected {
+		t.Errorf("expected query:\n%s\ngot:\n%s", expected, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"5f1", int64(117), "Alien"}) {
+		t.Errorf("wrong query arguments: %v", args)
+	}
+
+	query, _ = upsertQuery("film", "ext_id", map[string]interface{}{"ext_id": "5f1"})
+	if !strings.HasSuffix(query, `ON CONFLICT ("ext_id") DO NOTHING`) {
+		t.Errorf("expected a row with only the key to be left alone on conflict, got %s", query)
+	}
+}
+
+func TestPostgresConnectionString(t *testing.T) {
+	dbCfg := &cfg.DbConfig{Name: "dvdrental", Host: "localhost", Port: 5432, User: "postgres", Password: "it's secret"}
+	connectionString, err := postgresConnectionString(dbCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `dbname=dvdrental host=localhost port=5432 user=postgres password='it\'s secret' sslmode=disable`
+	if connectionString != expected {
+		t.Errorf("expected %s, got %s", expected, connectionString)
+	}
+
+	dbCfg = &cfg.DbConfig{
+		Name:           "dvdrental",
+		DSN:            "postgres://app@db.example.com:6432/dvdrental?application_name=mediator",
+		Password:       "secret",
+		TLS:            &cfg.TLSConfig{CAFile: "/etc/ssl/ca.pem"},
+		SearchPath:     "sales,public",
+		ConnectTimeout: 1500 * time.Millisecond,
+	}
+	connectionString, err = postgresConnectionString(dbCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, param := range []string{"host=db.example.com", "user=app", "password=secret", "sslmode=verify-full", "sslrootcert=/etc/ssl/ca.pem", "search_path=sales,public", "connect_timeout=2"} {
+		if !strings.Contains(connectionString, param) {
+			t.Errorf("expected %s in %s", param, connectionString)
+		}
+	}
+}
+
+func TestMongoClientOptions(t *testing.T) {
+	dbCfg := &cfg.DbConfig{
+		Name:           "msamp",
+		DSN:            "mongodb://db1.example.com:27017,db2.example.com:27017/msamp",
+		User:           "mongo",
+		Password:       "secret",
+		AuthSource:     "admin",
+		ReplicaSet:     "rs0",
+		ConnectTimeout: 5 * time.Second,
+		TLS:            &cfg.TLSConfig{Mode: cfg.TLS_REQUIRE},
+	}
+	clientOptions, err := mongoClientOptions(dbCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clientOptions.Hosts) != 2 || clientOptions.Auth.AuthSource != "admin" || *clientOptions.ReplicaSet != "rs0" {
+		t.Errorf("wrong client options: %+v", clientOptions)
+	}
+	if clientOptions.TLSConfig == nil || !clientOptions.TLSConfig.InsecureSkipVerify {
+		t.Error("expected TLS without certificate verification")
+	}
+	if *clientOptions.ConnectTimeout != 5*time.Second {
+		t.Errorf("wrong connect timeout: %s", *clientOptions.ConnectTimeout)
+	}
+	if *clientOptions.MaxPoolSize != cfg.DEFAULT_POOL_SIZE {
+		t.Errorf("wrong pool size: %d", *clientOptions.MaxPoolSize)
+	}
+}
+
+func TestRawFilters(t *testing.T) {
+	raw := &cfg.RawFilter{Query: `{"$or": [{"Length": 1}, {"$where": "sleep(1000)"}]}`}
+	if err := checkRawFilter(&cfg.DbConfig{Name: "msamp"}, raw); err == nil {
+		t.Error("expected raw filters to be disabled by default")
+	}
+	if err := checkRawFilter(&cfg.DbConfig{Name: "msamp", AllowRawFilters: true}, raw); err != nil {
+		t.Error(err)
+	}
+	if _, err := mongoFilter(raw); err == nil {
+		t.Error("expected $where to be rejected")
+	}
+}
+
+func TestMongoFilter(t *testing.T) {
+	filter := &cfg.LogicalFilter{
+		Operator: cfg.FILTER_AND,
+		Operands: []cfg.Filter{
+			&cfg.Comparison{Column: "Length", Operator: "<=", Value: int64(90)},
+			&cfg.NotFilter{Operand: &cfg.NullFilter{Column: "Rating"}},
+			&cfg.LikeFilter{Column: "Title", Pattern: `A\_%`, Negated: true},
+		},
+	}
+
+	query, err := mongoFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Length", Value: bson.D{{Key: "$lte", Value: int64(90)}}}},
+		bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "Rating", Value: nil}}}}},
+		bson.D{{Key: "Title", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: `^A_.*$`, Options: "s"}}}}},
+	}}}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("expected %v, got %v", expected, query)
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	columns := []orderColumn{{name: "title", collate: true}, {name: "film_id"}}
+	args := []interface{}{int64(30)}
+	condition := keysetCondition(columns, []interface{}{nil, int64(7)}, &args)
+
+	expectedCondition := `(("title" IS NOT NULL) OR ("title" IS NULL AND "film_id" > $2))`
+	if condition != expectedCondition {
+		t.Errorf("expected %s, got %s", expectedCondition, condition)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(30), int64(7)}) {
+		t.Errorf("wrong args: %v", args)
+	}
+
+	args = nil
+	condition = keysetCondition(columns, []interface{}{"Alien", int64(7)}, &args)
+	expectedCondition = `(("title" COLLATE "C" > $1) OR ("title" = $2 AND "film_id" > $3))`
+	if condition != expectedCondition {
+		t.Errorf("expected %s, got %s", expectedCondition, condition)
+	}
+	if orderBy := orderByClause(columns); orderBy != `"title" COLLATE "C" NULLS FIRST, "film_id" NULLS FIRST` {
+		t.Errorf("wrong order: %s", orderBy)
+	}
+}
+
+func TestSampleSchema(t *testing.T) {
+	documents := make([]bson.Raw, 0)
+	for _, document := range []bson.D{
+		{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "Title", Value: "Alien"}, {Key: "Length", Value: int32(117)}, {Key: "Rating", Value: nil}},
+		{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "Title", Value: "Heat"}, {Key: "Length", Value: 170.5}, {Key: "Tags", Value: bson.A{"crime"}}},
+	} {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, raw)
+	}
+
+	schema, err := sampleSchema("films", documents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Column{
+		{Name: "_id", Type: "objectID", Kind: KIND_OBJECT_ID, PrimaryKey: true},
+		{Name: "Title", Type: "string", Kind: KIND_STRING},
+		{Name: "Length", Type: "32-bit integer|double", Kind: KIND_DECIMAL},
+		{Name: "Rating", Type: "", Kind: KIND_UNKNOWN, Nullable: true},
+		{Name: "Tags", Type: "array", Kind: KIND_ARRAY, Nullable: true},
+	}
+	if !reflect.DeepEqual(schema.Columns, expected) {
+		t.Errorf("expected %+v, got %+v", expected, schema.Columns)
+	}
+	if postgresKind("ARRAY", "_text") != KIND_ARRAY || postgresKind("numeric", "numeric") != KIND_DECIMAL || postgresKind("USER-DEFINED", "mpaa_rating") != KIND_UNKNOWN {
+		t.Error("wrong PostgreSQL kinds")
+	}
+}
+
+func TestMongoSetFields(t *testing.T) {
+	ref, err := cfg.ParseColumnRef("docs.meta.actors[*].name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := mongoSetFields(ref.Column, ref.Path, primitive.A{"Penelope", "Nick"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.D{{Key: "meta.actors.0.name", Value: "Penelope"}, {Key: "meta.actors.1.name", Value: "Nick"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+
+	fields, _ = mongoSetFields("tags", []cfg.PathSegment{{IsIndex: true, Index: 2}}, "drama")
+	if !reflect.DeepEqual(fields, bson.D{{Key: "tags.2", Value: "drama"}}) {
+		t.Errorf("expected the third tag to be set, got %v", fields)
+	}
+	if _, err := mongoSetFields(ref.Column, ref.Path, "Penelope"); err == nil {
+		t.Error("expected an error setting a single value to all elements of an array")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{fmt.Errorf("write: %w", &pq.Error{Code: "40P01"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{mongo.CommandError{Labels: []string{"RetryableWriteError"}}, true},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, false},
+		{errors.New("syntax error"), false},
+	}
+	for _, c := range cases {
+		if isTransient(c.err) != c.transient {
+			t.Errorf("expected %v to be transient: %v", c.err, c.transient)
+		}
+	}
+	if dbErr := newWriteError("dvdrental", &pq.Error{Code: "08006"}, "film_id", 1); dbErr.Category() != apperr.CONNECTION {
+		t.Errorf("expected a lost connection to be a connection error, got %s", dbErr.Category())
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := RECONNECT_MIN_BACKOFF
+	for i := 0; i < 10; i++ {
+		backoff = nextBackoff(backoff, RECONNECT_MAX_BACKOFF)
+	}
+	if backoff != RECONNECT_MAX_BACKOFF {
+		t.Errorf("expected the backoff to be capped at %s, got %s", RECONNECT_MAX_BACKOFF, backoff)
+	}
+	if backoff := nextBackoff(time.Second, time.Minute); backoff != 2*time.Second {
+		t.Errorf("expected the backoff to double, got %s", backoff)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	var mux sync.Mutex
+	failingPings := 3
+	reconnects := 0
+	ping := func(ctx context.Context) error {
+		mux.Lock()
+		defer mux.Unlock()
+		if failingPings > 0 {
+			failingPings--
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	reconnect := func() error {
+		mux.Lock()
+		defer mux.Unlock()
+		reconnects++
+		return nil
+	}
+
+	health := newHealthChecker("test", time.Millisecond, ping, reconnect)
+	health.minBackoff = time.Millisecond
+	health.maxBackoff = 4 * time.Millisecond
+	go health.run()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mux.Lock()
+		recovered := failingPings == 0 && reconnects > 0
+		mux.Unlock()
+		if recovered && health.IsHealthy() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("health checker didn't reconnect the database")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	health.Stop()
+
+	// Each of the 3 failed pings is followed by a reconnect.
+	if reconnects != 3 {
+		t.Errorf("expected 3 reconnects, got %d", reconnects)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	readOnly := &cfg.DbConfig{Name: "erp", Mode: cfg.DB_MODE_READ_ONLY}
+	allowList := &cfg.DbConfig{Name: "dvdrental", WritableTables: []string{"film"}}
+	inDto := InsertDto{TableName: "film", KeyName: "film_id", KeyValue: int64(1), Values: map[string]interface{}{"title": "Alien"}}
+
+	// Writes are refused before a connection is even needed.
+	for _, database := range []Database{&postgresDatabase{cfg: readOnly}, &mongoDatabase{cfg: readOnly}} {
+		if err := database.Insert(context.Background(), inDto); apperr.CategoryOf(err) != apperr.CONFIG {
+			t.Errorf("expected an insert to read-only %T to be refused, got %v", database, err)
+		}
+		if err := database.ReplaceChildren(context.Background(), ChildrenDto{TableName: "film_actor"}); apperr.CategoryOf(err) != apperr.CONFIG {
+			t.Errorf("expected children of read-only %T not to be replaced, got %v", database, err)
+		}
+	}
+
+	if err := checkWritable(allowList, "film"); err != nil {
+		t.Errorf("expected an allowed table to be writable, got %v", err)
+	}
+	if err := checkWritable(allowList, "payment"); err == nil || !strings.Contains(err.Error(), "writable_tables") {
+		t.Errorf("expected a table missing from the allow-list not to be writable, got %v", err)
+	}
+	if err := checkWritable(&cfg.DbConfig{Name: "msamp"}, "payment"); err != nil {
+		t.Errorf("expected all tables to be writable by default, got %v", err)
+	}
+	mistyped := &cfg.DbConfig{Name: "erp", Mode: "readonly", WritableTables: []string{"film"}}
+	if err := checkWritable(mistyped, "film"); err == nil || !strings.Contains(err.Error(), "unknown mode \"readonly\"") {
+		t.Errorf("expected a database with an unknown mode not to be writable, got %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if err := NewRateLimiter("nil", nil).Do(context.Background(), func() error { return nil }); err != nil {
+		t.Errorf("expected a nil limiter not to limit writes, got %v", err)
+	}
+
+	limiter := NewRateLimiter("dvdrental", &cfg.RateLimit{OpsPerSecond: 100, MaxConcurrent: 2})
+	var mux sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Do(context.Background(), func() error {
+				mux.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mux.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mux.Lock()
+				running--
+				mux.Unlock()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 concurrent writes, got %d", maxRunning)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected 6 writes at 100 ops/s to take at least 50ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewRateLimiter("slow", &cfg.RateLimit{OpsPerSecond: 0.1})
+	slow.Do(ctx, func() error { return nil })
+	if err := slow.Do(ctx, func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a waiting write to be abandoned, got %v", err)
+	}
+
+	adaptive := NewRateLimiter("msamp", &cfg.RateLimit{OpsPerSecond: 1000, Adaptive: &cfg.AdaptiveRate{MinOpsPerSecond: 300}})
+	failing := errors.New("connection reset")
+	for i := 0; i < 2*ADAPTIVE_WINDOW; i++ {
+		adaptive.Do(context.Background(), func() error { return failing })
+	}
+	if rate := adaptive.GetRate(); rate != 300 {
+		t.Errorf("expected failing writes to lower the rate to the minimum, got %.2f", rate)
+	}
+	for i := 0; i < ADAPTIVE_WINDOW; i++ {
+		adaptive.Do(context.Background(), func() error { return nil })
+	}
+	if rate := adaptive.GetRate(); rate != 400 {
+		t.Errorf("expected successful writes to raise the rate by a step, got %.2f", rate)
+	}
+}
+
 func TestDbs(t *testing.T) {
 	os.Chdir("../../..")
 	dbs = make(Databases)
@@ -25,10 +444,15 @@ func TestMongoCRUD(t *testing.T) {
 	}
 
 	if database != nil {
-		database.Init()
+		if initErr := database.Init(); initErr != nil {
+			log.Fatalln(initErr)
+		}
 
 		// Select
-		rows := database.Select("Sakila_films", "{\"_id\":{\"$lt\": 3}}")
+		rows, selectErr := database.Select(context.Background(), "Sakila_films", &cfg.RawFilter{Query: "{\"_id\":{\"$lt\": 3}}"})
+		if selectErr != nil {
+			log.Fatalln(selectErr)
+		}
 		log.Println(len(rows))
 
 		// Insert
@@ -40,25 +464,25 @@ func TestMongoCRUD(t *testing.T) {
 			"ext_id":      1001,
 		}
 		inDto := InsertDto{
-			"Sakila_films",
-			"_id",
-			1,
-			row,
+			TableName: "Sakila_films",
+			KeyName:   "_id",
+			KeyValue:  1,
+			Values:    row,
 		}
-		insertErr := database.Insert(inDto)
+		insertErr := database.Insert(context.Background(), inDto)
 		if insertErr != nil {
 			log.Fatalln(insertErr)
 		}
 
 		// Update
 		upDto := UpdateDto{
-			"Sakila_films",
-			"_id",
-			6,
-			"Rating",
-			"test",
+			TableName:         "Sakila_films",
+			KeyName:           "_id",
+			KeyValue:          6,
+			UpdatedColumnName: "Rating",
+			NewValue:          "test",
 		}
-		updateErr := database.Update(upDto)
+		updateErr := database.Update(context.Background(), upDto)
 		if updateErr != nil {
 			log.Fatalln(updateErr)
 		}
@@ -76,10 +500,21 @@ func TestPostgresCRUD(t *testing.T) {
 	}
 
 	if database != nil {
-		database.Init()
+		if initErr := database.Init(); initErr != nil {
+			log.Fatalln(initErr)
+		}
 
 		// Select
-		rows := database.Select("film", "film_id > 10 AND film_id < 22")
+		rows, selectErr := database.Select(context.Background(), "film", &cfg.LogicalFilter{
+			Operator: cfg.FILTER_AND,
+			Operands: []cfg.Filter{
+				&cfg.Comparison{Column: "film_id", Operator: ">", Value: int64(10)},
+				&cfg.Comparison{Column: "film_id", Operator: "<", Value: int64(22)},
+			},
+		})
+		if selectErr != nil {
+			log.Fatalln(selectErr)
+		}
 		log.Println(len(rows))
 
 		// Insert
@@ -91,25 +526,25 @@ func TestPostgresCRUD(t *testing.T) {
 			"language_id":  2,
 		}
 		inDto := InsertDto{
-			"Sakila_films",
-			"_id",
-			1,
-			row,
+			TableName: "Sakila_films",
+			KeyName:   "_id",
+			KeyValue:  1,
+			Values:    row,
 		}
-		insertErr := database.Insert(inDto)
+		insertErr := database.Insert(context.Background(), inDto)
 		if insertErr != nil {
 			log.Fatalln(insertErr)
 		}
 
 		// Update
 		upDto := UpdateDto{
-			"Sakila_films",
-			"_id",
-			6,
-			"Rating",
-			"test",
+			TableName:         "Sakila_films",
+			KeyName:           "_id",
+			KeyValue:          6,
+			UpdatedColumnName: "Rating",
+			NewValue:          "test",
 		}
-		updateErr := database.Update(upDto)
+		updateErr := database.Update(context.Background(), upDto)
 		if updateErr != nil {
 			log.Fatalln(updateErr)
 		}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	RECONNECT_MIN_BACKOFF = 1 * time.Second
+	RECONNECT_MAX_BACKOFF = 1 * time.Minute
+	PING_TIMEOUT          = 5 * time.Second
+)
+
+// healthChecker periodically pings a database. When a ping fails,
+// it keeps reconnecting with an exponential backoff until the database is back.
+type healthChecker struct {
+	mux        sync.RWMutex
+	dbName     string
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	ping       func(ctx context.Context) error
+	reconnect  func() error
+	healthy    bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func startHealthChecker(dbName string, interval time.Duration, ping func(ctx context.Context) error, reconnect func() error) *healthChecker {
+	h := newHealthChecker(dbName, interval, ping, reconnect)
+	go h.run()
+	return h
+}
+
+func newHealthChecker(dbName string, interval time.Duration, ping func(ctx context.Context) error, reconnect func() error) *healthChecker {
+	return &healthChecker{
+		dbName:     dbName,
+		interval:   interval,
+		minBackoff: RECONNECT_MIN_BACKOFF,
+		maxBackoff: RECONNECT_MAX_BACKOFF,
+		ping:       ping,
+		reconnect:  reconnect,
+		healthy:    true,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-time.After(h.interval):
+		}
+
+		if err := h.check(); err != nil {
+			log.Printf("[health check] database %s is unreachable: %s\n", h.dbName, err)
+			if !h.reconnectUntilHealthy() {
+				return
+			}
+			log.Printf("[health check] database %s reconnected\n", h.dbName)
+		}
+	}
+}
+
+func (h *healthChecker) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+
+	err := h.ping(ctx)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.healthy = err == nil
+	return err
+}
+
+// reconnectUntilHealthy reconnects until a ping succeeds. It returns false if the checker got stopped.
+func (h *healthChecker) reconnectUntilHealthy() bool {
+	backoff := h.minBackoff
+	for {
+		select {
+		case <-h.stop:
+			return false
+		case <-time.After(backoff):
+		}
+
+		if err := h.reconnect(); err != nil {
+			log.Printf("[health check] database %s: reconnect failed: %s\n", h.dbName, err)
+		} else if h.check() == nil {
+			return true
+		}
+		backoff = nextBackoff(backoff, h.maxBackoff)
+	}
+}
+
+// nextBackoff doubles the backoff up to the given maximum.
+func nextBackoff(backoff time.Duration, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// IsHealthy returns the result of the last health check.
+func (h *healthChecker) IsHealthy() bool {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return h.healthy
+}
+
+// Stop stops the health checks and waits for the checker to finish.
+func (h *healthChecker) Stop() {
+	close(h.stop)
+	<-h.done
+}
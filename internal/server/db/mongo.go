@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,62 +15,128 @@ import (
 )
 
 // MongoDatabase implements Database interface for MongoDB database.
+// All queries share one client, which is connected on the first Init call.
 type mongoDatabase struct {
-	cfg              *cfg.DbConfig
-	connectionString string
-	ctx              context.Context
-	close            context.CancelFunc
+	mux    sync.RWMutex
+	cfg    *cfg.DbConfig
+	client *mongo.Client
+	health *healthChecker
 }
 
-// CloseConnection closes the db connection.
-func (d *mongoDatabase) CloseConnection() {
-	d.close()
+// GetClient returns the connected client.
+func (d *mongoDatabase) GetClient() (*mongo.Client, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	if d.client == nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: "client isn't connected", Cat: apperr.CONNECTION}
+	}
+	return d.client, nil
 }
 
-// GetClient returns a connection client object.
-func (d *mongoDatabase) GetClient() *mongo.Client {
-	authCredentials := options.Credential{Username: d.cfg.User, Password: d.cfg.Password}
-	clientOptions := options.Client().ApplyURI(d.connectionString).SetAuth(authCredentials)
-	client, err := mongo.NewClient(clientOptions)
+// GetConfig returns information about the database, which was parsed from JSON.
+func (d *mongoDatabase) GetConfig() *cfg.DbConfig {
+	return d.cfg
+}
+
+// Init connects the client and starts its health checks.
+// Subsequent calls only test the connection.
+func (d *mongoDatabase) Init() error {
+	d.mux.Lock()
+	if d.client == nil {
+		client, err := d.connect()
+		if err != nil {
+			d.mux.Unlock()
+			return err
+		}
+		d.client = client
+		d.health = startHealthChecker(d.cfg.GetName(), d.cfg.GetHealthCheckInterval(), d.ping, d.reconnect)
+		log.Printf("Connected a client to database %s.\n", d.cfg.GetName())
+	}
+	d.mux.Unlock()
+
+	return d.TestConnection()
+}
+
+func (d *mongoDatabase) connect() (*mongo.Client, error) {
+	clientOptions, err := mongoClientOptions(d.cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
 	}
-	err = client.Connect(d.ctx)
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
 	}
-	return client
+
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	return client, nil
 }
 
-// GetConfig returns information about the database, which was parsed from JSON.
-func (d *mongoDatabase) GetConfig() *cfg.DbConfig {
-	return d.cfg
+// reconnect replaces the client with a newly connected one.
+func (d *mongoDatabase) reconnect() error {
+	client, err := d.connect()
+	if err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	oldClient := d.client
+	d.client = client
+	d.mux.Unlock()
+
+	if oldClient != nil {
+		disconnect(oldClient)
+	}
+	return nil
 }
 
-// Init creates the db connection string and context object.
-func (d *mongoDatabase) Init() {
-	d.connectionString = fmt.Sprintf(`mongodb://%s:%d/%s`,
-		d.cfg.Host,
-		d.cfg.Port,
-		d.cfg.Name,
-	)
-	ctx, cancel := context.WithCancel(context.Background())
+// Close stops the health checks and disconnects the client.
+func (d *mongoDatabase) Close() error {
+	d.mux.Lock()
+	client, health := d.client, d.health
+	d.client, d.health = nil, nil
+	d.mux.Unlock()
 
-	d.ctx = ctx
-	d.close = cancel
+	if health != nil {
+		health.Stop()
+	}
+	if client == nil {
+		return nil
+	}
+	if err := disconnect(client); err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	return nil
+}
 
-	d.TestConnection()
+func disconnect(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+	return client.Disconnect(ctx)
 }
 
 // Insert inserts one row into a given collection.
-func (d *mongoDatabase) Insert(inDto InsertDto) error {
+func (d *mongoDatabase) Insert(ctx context.Context, inDto InsertDto) error {
+	if err := checkWritable(d.cfg, inDto.TableName); err != nil {
+		return err
+	}
+
 	fmt.Println(inDto)
-	client := d.GetClient()
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return err
+	}
 	collection := client.Database(d.cfg.Name).Collection(inDto.TableName)
 
-	insertResult, err := collection.InsertOne(context.TODO(), inDto.Values)
+	insertResult, err := collection.InsertOne(ctx, inDto.Values)
 	if err != nil {
-		dbErr := &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), KeyName: inDto.KeyName, KeyValue: inDto.KeyValue}
+		dbErr := newWriteError(d.cfg.Name, err, inDto.KeyName, inDto.KeyValue)
 		return dbErr
 	}
 	if insertResult.InsertedID == nil {
@@ -80,67 +148,169 @@ func (d *mongoDatabase) Insert(inDto InsertDto) error {
 }
 
 // Select selects data from the database, with or without filters.
-func (d *mongoDatabase) Select(tableName string, conditions string) []map[string]interface{} {
+func (d *mongoDatabase) Select(ctx context.Context, tableName string, filter cfg.Filter) ([]map[string]interface{}, error) {
 	var allDocuments []map[string]interface{}
 
-	client := d.GetClient()
+	if err := checkRawFilter(d.cfg, filter); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return nil, err
+	}
 	collection := client.Database(d.cfg.Name).Collection(tableName)
 
-	var bsonConditions interface{}
-	if conditions != "" {
-		err := bson.UnmarshalExtJSON([]byte(conditions), true, &bsonConditions)
+	var bsonConditions interface{} = bson.M{}
+	if filter != nil {
+		bsonConditions, err = mongoFilter(filter)
 		if err != nil {
-			panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
 		}
-	} else {
-		bsonConditions = bson.M{}
 	}
 
-	cur, err := collection.Find(d.ctx, bsonConditions)
+	cur, err := collection.Find(ctx, bsonConditions)
 	if err != nil {
-		log.Fatal(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
 	}
+	defer cur.Close(context.Background())
 
-	for cur.Next(context.TODO()) {
+	for cur.Next(ctx) {
 		var documentMap map[string]interface{} = make(map[string]interface{})
 		err := cur.Decode(documentMap)
 		if err != nil {
-			log.Fatal(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
 		}
 		allDocuments = append(allDocuments, documentMap)
 	}
+	if err := cur.Err(); err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+
+	return allDocuments, nil
+}
+
+// Iterate selects documents page by page using a single cursor.
+func (d *mongoDatabase) Iterate(ctx context.Context, tableName string, opts SelectOptions) (RecordIterator, error) {
+	if err := checkRawFilter(d.cfg, opts.Filter); err != nil {
+		return nil, err
+	}
+	if len(opts.OrderBy) == 0 {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: "records can't be iterated without an order", Cat: apperr.CONFIG}
+	}
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(d.cfg.Name).Collection(tableName)
+
+	var bsonConditions interface{} = bson.M{}
+	if opts.Filter != nil {
+		bsonConditions, err = mongoFilter(opts.Filter)
+		if err != nil {
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
+		}
+	}
+
+	// MongoDB sorts missing fields and nulls first and compares strings byte by byte.
+	sort := bson.D{}
+	for _, column := range opts.OrderBy {
+		sort = append(sort, bson.E{Key: column, Value: 1})
+	}
+	findOptions := options.Find().SetSort(sort).SetBatchSize(int32(opts.GetPageSize()))
 
-	defer cur.Close(context.TODO())
+	cur, err := collection.Find(ctx, bsonConditions, findOptions)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	return &mongoIterator{db: d, cursor: cur, pageSize: opts.GetPageSize()}, nil
+}
+
+// Describe infers the collection's schema from a random sample of its documents.
+func (d *mongoDatabase) Describe(ctx context.Context, tableName string) (*TableSchema, error) {
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(d.cfg.Name).Collection(tableName)
+
+	pipeline := mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: SCHEMA_SAMPLE_SIZE}}}}}
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	defer cur.Close(context.Background())
+
+	documents := make([]bson.Raw, 0, SCHEMA_SAMPLE_SIZE)
+	for cur.Next(ctx) {
+		// The current document is only valid until the next call to Next.
+		documents = append(documents, append(bson.Raw(nil), cur.Current...))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
 
-	return allDocuments
+	schema, err := sampleSchema(tableName, documents)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	return schema, nil
 }
 
 // TestConnection pings the database.
-func (d *mongoDatabase) TestConnection() {
-	c := d.GetClient()
-	err := c.Ping(context.Background(), readpref.Primary())
+func (d *mongoDatabase) TestConnection() error {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+
+	return d.ping(ctx)
+}
+
+func (d *mongoDatabase) ping(ctx context.Context) error {
+	client, err := d.GetClient()
 	if err != nil {
-		log.Fatal("Couldn't connect to the database", err)
-	} else {
-		log.Println("Connected!")
+		return err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: "couldn't connect to the database: " + err.Error(), Cat: apperr.CONNECTION}
 	}
+	return nil
 }
 
 // Update updates a document with the provided key.
-func (d *mongoDatabase) Update(upDto UpdateDto) error {
+func (d *mongoDatabase) Update(ctx context.Context, upDto UpdateDto) error {
+	if err := checkWritable(d.cfg, upDto.TableName); err != nil {
+		return err
+	}
+
 	fmt.Println(upDto)
-	client := d.GetClient()
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return err
+	}
 	collection := client.Database(d.cfg.Name).Collection(upDto.TableName)
-	filter := bson.D{{upDto.KeyName, upDto.KeyValue}}
+	fields, err := mongoSetFields(upDto.UpdatedColumnName, upDto.UpdatedPath, upDto.NewValue)
+	if err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), KeyName: upDto.KeyName, KeyValue: upDto.KeyValue, Cat: apperr.MAPPING}
+	}
+	filter := bson.D{{Key: upDto.KeyName, Value: upDto.KeyValue}}
 	update := bson.D{
-		{"$set", bson.D{
-			{upDto.UpdatedColumnName, upDto.NewValue},
-		}},
+		{Key: "$set", Value: fields},
 	}
 
-	updateResult, err := collection.UpdateOne(context.TODO(), filter, update)
+	updateResult, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		dbErr := &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), KeyName: upDto.KeyName, KeyValue: upDto.KeyValue}
+		dbErr := newWriteError(d.cfg.Name, err, upDto.KeyName, upDto.KeyValue)
 		return dbErr
 	}
 	if updateResult.MatchedCount == 0 {
@@ -154,3 +324,62 @@ func (d *mongoDatabase) Update(upDto UpdateDto) error {
 
 	return nil
 }
+
+// Upsert sets the fields of the document with the match key or inserts it, if there isn't one.
+func (d *mongoDatabase) Upsert(ctx context.Context, inDto InsertDto) error {
+	if err := checkWritable(d.cfg, inDto.TableName); err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return err
+	}
+	collection := client.Database(d.cfg.Name).Collection(inDto.TableName)
+
+	filter := bson.D{{Key: inDto.KeyName, Value: inDto.KeyValue}}
+	update := bson.D{
+		{Key: "$set", Value: inDto.GetKeyedValues()},
+	}
+	if _, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return newWriteError(d.cfg.Name, err, inDto.KeyName, inDto.KeyValue)
+	}
+	return nil
+}
+
+// ReplaceChildren deletes the documents referencing a parent document and inserts the given
+// ones in their place. MongoDB can't do it atomically without a replica set.
+func (d *mongoDatabase) ReplaceChildren(ctx context.Context, chDto ChildrenDto) error {
+	if err := checkWritable(d.cfg, chDto.TableName); err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	client, err := d.GetClient()
+	if err != nil {
+		return err
+	}
+	collection := client.Database(d.cfg.Name).Collection(chDto.TableName)
+
+	if _, err := collection.DeleteMany(ctx, bson.D{{Key: chDto.ParentKeyName, Value: chDto.ParentKeyValue}}); err != nil {
+		return newWriteError(d.cfg.Name, err, chDto.ParentKeyName, chDto.ParentKeyValue)
+	}
+
+	children := chDto.GetChildren()
+	if len(children) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(children))
+	for i, child := range children {
+		documents[i] = child
+	}
+	if _, err := collection.InsertMany(ctx, documents); err != nil {
+		return newWriteError(d.cfg.Name, err, chDto.ParentKeyName, chDto.ParentKeyValue)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var mongoOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+// mongoFilter translates a filter into a MongoDB query document.
+func mongoFilter(filter cfg.Filter) (interface{}, error) {
+	switch f := filter.(type) {
+	case *cfg.Comparison:
+		return bson.D{{Key: f.Column, Value: bson.D{{Key: mongoOperators[f.Operator], Value: f.Value}}}}, nil
+	case *cfg.LogicalFilter:
+		operands := make(bson.A, len(f.Operands))
+		for i, operand := range f.Operands {
+			query, err := mongoFilter(operand)
+			if err != nil {
+				return nil, err
+			}
+			operands[i] = query
+		}
+		return bson.D{{Key: "$" + strings.ToLower(f.Operator), Value: operands}}, nil
+	case *cfg.NotFilter:
+		// $not can only be applied to a single field, so $nor is used instead.
+		query, err := mongoFilter(f.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "$nor", Value: bson.A{query}}}, nil
+	case *cfg.InFilter:
+		operator := "$in"
+		if f.Negated {
+			operator = "$nin"
+		}
+		return bson.D{{Key: f.Column, Value: bson.D{{Key: operator, Value: bson.A(f.Values)}}}}, nil
+	case *cfg.NullFilter:
+		// Missing fields are considered null, same as in SQL outer joins.
+		if f.Negated {
+			return bson.D{{Key: f.Column, Value: bson.D{{Key: "$ne", Value: nil}}}}, nil
+		}
+		return bson.D{{Key: f.Column, Value: nil}}, nil
+	case *cfg.LikeFilter:
+		regex := primitive.Regex{Pattern: likeToRegex(f.Pattern), Options: "s"}
+		if f.Negated {
+			return bson.D{{Key: f.Column, Value: bson.D{{Key: "$not", Value: regex}}}}, nil
+		}
+		return bson.D{{Key: f.Column, Value: regex}}, nil
+	case *cfg.RawFilter:
+		var query interface{}
+		if err := bson.UnmarshalExtJSON([]byte(f.Query), true, &query); err != nil {
+			return nil, err
+		}
+		if err := checkMongoOperators(query); err != nil {
+			return nil, err
+		}
+		return query, nil
+	}
+	return nil, fmt.Errorf("unsupported filter %T", filter)
+}
+
+// mongoJSOperators execute JavaScript on the server, so they're never accepted in raw conditions.
+var mongoJSOperators = []string{"$where", "$function", "$accumulator"}
+
+// checkMongoOperators looks for forbidden operators anywhere in a raw query.
+func checkMongoOperators(query interface{}) error {
+	switch q := query.(type) {
+	case primitive.D:
+		for _, elem := range q {
+			if util.StringSliceContains(mongoJSOperators, elem.Key) {
+				return fmt.Errorf("operator %s isn't allowed in raw conditions", elem.Key)
+			}
+			if err := checkMongoOperators(elem.Value); err != nil {
+				return err
+			}
+		}
+	case primitive.M:
+		for key, value := range q {
+			if util.StringSliceContains(mongoJSOperators, key) {
+				return fmt.Errorf("operator %s isn't allowed in raw conditions", key)
+			}
+			if err := checkMongoOperators(value); err != nil {
+				return err
+			}
+		}
+	case primitive.A:
+		for _, value := range q {
+			if err := checkMongoOperators(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// likeToRegex converts an SQL LIKE pattern into an anchored regular expression.
+// A backslash escapes the following character, like in PostgreSQL.
+func likeToRegex(pattern string) string {
+	var regex strings.Builder
+	regex.WriteString("^")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			regex.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			regex.WriteString(".*")
+		case r == '_':
+			regex.WriteString(".")
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		regex.WriteString(regexp.QuoteMeta(`\`))
+	}
+
+	regex.WriteString("$")
+	return regex.String()
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongoIterator reads documents from a cursor, which
+// fetches them from the server in batches of the page size.
+type mongoIterator struct {
+	db       *mongoDatabase
+	cursor   *mongo.Cursor
+	pageSize int
+}
+
+// Next reads the next page of documents.
+func (it *mongoIterator) Next(ctx context.Context) ([]map[string]interface{}, error) {
+	ctx, cancel := withQueryTimeout(ctx, it.db.cfg)
+	defer cancel()
+
+	page := make([]map[string]interface{}, 0, it.pageSize)
+	for len(page) < it.pageSize && it.cursor.Next(ctx) {
+		document := make(map[string]interface{})
+		if err := it.cursor.Decode(document); err != nil {
+			return nil, &DatabaseError{DBName: it.db.cfg.Name, ErrMsg: err.Error()}
+		}
+		page = append(page, document)
+	}
+	if err := it.cursor.Err(); err != nil {
+		return nil, &DatabaseError{DBName: it.db.cfg.Name, ErrMsg: err.Error()}
+	}
+	return page, nil
+}
+
+// Close closes the cursor on the server.
+func (it *mongoIterator) Close() error {
+	return it.cursor.Close(context.Background())
+}
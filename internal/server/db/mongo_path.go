@@ -0,0 +1,55 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mongoSetFields builds the fields of a $set, which writes a value to a nested path.
+// Paths are written in the dot notation, e.g. meta.rating or tags.0.name. A "[*]"
+// segment requires an array value, whose elements are set at their indexes.
+func mongoSetFields(column string, path []cfg.PathSegment, value interface{}) (bson.D, error) {
+	fields := bson.D{}
+	if err := appendSetFields(&fields, column, path, value); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func appendSetFields(fields *bson.D, prefix string, path []cfg.PathSegment, value interface{}) error {
+	for i, segment := range path {
+		switch {
+		case !segment.IsIndex:
+			prefix += "." + segment.Field
+		case segment.Index != cfg.ANY_INDEX:
+			prefix += "." + strconv.Itoa(segment.Index)
+		default:
+			elements, isArray := arrayElements(value)
+			if !isArray {
+				return fmt.Errorf("value %v written to %s[*] isn't an array", value, prefix)
+			}
+			for index, element := range elements {
+				if err := appendSetFields(fields, prefix+"."+strconv.Itoa(index), path[i+1:], element); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	*fields = append(*fields, bson.E{Key: prefix, Value: value})
+	return nil
+}
+
+func arrayElements(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case primitive.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
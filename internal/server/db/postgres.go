@@ -1,19 +1,29 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
-	_ "github.com/lib/pq"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"github.com/lib/pq"
 )
 
 // PostgresDatabase implements Database interface for PostgreSQL database.
+// All queries share one connection pool, which is opened on the first Init call.
 type postgresDatabase struct {
+	mux              sync.RWMutex
 	cfg              *cfg.DbConfig
 	connectionString string
+	pool             *sql.DB
+	health           *healthChecker
 }
 
 // GetConfig returns information about the database, which was parsed from JSON.
@@ -21,75 +31,343 @@ func (d *postgresDatabase) GetConfig() *cfg.DbConfig {
 	return d.cfg
 }
 
-// Init creates the db connection string.
-func (d *postgresDatabase) Init() {
-	d.connectionString = fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		d.cfg.Host, d.cfg.Port, d.cfg.User, d.cfg.Password, d.cfg.Name)
+// Init opens the connection pool and starts its health checks.
+// Subsequent calls only test the connection.
+func (d *postgresDatabase) Init() error {
+	d.mux.Lock()
+	if d.pool == nil {
+		connectionString, err := postgresConnectionString(d.cfg)
+		if err != nil {
+			d.mux.Unlock()
+			return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
+		}
+		d.connectionString = connectionString
+
+		pool, err := d.openPool()
+		if err != nil {
+			d.mux.Unlock()
+			return err
+		}
+		d.pool = pool
+		d.health = startHealthChecker(d.cfg.GetName(), d.cfg.GetHealthCheckInterval(), d.ping, d.reconnect)
+		log.Printf("Opened a connection pool to database %s.\n", d.cfg.GetName())
+	}
+	d.mux.Unlock()
+
+	return d.TestConnection()
+}
 
-	d.TestConnection()
+func (d *postgresDatabase) openPool() (*sql.DB, error) {
+	pool, err := sql.Open("postgres", d.connectionString)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	pool.SetMaxOpenConns(d.cfg.GetPoolSize())
+	pool.SetMaxIdleConns(d.cfg.GetPoolSize())
+	return pool, nil
+}
+
+// getPool returns the connection pool or an error if it isn't open.
+func (d *postgresDatabase) getPool() (*sql.DB, error) {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	if d.pool == nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: "connection pool isn't open", Cat: apperr.CONNECTION}
+	}
+	return d.pool, nil
+}
+
+// reconnect replaces the connection pool with a new one.
+func (d *postgresDatabase) reconnect() error {
+	pool, err := d.openPool()
+	if err != nil {
+		return err
+	}
+
+	d.mux.Lock()
+	oldPool := d.pool
+	d.pool = pool
+	d.mux.Unlock()
+
+	if oldPool != nil {
+		oldPool.Close()
+	}
+	return nil
+}
+
+// Close stops the health checks and closes the connection pool.
+func (d *postgresDatabase) Close() error {
+	d.mux.Lock()
+	pool, health := d.pool, d.health
+	d.pool, d.health = nil, nil
+	d.mux.Unlock()
+
+	if health != nil {
+		health.Stop()
+	}
+	if pool == nil {
+		return nil
+	}
+	if err := pool.Close(); err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	return nil
 }
 
 // Insert inserts one row into a given table.
-func (d *postgresDatabase) Insert(inDto InsertDto) error {
-	database, err := sql.Open("postgres", d.connectionString)
+func (d *postgresDatabase) Insert(ctx context.Context, inDto InsertDto) error {
+	if err := checkWritable(d.cfg, inDto.TableName); err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer database.Close()
 
+	query, valuesList := insertQuery(inDto.TableName, inDto.Values)
+
+	result, err := database.ExecContext(ctx, query, valuesList...)
+	if err != nil {
+		return newWriteError(d.cfg.Name, err, inDto.KeyName, inDto.KeyValue)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return newWriteError(d.cfg.Name, err, inDto.KeyName, inDto.KeyValue)
+	}
+	if rowsAffected == 0 {
+		dbErr := &DatabaseError{DBName: d.cfg.Name, ErrMsg: "row hasn't been inserted" /* , KeyName: keyName, KeyValue: keyVal */}
+		return dbErr
+	}
+
+	return nil
+}
+
+// insertQuery builds a query inserting one row with the given values.
+func insertQuery(tableName string, values map[string]interface{}) (string, []interface{}) {
 	var columnList []string = make([]string, 0)
 	var valuesList []interface{} = make([]interface{}, 0)
 	var valuesPlaceholderList []string = make([]string, 0)
 	var valuesCounter int64 = 1
 
-	for key, val := range inDto.Values {
+	for _, key := range sortedColumns(values) {
 		valuesCounterStr := strconv.FormatInt(valuesCounter, 10)
 
-		columnList = append(columnList, key)
-		valuesList = append(valuesList, val)
+		columnList = append(columnList, pq.QuoteIdentifier(key))
+		valuesList = append(valuesList, values[key])
 		valuesPlaceholderList = append(valuesPlaceholderList, "$"+valuesCounterStr)
 		valuesCounter++
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s)", inDto.TableName, strings.Join(columnList, ", "), strings.Join(valuesPlaceholderList, ", "))
+	query := fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s)", quoteTableName(tableName), strings.Join(columnList, ", "), strings.Join(valuesPlaceholderList, ", "))
+	return query, valuesList
+}
+
+// upsertQuery builds a query inserting one row or updating the row, which has the same value
+// in the key column. The key column needs a unique constraint for conflicts to be detected.
+func upsertQuery(tableName string, keyName string, values map[string]interface{}) (string, []interface{}) {
+	query, valuesList := insertQuery(tableName, values)
+
+	assignments := make([]string, 0, len(values))
+	for _, column := range sortedColumns(values) {
+		if column != keyName {
+			assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", pq.QuoteIdentifier(column), pq.QuoteIdentifier(column)))
+		}
+	}
+	if len(assignments) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING", query, pq.QuoteIdentifier(keyName)), valuesList
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", query, pq.QuoteIdentifier(keyName), strings.Join(assignments, ", ")), valuesList
+}
+
+func sortedColumns(values map[string]interface{}) []string {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// Upsert inserts a row or updates the row with the same match key in one statement,
+// so rows created by other writers in the meantime aren't duplicated.
+func (d *postgresDatabase) Upsert(ctx context.Context, inDto InsertDto) error {
+	if err := checkWritable(d.cfg, inDto.TableName); err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
 
-	result, err := database.Exec(query, valuesList...)
+	database, err := d.getPool()
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	query, valuesList := upsertQuery(inDto.TableName, inDto.KeyName, inDto.GetKeyedValues())
+	if _, err := database.ExecContext(ctx, query, valuesList...); err != nil {
+		return newWriteError(d.cfg.Name, err, inDto.KeyName, inDto.KeyValue)
+	}
+	return nil
+}
+
+// ReplaceChildren deletes the rows referencing a parent row
+// and inserts the given ones in their place in one transaction.
+func (d *postgresDatabase) ReplaceChildren(ctx context.Context, chDto ChildrenDto) error {
+	if err := checkWritable(d.cfg, chDto.TableName); err != nil {
+		return err
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		dbErr := &DatabaseError{DBName: d.cfg.Name, ErrMsg: "row hasn't been inserted" /* , KeyName: keyName, KeyValue: keyVal */}
-		return dbErr
+
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", quoteTableName(chDto.TableName), pq.QuoteIdentifier(chDto.ParentKeyName))
+	if _, err := tx.ExecContext(ctx, query, chDto.ParentKeyValue); err != nil {
+		return newWriteError(d.cfg.Name, err, chDto.ParentKeyName, chDto.ParentKeyValue)
+	}
+	for _, child := range chDto.GetChildren() {
+		query, values := insertQuery(chDto.TableName, child)
+		if _, err := tx.ExecContext(ctx, query, values...); err != nil {
+			return newWriteError(d.cfg.Name, err, chDto.ParentKeyName, chDto.ParentKeyValue)
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return newWriteError(d.cfg.Name, err, chDto.ParentKeyName, chDto.ParentKeyValue)
+	}
 	return nil
 }
 
 // Select selects data from the database, with or without a WHERE clause.
-func (d *postgresDatabase) Select(tableName string, conditions string) []map[string]interface{} {
+func (d *postgresDatabase) Select(ctx context.Context, tableName string, filter cfg.Filter) ([]map[string]interface{}, error) {
+	if err := checkRawFilter(d.cfg, filter); err != nil {
+		return nil, err
+	}
+
+	var conditions string
+	var args []interface{}
+	if filter != nil {
+		where, err := postgresFilter(filter, &args)
+		if err != nil {
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
+		}
+		conditions = fmt.Sprintf(" WHERE %s", where)
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s%s", quoteTableName(tableName), conditions)
+	return d.query(ctx, query, args)
+}
+
+// Iterate selects data page by page. Every page is selected with a separate
+// query, which continues after the last record of the previous page.
+func (d *postgresDatabase) Iterate(ctx context.Context, tableName string, opts SelectOptions) (RecordIterator, error) {
+	if err := checkRawFilter(d.cfg, opts.Filter); err != nil {
+		return nil, err
+	}
+	if len(opts.OrderBy) == 0 {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: "records can't be iterated without an order", Cat: apperr.CONFIG}
+	}
+
+	iterator := &postgresIterator{
+		db:        d,
+		tableName: tableName,
+		pageSize:  opts.GetPageSize(),
+	}
+	if opts.Filter != nil {
+		where, err := postgresFilter(opts.Filter, &iterator.filterArgs)
+		if err != nil {
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONFIG}
+		}
+		iterator.filter = where
+	}
+
+	columns, err := d.orderColumns(ctx, tableName, opts.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	iterator.orderBy = columns
+
+	return iterator, nil
+}
+
+// orderColumns checks the types of the columns, so that
+// strings can be sorted byte by byte regardless of their collation.
+func (d *postgresDatabase) orderColumns(ctx context.Context, tableName string, columnNames []string) ([]orderColumn, error) {
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
+	if err != nil {
+		return nil, err
+	}
+
+	quotedNames := make([]string, len(columnNames))
+	for i, name := range columnNames {
+		quotedNames[i] = pq.QuoteIdentifier(name)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s LIMIT 0", strings.Join(quotedNames, ", "), quoteTableName(tableName))
+
+	rows, err := database.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	columns := make([]orderColumn, len(columnNames))
+	for i, name := range columnNames {
+		columns[i] = orderColumn{name: name, collate: util.StringSliceContains(collatableTypes, columnTypes[i].DatabaseTypeName())}
+	}
+	return columns, nil
+}
+
+// query runs a select query, which is prepared in a read only transaction,
+// so that raw conditions can neither modify data nor smuggle in additional statements.
+func (d *postgresDatabase) query(ctx context.Context, query string, args []interface{}) ([]map[string]interface{}, error) {
 	var allRecords []map[string]interface{}
 
-	database, err := sql.Open("postgres", d.connectionString)
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
 	if err != nil {
-		panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+		return nil, err
 	}
-	defer database.Close()
 
-	if conditions != "" {
-		conditions = fmt.Sprintf(" WHERE %s", conditions)
+	tx, err := database.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
 	}
+	defer tx.Rollback()
 
-	query := fmt.Sprintf("SELECT * FROM %s%s", tableName, conditions)
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	defer stmt.Close()
 
-	rows, err := database.Query(query)
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
 	}
+	defer rows.Close()
 
 	cols, _ := rows.Columns()
 
@@ -104,7 +382,7 @@ func (d *postgresDatabase) Select(tableName string, conditions string) []map[str
 
 		// Scan the result into the column pointers.
 		if err := rows.Scan(columnPointers...); err != nil {
-			panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
 		}
 
 		// Create our map, and retrieve the value for each column from the pointers slice,
@@ -118,43 +396,110 @@ func (d *postgresDatabase) Select(tableName string, conditions string) []map[str
 		// Outputs: map[columnName:value columnName2:value2 columnName3:value3 ...]
 		allRecords = append(allRecords, record)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
 
-	return allRecords
+	return allRecords, nil
 }
 
-// TestConnection pings the database.
-func (d *postgresDatabase) TestConnection() {
-	database, err := sql.Open("postgres", d.connectionString)
+// Describe reads the table's columns from information_schema.
+// The table name is resolved with to_regclass, so unqualified names follow the search_path.
+func (d *postgresDatabase) Describe(ctx context.Context, tableName string) (*TableSchema, error) {
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
 	if err != nil {
-		panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+		return nil, err
 	}
-	defer database.Close()
 
-	err = database.Ping()
+	query := `SELECT c.column_name, c.data_type, c.udt_name, c.is_nullable = 'YES',
+			EXISTS (SELECT 1 FROM information_schema.table_constraints tc
+				JOIN information_schema.key_column_usage k ON k.constraint_schema = tc.constraint_schema AND k.constraint_name = tc.constraint_name
+				WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema AND tc.table_name = c.table_name AND k.column_name = c.column_name),
+			EXISTS (SELECT 1 FROM pg_catalog.pg_index i
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+				WHERE i.indrelid = r.oid AND i.indisunique AND i.indimmediate AND i.indnatts = 1 AND i.indpred IS NULL AND a.attname = c.column_name)
+		FROM information_schema.columns c
+		JOIN pg_catalog.pg_namespace n ON n.nspname = c.table_schema
+		JOIN pg_catalog.pg_class r ON r.relnamespace = n.oid AND r.relname = c.table_name
+		WHERE r.oid = to_regclass($1)
+		ORDER BY c.ordinal_position`
+	rows, err := database.QueryContext(ctx, query, quoteTableName(tableName))
 	if err != nil {
-		panic(&DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()})
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
 	}
+	defer rows.Close()
 
-	fmt.Println("Successfully connected!")
+	schema := &TableSchema{Name: tableName}
+	for rows.Next() {
+		var column Column
+		var udtName string
+		if err := rows.Scan(&column.Name, &column.Type, &udtName, &column.Nullable, &column.PrimaryKey, &column.Unique); err != nil {
+			return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+		}
+		column.Kind = postgresKind(column.Type, udtName)
+		if column.Kind == KIND_ARRAY {
+			column.ElementKind = postgresElementKind(udtName)
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error()}
+	}
+	if len(schema.Columns) == 0 {
+		return nil, &DatabaseError{DBName: d.cfg.Name, ErrMsg: fmt.Sprintf("table %s doesn't exist", tableName), Cat: apperr.CONFIG}
+	}
+	return schema, nil
 }
 
-// Update updates a record with the provided key.
-func (d *postgresDatabase) Update(upDto UpdateDto) error {
-	database, err := sql.Open("postgres", d.connectionString)
+// TestConnection pings the database.
+func (d *postgresDatabase) TestConnection() error {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+
+	return d.ping(ctx)
+}
+
+func (d *postgresDatabase) ping(ctx context.Context) error {
+	database, err := d.getPool()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := database.PingContext(ctx); err != nil {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: err.Error(), Cat: apperr.CONNECTION}
+	}
+	return nil
+}
+
+// Update updates a record with the provided key.
+func (d *postgresDatabase) Update(ctx context.Context, upDto UpdateDto) error {
+	if err := checkWritable(d.cfg, upDto.TableName); err != nil {
+		return err
 	}
-	defer database.Close()
 
-	query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", upDto.TableName, upDto.UpdatedColumnName, upDto.KeyName)
+	if len(upDto.UpdatedPath) > 0 {
+		return &DatabaseError{DBName: d.cfg.Name, ErrMsg: "nested paths can only be updated in MongoDB", KeyName: upDto.KeyName, KeyValue: upDto.KeyValue, Cat: apperr.CONFIG}
+	}
 
-	result, err := database.Exec(query, upDto.NewValue, upDto.KeyValue)
+	ctx, cancel := withQueryTimeout(ctx, d.cfg)
+	defer cancel()
+
+	database, err := d.getPool()
 	if err != nil {
 		return err
 	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", quoteTableName(upDto.TableName), pq.QuoteIdentifier(upDto.UpdatedColumnName), pq.QuoteIdentifier(upDto.KeyName))
+
+	result, err := database.ExecContext(ctx, query, upDto.NewValue, upDto.KeyValue)
+	if err != nil {
+		return newWriteError(d.cfg.Name, err, upDto.KeyName, upDto.KeyValue)
+	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return newWriteError(d.cfg.Name, err, upDto.KeyName, upDto.KeyValue)
 	}
 	if rowsAffected == 0 {
 		dbErr := &DatabaseError{DBName: d.cfg.Name, ErrMsg: "no rows affected in update", KeyName: upDto.KeyName, KeyValue: upDto.KeyValue}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/lib/pq"
+)
+
+// postgresFilter translates a filter into an SQL condition.
+// Values are bound as positional parameters and appended to args.
+func postgresFilter(filter cfg.Filter, args *[]interface{}) (string, error) {
+	switch f := filter.(type) {
+	case *cfg.Comparison:
+		return fmt.Sprintf("%s %s %s", pq.QuoteIdentifier(f.Column), f.Operator, bindParam(f.Value, args)), nil
+	case *cfg.LogicalFilter:
+		operands := make([]string, len(f.Operands))
+		for i, operand := range f.Operands {
+			condition, err := postgresFilter(operand, args)
+			if err != nil {
+				return "", err
+			}
+			operands[i] = condition
+		}
+		return "(" + strings.Join(operands, " "+f.Operator+" ") + ")", nil
+	case *cfg.NotFilter:
+		condition, err := postgresFilter(f.Operand, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + condition + ")", nil
+	case *cfg.InFilter:
+		params := make([]string, len(f.Values))
+		for i, value := range f.Values {
+			params[i] = bindParam(value, args)
+		}
+		operator := "IN"
+		if f.Negated {
+			operator = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", pq.QuoteIdentifier(f.Column), operator, strings.Join(params, ", ")), nil
+	case *cfg.NullFilter:
+		if f.Negated {
+			return pq.QuoteIdentifier(f.Column) + " IS NOT NULL", nil
+		}
+		return pq.QuoteIdentifier(f.Column) + " IS NULL", nil
+	case *cfg.LikeFilter:
+		operator := "LIKE"
+		if f.Negated {
+			operator = "NOT LIKE"
+		}
+		return fmt.Sprintf("%s %s %s", pq.QuoteIdentifier(f.Column), operator, bindParam(f.Pattern, args)), nil
+	case *cfg.RawFilter:
+		return f.Query, nil
+	}
+	return "", fmt.Errorf("unsupported filter %T", filter)
+}
+
+func bindParam(value interface{}, args *[]interface{}) string {
+	*args = append(*args, value)
+	return "$" + strconv.Itoa(len(*args))
+}
+
+// quoteTableName quotes a table name, which can be qualified with a schema
+// name like "public.film". Parts already in double quotes are kept as they are,
+// so names containing dots can be written as "public"."my.table".
+func quoteTableName(tableName string) string {
+	parts := make([]string, 0)
+	var part strings.Builder
+	quoted := false
+
+	for i := 0; i < len(tableName); i++ {
+		c := tableName[i]
+		switch {
+		case c == '"' && quoted && i+1 < len(tableName) && tableName[i+1] == '"':
+			part.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, pq.QuoteIdentifier(part.String()))
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	parts = append(parts, pq.QuoteIdentifier(part.String()))
+
+	return strings.Join(parts, ".")
+}
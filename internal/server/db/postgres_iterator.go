@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var collatableTypes = []string{"TEXT", "VARCHAR", "BPCHAR", "NAME"}
+
+// orderColumn is a column records are sorted by.
+// String columns are sorted with the "C" collation, that compares bytes.
+type orderColumn struct {
+	name    string
+	collate bool
+}
+
+func (c orderColumn) String() string {
+	if c.collate {
+		return pq.QuoteIdentifier(c.name) + ` COLLATE "C"`
+	}
+	return pq.QuoteIdentifier(c.name)
+}
+
+// postgresIterator selects records with keyset pagination.
+// last holds the order columns' values of the previous page's last record.
+type postgresIterator struct {
+	db         *postgresDatabase
+	tableName  string
+	filter     string
+	filterArgs []interface{}
+	orderBy    []orderColumn
+	pageSize   int
+	last       []interface{}
+	done       bool
+}
+
+// Next selects the next page of records.
+func (it *postgresIterator) Next(ctx context.Context) ([]map[string]interface{}, error) {
+	if it.done {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(it.filterArgs))
+	copy(args, it.filterArgs)
+
+	conditions := make([]string, 0)
+	if it.filter != "" {
+		conditions = append(conditions, "("+it.filter+")")
+	}
+	if it.last != nil {
+		conditions = append(conditions, keysetCondition(it.orderBy, it.last, &args))
+	}
+	var where string
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s%s ORDER BY %s LIMIT %d", quoteTableName(it.tableName), where, orderByClause(it.orderBy), it.pageSize)
+	page, err := it.db.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page) < it.pageSize {
+		it.done = true
+	}
+	if len(page) > 0 {
+		lastRecord := page[len(page)-1]
+		it.last = make([]interface{}, len(it.orderBy))
+		for i, column := range it.orderBy {
+			it.last[i] = keysetValue(lastRecord[column.name])
+		}
+	}
+	return page, nil
+}
+
+// Close does nothing, because every page is selected with a separate query.
+func (it *postgresIterator) Close() error {
+	return nil
+}
+
+func orderByClause(columns []orderColumn) string {
+	clauses := make([]string, len(columns))
+	for i, column := range columns {
+		clauses[i] = column.String() + " NULLS FIRST"
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// keysetCondition selects the records sorted after the given values:
+// the ones, which equal the values in the first n columns and are greater in the next one.
+func keysetCondition(columns []orderColumn, values []interface{}, args *[]interface{}) string {
+	alternatives := make([]string, len(columns))
+	for i := range columns {
+		conditions := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				conditions = append(conditions, pq.QuoteIdentifier(columns[j].name)+" IS NULL")
+			} else {
+				conditions = append(conditions, pq.QuoteIdentifier(columns[j].name)+" = "+bindParam(values[j], args))
+			}
+		}
+		// Nulls come first, so every value is greater than null.
+		if values[i] == nil {
+			conditions = append(conditions, pq.QuoteIdentifier(columns[i].name)+" IS NOT NULL")
+		} else {
+			conditions = append(conditions, columns[i].String()+" > "+bindParam(values[i], args))
+		}
+		alternatives[i] = "(" + strings.Join(conditions, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// keysetValue converts values, which the driver returns as bytes (e.g. numeric or uuid),
+// to strings, so that they're bound as text instead of bytea parameters.
+func keysetValue(value interface{}) interface{} {
+	if bytes, isBytes := value.([]byte); isBytes {
+		return string(bytes)
+	}
+	return value
+}
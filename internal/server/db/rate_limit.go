@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+)
+
+// ADAPTIVE_WINDOW is the number of writes, whose latency and errors
+// are evaluated at once by adaptive rate limiting.
+const ADAPTIVE_WINDOW = 20
+
+// ADAPTIVE_STEP is the part of the configured rate, by which a lowered rate is raised
+// after a window of fast and successful writes.
+const ADAPTIVE_STEP = 0.1
+
+// RateLimiter spaces writes evenly according to the rate and limits how many of them
+// run at once. In adaptive mode the rate follows the latency and errors of the writes.
+// A nil RateLimiter doesn't limit anything.
+type RateLimiter struct {
+	mux      sync.Mutex
+	name     string
+	rate     float64
+	maxRate  float64
+	next     time.Time
+	slots    chan struct{}
+	adaptive *cfg.AdaptiveRate
+	writes   int
+	failures int
+	latency  time.Duration
+}
+
+// NewRateLimiter constructor function for the RateLimiter struct.
+// It returns nil if the limit is nil. The name identifies the limiter in logs.
+func NewRateLimiter(name string, limit *cfg.RateLimit) *RateLimiter {
+	if limit == nil {
+		return nil
+	}
+	l := &RateLimiter{
+		name:     name,
+		rate:     limit.OpsPerSecond,
+		maxRate:  limit.OpsPerSecond,
+		adaptive: limit.Adaptive,
+	}
+	if limit.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limit.MaxConcurrent)
+	}
+	return l
+}
+
+// GetRate returns the current number of writes per second, zero means no limit.
+func (l *RateLimiter) GetRate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.rate
+}
+
+// Do waits for its turn and carries out the write. Writes waiting for their turn
+// are abandoned when the context gets cancelled.
+func (l *RateLimiter) Do(ctx context.Context, write func() error) error {
+	if l == nil {
+		return write()
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			defer func() { <-l.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if delay := l.reserve(); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	start := time.Now()
+	err := write()
+	l.record(time.Since(start), err)
+	return err
+}
+
+// reserve books the next free moment for a write and returns how long to wait for it.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(time.Second) / l.rate))
+	return delay
+}
+
+// record adapts the rate once a window of writes has been carried out.
+func (l *RateLimiter) record(latency time.Duration, err error) {
+	if l.adaptive == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.writes++
+	l.latency += latency
+	if err != nil {
+		l.failures++
+	}
+	if l.writes < ADAPTIVE_WINDOW {
+		return
+	}
+
+	avgLatency := l.latency / time.Duration(l.writes)
+	errorRate := float64(l.failures) / float64(l.writes)
+	if avgLatency > l.adaptive.GetMaxLatency() || errorRate > l.adaptive.GetMaxErrorRate() {
+		rate := math.Max(l.rate/2, math.Min(l.adaptive.GetMinOpsPerSecond(), l.maxRate))
+		if rate < l.rate {
+			log.Printf("[rate limit] %s: writes are slow or failing (average latency %s, error rate %.2f), lowering the rate to %.2f ops/s\n", l.name, avgLatency, errorRate, rate)
+		}
+		l.rate = rate
+	} else if l.rate < l.maxRate {
+		l.rate = math.Min(l.rate+l.maxRate*ADAPTIVE_STEP, l.maxRate)
+	}
+	l.writes, l.failures, l.latency = 0, 0, 0
+}
+
+// limitedDatabase throttles the writes to a database according to its rate limit,
+// which all synchs writing to the database share.
+type limitedDatabase struct {
+	Database
+	limiter *RateLimiter
+}
+
+func (d *limitedDatabase) Insert(ctx context.Context, inDto InsertDto) error {
+	return d.limiter.Do(ctx, func() error { return d.Database.Insert(ctx, inDto) })
+}
+
+func (d *limitedDatabase) Update(ctx context.Context, upDto UpdateDto) error {
+	return d.limiter.Do(ctx, func() error { return d.Database.Update(ctx, upDto) })
+}
+
+func (d *limitedDatabase) Upsert(ctx context.Context, inDto InsertDto) error {
+	return d.limiter.Do(ctx, func() error { return d.Database.Upsert(ctx, inDto) })
+}
+
+func (d *limitedDatabase) ReplaceChildren(ctx context.Context, chDto ChildrenDto) error {
+	return d.limiter.Do(ctx, func() error { return d.Database.ReplaceChildren(ctx, chDto) })
+}
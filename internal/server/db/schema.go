@@ -0,0 +1,219 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Database agnostic kinds of columns.
+// KIND_MIXED is used for MongoDB fields holding values of different types.
+const (
+	KIND_STRING    = "string"
+	KIND_INTEGER   = "integer"
+	KIND_DECIMAL   = "decimal"
+	KIND_BOOL      = "bool"
+	KIND_TIME      = "time"
+	KIND_BINARY    = "binary"
+	KIND_UUID      = "uuid"
+	KIND_OBJECT_ID = "objectId"
+	KIND_DOCUMENT  = "document"
+	KIND_ARRAY     = "array"
+	KIND_MIXED     = "mixed"
+	KIND_UNKNOWN   = "unknown"
+)
+
+// SCHEMA_SAMPLE_SIZE is the number of documents, which MongoDB schemas are inferred from.
+const SCHEMA_SAMPLE_SIZE = 100
+
+// TableSchema describes the columns of a table.
+// Sampled schemas are inferred from a sample of records,
+// so columns, which are rarely set, can be missing.
+type TableSchema struct {
+	Name    string
+	Columns []Column
+	Sampled bool
+}
+
+// Column describes a single column. Type is the database's native type name.
+// ElementKind is the kind of a PostgreSQL array's elements.
+// Fields describes the documents held by the column or its elements, when they're known.
+// Unique tells whether the column alone has a unique index or constraint, which upserts can be keyed by.
+type Column struct {
+	Name        string
+	Type        string
+	Kind        string
+	ElementKind string
+	Fields      []Column
+	Nullable    bool
+	PrimaryKey  bool
+	Unique      bool
+}
+
+// localTimeTypes are PostgreSQL types of times stored without a time zone.
+var localTimeTypes = []string{"date", "timestamp without time zone", "time without time zone"}
+
+// IsLocalTime tells whether the column holds times without a time zone.
+func (c *Column) IsLocalTime() bool {
+	for _, localTimeType := range localTimeTypes {
+		if c.Type == localTimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetColumn returns the column with the given name or nil if it doesn't exist.
+func (s *TableSchema) GetColumn(name string) *Column {
+	for i := range s.Columns {
+		if s.Columns[i].Name == name {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+// GetField returns the description of a field of the column's documents or nil if it isn't known.
+func (c *Column) GetField(name string) *Column {
+	for i := range c.Fields {
+		if c.Fields[i].Name == name {
+			return &c.Fields[i]
+		}
+	}
+	return nil
+}
+
+var postgresKinds = map[string]string{
+	"int2":        KIND_INTEGER,
+	"int4":        KIND_INTEGER,
+	"int8":        KIND_INTEGER,
+	"oid":         KIND_INTEGER,
+	"numeric":     KIND_DECIMAL,
+	"float4":      KIND_DECIMAL,
+	"float8":      KIND_DECIMAL,
+	"money":       KIND_DECIMAL,
+	"text":        KIND_STRING,
+	"varchar":     KIND_STRING,
+	"bpchar":      KIND_STRING,
+	"char":        KIND_STRING,
+	"name":        KIND_STRING,
+	"citext":      KIND_STRING,
+	"bool":        KIND_BOOL,
+	"date":        KIND_TIME,
+	"time":        KIND_TIME,
+	"timetz":      KIND_TIME,
+	"timestamp":   KIND_TIME,
+	"timestamptz": KIND_TIME,
+	"bytea":       KIND_BINARY,
+	"uuid":        KIND_UUID,
+	"json":        KIND_DOCUMENT,
+	"jsonb":       KIND_DOCUMENT,
+}
+
+// postgresKind returns the kind of a column based on its
+// information_schema data type and underlying type name.
+func postgresKind(dataType string, udtName string) string {
+	if dataType == "ARRAY" {
+		return KIND_ARRAY
+	}
+	if kind, found := postgresKinds[udtName]; found {
+		return kind
+	}
+	return KIND_UNKNOWN
+}
+
+// postgresElementKind returns the kind of an array's elements.
+// Names of array types are the element types' names prefixed with an underscore.
+func postgresElementKind(udtName string) string {
+	return postgresKind("", strings.TrimPrefix(udtName, "_"))
+}
+
+// mongoKind returns the kind of a BSON value.
+func mongoKind(value bson.RawValue) string {
+	switch value.Type {
+	case bsontype.String, bsontype.Symbol:
+		return KIND_STRING
+	case bsontype.Int32, bsontype.Int64:
+		return KIND_INTEGER
+	case bsontype.Double, bsontype.Decimal128:
+		return KIND_DECIMAL
+	case bsontype.Boolean:
+		return KIND_BOOL
+	case bsontype.DateTime, bsontype.Timestamp:
+		return KIND_TIME
+	case bsontype.Binary:
+		if subtype, _ := value.Binary(); subtype == bsontype.BinaryUUID || subtype == bsontype.BinaryUUIDOld {
+			return KIND_UUID
+		}
+		return KIND_BINARY
+	case bsontype.ObjectID:
+		return KIND_OBJECT_ID
+	case bsontype.EmbeddedDocument:
+		return KIND_DOCUMENT
+	case bsontype.Array:
+		return KIND_ARRAY
+	}
+	return KIND_UNKNOWN
+}
+
+// sampleSchema infers a schema from the top level fields of sampled documents.
+// Fields missing in some of the documents are nullable. Integers and decimals
+// in the same field make it decimal, other combinations of types make it mixed.
+func sampleSchema(tableName string, documents []bson.Raw) (*TableSchema, error) {
+	schema := &TableSchema{Name: tableName, Sampled: true}
+	kinds := make(map[string]map[string]bool)
+	types := make(map[string]map[string]bool)
+	counts := make(map[string]int)
+	nulls := make(map[string]bool)
+
+	for _, document := range documents {
+		elements, err := document.Elements()
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			name, value := element.Key(), element.Value()
+			if _, found := counts[name]; !found {
+				schema.Columns = append(schema.Columns, Column{Name: name, PrimaryKey: name == "_id"})
+				kinds[name] = make(map[string]bool)
+				types[name] = make(map[string]bool)
+			}
+			counts[name]++
+			if value.Type == bsontype.Null || value.Type == bsontype.Undefined {
+				nulls[name] = true
+				continue
+			}
+			kinds[name][mongoKind(value)] = true
+			types[name][value.Type.String()] = true
+		}
+	}
+
+	for i := range schema.Columns {
+		column := &schema.Columns[i]
+		column.Nullable = counts[column.Name] < len(documents) || nulls[column.Name]
+		column.Type = strings.Join(sortedKeys(types[column.Name]), "|")
+
+		switch columnKinds := kinds[column.Name]; {
+		case len(columnKinds) == 0:
+			column.Kind = KIND_UNKNOWN
+		case len(columnKinds) == 1:
+			column.Kind = sortedKeys(columnKinds)[0]
+		case len(columnKinds) == 2 && columnKinds[KIND_INTEGER] && columnKinds[KIND_DECIMAL]:
+			column.Kind = KIND_DECIMAL
+		default:
+			column.Kind = KIND_MIXED
+		}
+	}
+	return schema, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
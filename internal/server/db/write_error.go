@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/lib/pq"
+)
+
+// transientPostgresClasses are classes of PostgreSQL error codes, which are likely to pass
+// when a write is repeated: connection exceptions, transaction rollbacks (serialization
+// failures and deadlocks), insufficient resources and operator intervention.
+var transientPostgresClasses = []pq.ErrorClass{"08", "40", "53", "57"}
+
+// transientMongoLabels are labels of MongoDB errors, which are likely to pass when a write is repeated.
+var transientMongoLabels = []string{"NetworkError", "RetryableWriteError", "TransientTransactionError"}
+
+// labeledError is implemented by MongoDB's command errors and write exceptions.
+type labeledError interface {
+	HasErrorLabel(label string) bool
+}
+
+// newWriteError wraps an error returned by a write. Transient errors are
+// classified as connection errors, so that the write can be retried.
+func newWriteError(dbName string, err error, keyName string, keyValue interface{}) *DatabaseError {
+	dbErr := &DatabaseError{DBName: dbName, ErrMsg: err.Error(), KeyName: keyName, KeyValue: keyValue}
+	if isTransient(err) {
+		dbErr.Cat = apperr.CONNECTION
+	}
+	return dbErr
+}
+
+// isTransient tells whether an error is likely to pass when the failed operation is repeated.
+// Cancelled contexts aren't transient, they mean the operation is no longer wanted.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		for _, class := range transientPostgresClasses {
+			if pqErr.Code.Class() == class {
+				return true
+			}
+		}
+		return false
+	}
+
+	var labeled labeledError
+	if errors.As(err, &labeled) {
+		for _, label := range transientMongoLabels {
+			if labeled.HasErrorLabel(label) {
+				return true
+			}
+		}
+	}
+	return false
+}
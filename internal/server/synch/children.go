@@ -0,0 +1,146 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+)
+
+// CHILDREN_TYPE is the type of the virtual columns holding a record's children.
+const CHILDREN_TYPE = "children"
+
+// childColumn describes the virtual column holding a record's children.
+// The children's fields are only known once the child table has been described.
+func childColumn(child *cfg.ChildConfig, schema *db.TableSchema) *db.Column {
+	col := &db.Column{Name: child.Name, Type: CHILDREN_TYPE, Kind: db.KIND_ARRAY, ElementKind: db.KIND_DOCUMENT}
+	if schema == nil {
+		return col
+	}
+	for _, field := range schema.Columns {
+		if isEmbeddedColumn(child, field.Name) {
+			col.Fields = append(col.Fields, field)
+		}
+	}
+	return col
+}
+
+// isEmbeddedColumn tells whether a column of the child table is embedded in the parent's records.
+func isEmbeddedColumn(child *cfg.ChildConfig, column string) bool {
+	if column == child.ParentKey {
+		return false
+	}
+	return len(child.Columns) == 0 || util.StringSliceContains(child.Columns, column)
+}
+
+// attachChildren selects the children of a page of records with one query
+// per child table and embeds them in the records sorted by their keys.
+func (n *node) attachChildren(ctx context.Context, records []*record) error {
+	for i := range n.cfg.Children {
+		child := &n.cfg.Children[i]
+
+		parentKeys := make([]interface{}, 0, len(records))
+		for _, rec := range records {
+			if key := parentKeyValue(rec.Data[n.cfg.Key]); key != nil {
+				parentKeys = append(parentKeys, key)
+			}
+		}
+
+		var rows []map[string]interface{}
+		if len(parentKeys) > 0 {
+			var err error
+			rows, err = (*n.db).Select(ctx, child.Table, &cfg.InFilter{Column: child.ParentKey, Values: parentKeys})
+			if err != nil {
+				return err
+			}
+		}
+
+		grouped, err := groupChildren(child, rows)
+		if err != nil {
+			return &pairingError{tableName: child.Table, errMsg: err.Error()}
+		}
+		for _, rec := range records {
+			key, err := groupKey(rec.Data[n.cfg.Key])
+			if err != nil {
+				return &pairingError{tableName: n.tbl.name, errMsg: err.Error()}
+			}
+			children, found := grouped[key]
+			if !found {
+				children = make([]interface{}, 0)
+			}
+			rec.Data[child.Name] = children
+		}
+	}
+	return nil
+}
+
+// groupChildren groups the rows of a child table by their parent keys
+// and sorts them by their keys. Only the embedded columns are kept.
+func groupChildren(child *cfg.ChildConfig, rows []map[string]interface{}) (map[string][]interface{}, error) {
+	groups := make(map[string][]map[string]interface{})
+	for _, row := range rows {
+		key, err := groupKey(row[child.ParentKey])
+		if err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	grouped := make(map[string][]interface{}, len(groups))
+	for key, group := range groups {
+		var sortErr error
+		sort.SliceStable(group, func(i, j int) bool {
+			cmp, err := compareKeys(group[i][child.Key], group[j][child.Key])
+			if err != nil && sortErr == nil {
+				sortErr = fmt.Errorf("keys of child \"%s\" can't be sorted: %s", child.Name, err)
+			}
+			return cmp < 0
+		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
+
+		children := make([]interface{}, len(group))
+		for i, row := range group {
+			embedded := make(map[string]interface{}, len(row))
+			for column, value := range row {
+				if isEmbeddedColumn(child, column) {
+					embedded[column] = value
+				}
+			}
+			children[i] = embedded
+		}
+		grouped[key] = children
+	}
+	return grouped, nil
+}
+
+// groupKey identifies equal keys regardless of their types, so that the parent keys
+// of children match the keys of their parents read from another representation.
+func groupKey(key interface{}) (string, error) {
+	kind, value, err := normalizeKey(key)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case *big.Float:
+		return fmt.Sprintf("%d:%s", kind, v.Text('g', -1)), nil
+	case time.Time:
+		return fmt.Sprintf("%d:%d", kind, v.UnixNano()), nil
+	}
+	return fmt.Sprintf("%d:%v", kind, value), nil
+}
+
+// parentKeyValue prepares a parent's key to be looked up in a child table.
+// The PostgreSQL driver returns some keys (e.g. numeric and uuid) as text bytes.
+func parentKeyValue(key interface{}) interface{} {
+	if text, isText := key.([]byte); isText {
+		return string(text)
+	}
+	return key
+}
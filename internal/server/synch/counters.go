@@ -1,13 +1,47 @@
 package synch
 
+import "sync"
+
+// Counters sum up what a run has done. Selected counts the records read from both
+// nodes of all links. Skipped counts the pairs left alone, because their values
+// are already equal or their action isn't enabled in "do".
+type Counters struct {
+	Selected int `json:"selected"`
+	Paired   int `json:"paired"`
+	Unpaired int `json:"unpaired"`
+	Updated  int `json:"updated"`
+	Inserted int `json:"inserted"`
+	Failed   int `json:"failed"`
+	Skipped  int `json:"skipped"`
+}
+
 type counters struct {
+	mux     sync.Mutex
 	selects int
+	run     Counters
 }
 
 func newCounters() *counters {
-	return &counters{0}
+	return &counters{}
+}
+
+// add updates the run's counters.
+func (c *counters) add(update func(run *Counters)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	update(&c.run)
+}
+
+// snapshot returns a copy of the run's counters.
+func (c *counters) snapshot() Counters {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.run
 }
 
 func (c *counters) reset() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	c.selects = 0
+	c.run = Counters{}
 }
@@ -1,8 +1,7 @@
 package synch
 
 import (
-	"log"
-
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 )
@@ -14,15 +13,17 @@ type dbStore struct {
 	nodes    map[string]*node
 }
 
-func (ds *dbStore) Init(DBMap map[string]*db.Database, nodeCfgs []cfg.NodeConfig) {
+func (ds *dbStore) Init(DBMap map[string]*db.Database, nodeCfgs []cfg.NodeConfig) error {
 	ds.dbs = make(map[string]*db.Database)
 	ds.tables = make(map[string]*table)
 	ds.nodes = make(map[string]*node)
 
 	ds.setNodeCfgs(nodeCfgs)
-	ds.setDatabases(DBMap)
+	if err := ds.setDatabases(DBMap); err != nil {
+		return err
+	}
 	ds.setTables()
-	ds.setNodes()
+	return ds.setNodes()
 }
 
 func (ds *dbStore) setNodeCfgs(nodeCfgs []cfg.NodeConfig) {
@@ -30,37 +31,40 @@ func (ds *dbStore) setNodeCfgs(nodeCfgs []cfg.NodeConfig) {
 }
 
 // setNodes creates node structs and adds them to the relevant synch struct field.
-func (ds *dbStore) setNodes() {
+func (ds *dbStore) setNodes() error {
 	for i := range ds.nodeCfgs {
 		var nodeConfig *cfg.NodeConfig = &ds.nodeCfgs[i]
 
 		var tableName string = nodeConfig.Database + "." + nodeConfig.Table
 		_, tableFound := ds.tables[tableName]
 		if !tableFound {
-			log.Fatalln("[create node] ERROR: table " + tableName + " not found.")
+			return apperr.New(apperr.CONFIG, "create node", "table "+tableName+" not found.")
 		}
 
 		ds.nodes[nodeConfig.Name] = createNode(nodeConfig, ds.dbs[nodeConfig.Database], ds.tables[tableName])
 	}
+	return nil
 }
 
 // setDatabases opens the chosen database connections.
-func (ds *dbStore) setDatabases(DBMap map[string]*db.Database) {
+func (ds *dbStore) setDatabases(DBMap map[string]*db.Database) error {
 	for j := range ds.nodeCfgs {
 		var nodeConfig *cfg.NodeConfig = &ds.nodeCfgs[j]
-		ds.setDatabase(DBMap, nodeConfig.Database)
+		if err := ds.setDatabase(DBMap, nodeConfig.Database); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (ds *dbStore) setDatabase(DBMap map[string]*db.Database, dbName string) {
-	_, dbExists := DBMap[dbName]
-	if dbExists {
-		ds.dbs[dbName] = DBMap[dbName]
-		(*ds.dbs[dbName]).Init()
-	} else {
-		dbErr := &db.DatabaseError{DBName: dbName, ErrMsg: "database hasn't been configured"}
-		panic(dbErr)
+func (ds *dbStore) setDatabase(DBMap map[string]*db.Database, dbName string) error {
+	database, dbExists := DBMap[dbName]
+	if !dbExists || *database == nil {
+		return &db.DatabaseError{DBName: dbName, ErrMsg: "database hasn't been configured", Cat: apperr.CONFIG}
 	}
+
+	ds.dbs[dbName] = database
+	return (*ds.dbs[dbName]).Init()
 }
 
 // setTables creates table structs based on node yaml data.
@@ -71,7 +75,8 @@ func (ds *dbStore) setTables() {
 	}
 }
 
-// setTable creates an individual table struct and selects all records from it.
+// setTable creates an individual table struct. Its records are streamed
+// page by page when a link is synchronized.
 func (ds *dbStore) setTable(tableName string, database *db.Database) {
 	var tblID string = (*database).GetConfig().GetName() + "." + tableName
 	_, tableSet := ds.tables[tblID]
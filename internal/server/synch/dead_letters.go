@@ -0,0 +1,340 @@
+package synch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeadLetter is a write, which failed permanently. Payload holds the write's
+// dto in MongoDB's extended JSON, so that the types of its values survive
+// being stored. Times keep millisecond precision.
+type DeadLetter struct {
+	ID          string          `json:"id"`
+	Synch       string          `json:"synch"`
+	IterationID string          `json:"iterationId,omitempty"`
+	Database    string          `json:"database"`
+	Write       string          `json:"write"`
+	Table       string          `json:"table"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Error       string          `json:"error"`
+	Category    apperr.Category `json:"category"`
+	Attempts    int             `json:"attempts"`
+	FailedAt    string          `json:"failedAt"`
+}
+
+func newDeadLetter(synchName string, iterationID string, w *write, attempts int, err error) (*DeadLetter, error) {
+	payload, marshalErr := bson.MarshalExtJSON(w.dto, true, false)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return &DeadLetter{
+		ID:          synchName + "-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Synch:       synchName,
+		IterationID: iterationID,
+		Database:    w.database.GetConfig().GetName(),
+		Write:       w.kind,
+		Table:       w.tableName(),
+		Payload:     payload,
+		Error:       err.Error(),
+		Category:    apperr.CategoryOf(err),
+		Attempts:    attempts,
+		FailedAt:    util.GetTimestamp(),
+	}, nil
+}
+
+// restore recreates the write stored in the dead letter.
+func (l *DeadLetter) restore(database db.Database, restorer *valueRestorer) (*write, error) {
+	w := &write{kind: l.Write, database: database}
+	var err error
+	switch l.Write {
+	case WRITE_UPDATE:
+		var dto db.UpdateDto
+		if err := bson.UnmarshalExtJSON(l.Payload, true, &dto); err != nil {
+			return nil, err
+		}
+		if dto.KeyValue, err = restorer.value(dto.KeyName, dto.KeyValue); err != nil {
+			return nil, err
+		}
+		if dto.NewValue, err = restorer.value(dto.UpdatedColumnName, dto.NewValue); err != nil {
+			return nil, err
+		}
+		w.dto = dto
+	case WRITE_INSERT, WRITE_UPSERT:
+		var dto db.InsertDto
+		if err := bson.UnmarshalExtJSON(l.Payload, true, &dto); err != nil {
+			return nil, err
+		}
+		if dto.KeyValue, err = restorer.value(dto.KeyName, dto.KeyValue); err != nil {
+			return nil, err
+		}
+		if err := restorer.values(dto.Values); err != nil {
+			return nil, err
+		}
+		w.dto = dto
+	case WRITE_REPLACE_CHILDREN:
+		var dto db.ChildrenDto
+		if err := bson.UnmarshalExtJSON(l.Payload, true, &dto); err != nil {
+			return nil, err
+		}
+		if dto.ParentKeyValue, err = restorer.value(dto.ParentKeyName, dto.ParentKeyValue); err != nil {
+			return nil, err
+		}
+		for _, child := range dto.Children {
+			if err := restorer.values(child); err != nil {
+				return nil, err
+			}
+		}
+		w.dto = dto
+	default:
+		return nil, fmt.Errorf("unknown kind of write \"%s\"", l.Write)
+	}
+	return w, nil
+}
+
+// valueRestorer turns values, which extended JSON decodes to BSON types, back into
+// the types the database's driver accepts. Values written to PostgreSQL are converted
+// like values of linked columns, according to the kinds of the table's columns.
+type valueRestorer struct {
+	converter *valueConverter
+	dbType    string
+	schema    *db.TableSchema
+}
+
+func (r *valueRestorer) values(values map[string]interface{}) error {
+	for column, value := range values {
+		restored, err := r.value(column, value)
+		if err != nil {
+			return err
+		}
+		values[column] = restored
+	}
+	return nil
+}
+
+func (r *valueRestorer) value(column string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().UTC(), nil
+	case primitive.Binary:
+		if v.Subtype == bsontype.BinaryGeneric {
+			return v.Data, nil
+		}
+	}
+	if r.dbType == "mongo" {
+		return value, nil
+	}
+
+	switch value.(type) {
+	case primitive.Binary, primitive.Decimal128, primitive.A, primitive.D, primitive.M, []interface{}, map[string]interface{}:
+		var col *db.Column
+		if r.schema != nil {
+			col = r.schema.GetColumn(column)
+		}
+		return r.converter.convert(value, nil, col, r.dbType)
+	}
+	return value, nil
+}
+
+// DeadLetters persists the writes, which failed permanently,
+// so that they can be inspected and re-driven later.
+type DeadLetters struct {
+	mux     sync.Mutex
+	path    string
+	letters map[string]*DeadLetter
+	// redriving holds the IDs of letters, whose writes are being repeated.
+	redriving map[string]bool
+}
+
+// CreateDeadLetters constructor function for the DeadLetters struct.
+// Dead letters stored in the file are loaded.
+func CreateDeadLetters(path string) *DeadLetters {
+	d := &DeadLetters{
+		path:      path,
+		letters:   make(map[string]*DeadLetter),
+		redriving: make(map[string]bool),
+	}
+	d.load()
+	return d
+}
+
+// load reads the stored dead letters. A missing file means there aren't any.
+func (d *DeadLetters) load() {
+	byteArray, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+		return
+	}
+
+	var letters []*DeadLetter
+	if err := json.Unmarshal(byteArray, &letters); err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+		return
+	}
+	for _, letter := range letters {
+		d.letters[letter.ID] = letter
+	}
+}
+
+// Add stores a dead letter.
+func (d *DeadLetters) Add(letter *DeadLetter) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.letters[letter.ID] = letter
+	d.save()
+}
+
+// Get returns a dead letter by its ID.
+func (d *DeadLetters) Get(id string) (*DeadLetter, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	letter, found := d.letters[id]
+	return letter, found
+}
+
+// List returns the dead letters of a synch, or all of them if the name is empty,
+// sorted by their IDs, which consist of the synch's name and the time of the failure.
+func (d *DeadLetters) List(synchName string) []*DeadLetter {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	letters := make([]*DeadLetter, 0, len(d.letters))
+	for _, letter := range d.letters {
+		if synchName == "" || letter.Synch == synchName {
+			letters = append(letters, letter)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+	return letters
+}
+
+// Redrive repeats the write stored in a dead letter. The letter is removed if the write
+// succeeds, otherwise it's updated with the new error. The store isn't locked during the write,
+// so that synchs can keep adding dead letters, but a letter can't be re-driven twice at once.
+func (d *DeadLetters) Redrive(ctx context.Context, DBMap map[string]*db.Database, id string) error {
+	letter, err := d.startRedrive(id)
+	if err != nil {
+		return err
+	}
+	defer d.finishRedrive(id)
+
+	database, dbExists := DBMap[letter.Database]
+	if !dbExists || *database == nil {
+		return &db.DatabaseError{DBName: letter.Database, ErrMsg: "database hasn't been configured", Cat: apperr.CONFIG}
+	}
+	if err := (*database).Init(); err != nil {
+		return err
+	}
+	restorer, err := newValueRestorer(ctx, *database, letter.Table)
+	if err != nil {
+		return err
+	}
+	w, err := letter.restore(*database, restorer)
+	if err != nil {
+		return apperr.Wrap(apperr.INTERNAL, "dead letter "+id, err)
+	}
+
+	writeErr := w.execute(ctx)
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if writeErr != nil {
+		letter.Error, letter.Category = writeErr.Error(), apperr.CategoryOf(writeErr)
+		letter.Attempts++
+		letter.FailedAt = util.GetTimestamp()
+		d.save()
+		return writeErr
+	}
+	delete(d.letters, id)
+	d.save()
+	return nil
+}
+
+// startRedrive marks a dead letter as being re-driven.
+func (d *DeadLetters) startRedrive(id string) (*DeadLetter, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	letter, found := d.letters[id]
+	if !found {
+		return nil, apperr.New(apperr.NOT_FOUND, "dead letter search", "\""+id+"\" not found.")
+	}
+	if d.redriving[id] {
+		return nil, apperr.New(apperr.REQUEST, "dead letter redrive", "\""+id+"\" is already being re-driven.")
+	}
+	d.redriving[id] = true
+	return letter, nil
+}
+
+func (d *DeadLetters) finishRedrive(id string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	delete(d.redriving, id)
+}
+
+// newValueRestorer describes the PostgreSQL table the write goes to, so that
+// its values are restored to the columns' types. MongoDB takes BSON types as they are.
+func newValueRestorer(ctx context.Context, database db.Database, table string) (*valueRestorer, error) {
+	converter, err := newValueConverter(cfg.Compare{})
+	if err != nil {
+		return nil, err
+	}
+	restorer := &valueRestorer{converter: converter, dbType: database.GetConfig().Type}
+	if restorer.dbType != "mongo" {
+		if restorer.schema, err = database.Describe(ctx, table); err != nil {
+			return nil, err
+		}
+	}
+	return restorer, nil
+}
+
+// save writes all dead letters to a temporary file and moves it
+// in place of the store's file, so that it's never left half-written.
+func (d *DeadLetters) save() {
+	letters := make([]*DeadLetter, 0, len(d.letters))
+	for _, letter := range d.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+
+	lettersJSON, err := json.MarshalIndent(letters, "", "	")
+	if err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+		return
+	}
+	tmpPath := d.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, lettersJSON, 0644); err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+		return
+	}
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		log.Println("[dead letters] ERROR: ", err)
+	}
+}
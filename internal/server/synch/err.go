@@ -1,6 +1,11 @@
 package synch
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+)
 
 type synchInitError struct {
 	method string
@@ -11,6 +16,10 @@ func (e *synchInitError) Error() string {
 	return fmt.Sprintf("[ERROR] synch init: %s in method %s", e.errMsg, e.method)
 }
 
+func (e *synchInitError) Category() apperr.Category {
+	return apperr.CONFIG
+}
+
 type mappingError struct {
 	errMsg string
 }
@@ -18,3 +27,61 @@ type mappingError struct {
 func (e *mappingError) Error() string {
 	return fmt.Sprintf("[ERROR] mapping: %s", e.errMsg)
 }
+
+func (e *mappingError) Category() apperr.Category {
+	return apperr.MAPPING
+}
+
+// pairingError is returned if records can't be paired,
+// because they aren't sorted or their match keys can't be compared.
+type pairingError struct {
+	tableName string
+	errMsg    string
+}
+
+func (e *pairingError) Error() string {
+	return fmt.Sprintf("[ERROR] pairing records of table %s: %s", e.tableName, e.errMsg)
+}
+
+func (e *pairingError) Category() apperr.Category {
+	return apperr.MAPPING
+}
+
+// schemaError lists the problems found while validating
+// a synch's columns against the databases' schemas.
+type schemaError struct {
+	synchName string
+	problems  []string
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("[ERROR] synch %s doesn't match the database schemas:\n- %s", e.synchName, strings.Join(e.problems, "\n- "))
+}
+
+func (e *schemaError) Category() apperr.Category {
+	return apperr.MAPPING
+}
+
+// writeError is returned if a write to a target database failed permanently.
+// It keeps the write, so that the failure can be reported along with the dto.
+type writeError struct {
+	write        *write
+	attempts     int
+	deadLetterID string
+	err          error
+}
+
+func (e *writeError) Error() string {
+	if e.deadLetterID != "" {
+		return fmt.Sprintf("%s, stored as dead letter %s after %d attempt(s)", e.err, e.deadLetterID, e.attempts)
+	}
+	return e.err.Error()
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
+
+func (e *writeError) Category() apperr.Category {
+	return apperr.CategoryOf(e.err)
+}
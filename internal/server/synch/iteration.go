@@ -3,10 +3,14 @@ package synch
 import (
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// iteration collects the operations of a single run, which
+// are added by the workers synchronizing pairs.
 type iteration struct {
+	mux        sync.Mutex
 	id         string
 	synch      *Synch
 	operations []operation
@@ -24,6 +28,8 @@ func getNewIterationID(synch *Synch) string {
 }
 
 func (i *iteration) addOperation(op operation) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 	i.operations = append(i.operations, op)
 	if !i.synch.IsSimulation() {
 		fmt.Println(op.toJSON())
@@ -31,5 +37,7 @@ func (i *iteration) addOperation(op operation) {
 }
 
 func (i *iteration) flush() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
 	i.synch.result.Operations = append(i.synch.result.Operations, i.operations...)
 }
@@ -1,11 +1,11 @@
 package synch
 
 import (
+	"context"
 	"log"
-	"strings"
-	"sync"
 
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 	"github.com/google/uuid"
 )
 
@@ -21,105 +21,183 @@ type Link struct {
 	targetTable  *table
 	sourceColumn string
 	targetColumn string
-	sourceWhere  string
-	targetWhere  string
+	sourcePath   []cfg.PathSegment
+	targetPath   []cfg.PathSegment
+	sourceFilter cfg.Filter
+	targetFilter cfg.Filter
 	sourceExID   string
 	targetExID   string
-	pairs        []*Pair
+	// The records are streamed and paired page by page.
+	// targetGroup holds the target records, whose match key is targetGroupKey.
+	sourceRecords  *recordStream
+	targetRecords  *recordStream
+	targetGroup    []*record
+	targetGroupKey interface{}
 }
 
-func createLink(synch Synchronizer, link map[string]string) *Link {
+func createLink(synch Synchronizer, link *cfg.LinkStmt) (*Link, error) {
 
-	sourceNode, sourceNodeFound := synch.GetNodes()[link[cfg.PSUBEXP_SOURCE_NODE]]
+	sourceNode, sourceNodeFound := synch.GetNodes()[link.Source.Ref.Node]
 	if !sourceNodeFound {
-		panic("[create link] ERROR: source node not found.")
+		return nil, &synchInitError{method: "createLink", errMsg: "source node \"" + link.Source.Ref.Node + "\" not found"}
 	}
-	targetNode, targetNodeFound := synch.GetNodes()[link[cfg.PSUBEXP_TARGET_NODE]]
+	targetNode, targetNodeFound := synch.GetNodes()[link.Target.Ref.Node]
 	if !targetNodeFound {
-		panic("[create link] ERROR: target node not found.")
+		return nil, &synchInitError{method: "createLink", errMsg: "target node \"" + link.Target.Ref.Node + "\" not found"}
 	}
 
 	newLink := Link{
 		id:           uuid.New().String(),
 		synch:        synch,
-		Cmd:          link["cmd"],
+		Cmd:          link.Cmd,
 		source:       sourceNode,
 		target:       targetNode,
 		sourceTable:  sourceNode.tbl,
 		targetTable:  targetNode.tbl,
-		sourceColumn: link[cfg.PSUBEXP_SOURCE_COLUMN],
-		targetColumn: link[cfg.PSUBEXP_TARGET_COLUMN],
-		sourceWhere:  link[cfg.PSUBEXP_SOURCE_WHERE],
-		targetWhere:  link[cfg.PSUBEXP_TARGET_WHERE],
+		sourceColumn: link.Source.Ref.Column,
+		targetColumn: link.Target.Ref.Column,
+		sourcePath:   link.Source.Ref.Path,
+		targetPath:   link.Target.Ref.Path,
+		sourceFilter: link.Source.GetFilter(),
+		targetFilter: link.Target.GetFilter(),
 	}
 
 	if synch.GetConfig().Match.Method == "ids" {
 		for _, marg := range synch.GetConfig().Match.Args {
-			margSplt := strings.Split(marg, ".")
-			margNode := margSplt[0]
-			margColumn := margSplt[1]
-			if margNode == newLink.source.cfg.Name {
-				newLink.sourceExID = margColumn
-			} else if margNode == newLink.target.cfg.Name {
-				newLink.targetExID = margColumn
+			margRef, err := cfg.ParseColumnRef(marg)
+			if err != nil {
+				return nil, &synchInitError{method: "createLink", errMsg: "invalid match argument \"" + marg + "\": " + err.Error()}
+			}
+			if margRef.Node == newLink.source.cfg.Name {
+				newLink.sourceExID = margRef.Column
+			} else if margRef.Node == newLink.target.cfg.Name {
+				newLink.targetExID = margRef.Column
 			}
 		}
 	}
 
-	return &newLink
+	return &newLink, nil
 }
 
 func (l Link) GetID() string {
 	return l.id
 }
 
-func (l *Link) comparePair(src *record, c chan bool) {
-	var pairFound bool = false
+// open starts selecting the records of both nodes sorted by their match columns.
+func (l *Link) open(ctx context.Context) error {
+	pageSize := l.synch.GetConfig().GetPageSize()
+
+	sourceIterator, err := (*l.source.db).Iterate(ctx, l.source.tbl.name, db.SelectOptions{
+		Filter:   l.sourceFilter,
+		OrderBy:  orderColumns(l.sourceExID, l.source.cfg.Key),
+		PageSize: pageSize,
+	})
+	if err != nil {
+		return err
+	}
+	l.sourceRecords = newRecordStream(l.source, sourceIterator, l.sourceExID)
+
+	targetIterator, err := (*l.target.db).Iterate(ctx, l.target.tbl.name, db.SelectOptions{
+		Filter:   l.targetFilter,
+		OrderBy:  orderColumns(l.targetExID, l.target.cfg.Key),
+		PageSize: pageSize,
+	})
+	if err != nil {
+		return err
+	}
+	l.targetRecords = newRecordStream(l.target, targetIterator, l.targetExID)
 
-	for j := range *l.targetTable.activeRecords {
-		target := (*l.targetTable.activeRecords)[j]
+	return nil
+}
 
-		if l.synch.GetConfig().Match.Method == "ids" {
-			sourceExternalID, sourceOk := src.Data[l.sourceExID]
-			targetExternalID, targetOk := target.Data[l.targetExID]
+// orderColumns sorts records by the match column. The key column makes the order unique.
+func orderColumns(matchColumn string, keyColumn string) []string {
+	if matchColumn == "" || matchColumn == keyColumn {
+		return []string{keyColumn}
+	}
+	return []string{matchColumn, keyColumn}
+}
 
-			if !sourceOk || !targetOk {
-				continue
-			}
+// createPairs pairs the next page of source records with the target records.
+// Both are sorted by their match keys, so the target records are read only once
+// and just the ones with the current key are kept. An empty slice means all
+// records have been paired.
+func (l *Link) createPairs(ctx context.Context) ([]*Pair, error) {
+	sources, err := l.sourceRecords.nextPage(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-			if areEqual, err := areEqual(sourceExternalID, targetExternalID); err != nil {
-				log.Println(err)
-			} else if areEqual {
-				newPair := createPair(l, src, target)
-				l.pairs = append(l.pairs, newPair)
-				pairFound = true
-				src.PairedIn = append(src.PairedIn, l)
-				target.PairedIn = append(target.PairedIn, l)
-			}
+	pairs := make([]*Pair, 0, len(sources))
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-	}
 
-	c <- pairFound
+		targets, err := l.findTargets(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) == 0 {
+			pairs = append(pairs, createPair(l, source, nil))
+		}
+		for _, target := range targets {
+			pairs = append(pairs, createPair(l, source, target))
+		}
+	}
+	return pairs, nil
 }
 
-// createPairs for each active record in source database finds a corresponding acitve record in target database.
-func (l *Link) createPairs(wg *sync.WaitGroup) {
-	for i := range *l.sourceTable.activeRecords {
-		ch := make(chan bool)
-		source := (*l.sourceTable.activeRecords)[i]
+// findTargets returns the target records with the same match key as the source record.
+// Records without a match key never have a pair.
+func (l *Link) findTargets(ctx context.Context, source *record) ([]*record, error) {
+	sourceKey := source.Data[l.sourceExID]
+	if sourceKey == nil {
+		return nil, nil
+	}
+	if l.targetGroupKey != nil {
+		if cmp, err := compareKeys(l.targetGroupKey, sourceKey); err != nil {
+			return nil, &pairingError{tableName: l.source.tbl.name, errMsg: err.Error()}
+		} else if cmp == 0 {
+			return l.targetGroup, nil
+		}
+	}
 
-		go l.comparePair(source, ch)
+	l.targetGroup, l.targetGroupKey = nil, sourceKey
+	for {
+		target, err := l.targetRecords.peek(ctx)
+		if err != nil || target == nil {
+			return l.targetGroup, err
+		}
 
-		if !<-ch {
-			newPair := createPair(l, source, nil)
-			l.pairs = append(l.pairs, newPair)
+		cmp, err := compareKeys(target.Data[l.targetExID], sourceKey)
+		if err != nil {
+			return nil, &pairingError{tableName: l.target.tbl.name, errMsg: err.Error()}
 		}
+		if cmp > 0 {
+			return l.targetGroup, nil
+		}
+		if cmp == 0 {
+			l.targetGroup = append(l.targetGroup, target)
+		}
+		l.targetRecords.skip()
 	}
-	wg.Done()
 }
 
+// reset closes the record streams and counts the records they've read.
 func (l *Link) reset() {
-	l.sourceTable.activeRecords = nil
-	l.targetTable.activeRecords = nil
-	l.pairs = nil
+	for _, stream := range []*recordStream{l.sourceRecords, l.targetRecords} {
+		if stream == nil {
+			continue
+		}
+		selected := stream.selected
+		l.synch.GetCounters().add(func(run *Counters) { run.Selected += selected })
+		if err := stream.close(); err != nil {
+			log.Println(err)
+		}
+	}
+	l.sourceRecords = nil
+	l.targetRecords = nil
+	l.targetGroup = nil
+	l.targetGroupKey = nil
 }
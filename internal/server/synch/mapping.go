@@ -10,28 +10,30 @@ type Mapping struct {
 	target       *node
 	sourceColumn string
 	targetColumn string
-	raw          map[string]string
+	sourcePath   []cfg.PathSegment
+	targetPath   []cfg.PathSegment
 }
 
-func createMapping(synch *Synch, mapping map[string]string) *Mapping {
+func createMapping(synch *Synch, mapping *cfg.MappingStmt) (*Mapping, error) {
 
-	_, sourceNodeFound := mapping[cfg.PSUBEXP_SOURCE_NODE]
+	sourceNode, sourceNodeFound := synch.dbStore.nodes[mapping.Source.Node]
 	if !sourceNodeFound {
-		panic("[create mapping] ERROR: source node not found.")
+		return nil, &mappingError{errMsg: "source node \"" + mapping.Source.Node + "\" not found"}
 	}
-	_, targetNodeFound := mapping[cfg.PSUBEXP_TARGET_NODE]
+	targetNode, targetNodeFound := synch.dbStore.nodes[mapping.Target.Node]
 	if !targetNodeFound {
-		panic("[create mapping] ERROR: target node not found.")
+		return nil, &mappingError{errMsg: "target node \"" + mapping.Target.Node + "\" not found"}
 	}
 
 	newMapping := Mapping{
 		synch:        synch,
-		source:       synch.dbStore.nodes[mapping[cfg.PSUBEXP_SOURCE_NODE]],
-		target:       synch.dbStore.nodes[mapping[cfg.PSUBEXP_TARGET_NODE]],
-		sourceColumn: mapping[cfg.PSUBEXP_SOURCE_COLUMN],
-		targetColumn: mapping[cfg.PSUBEXP_TARGET_COLUMN],
-		raw:          mapping,
+		source:       sourceNode,
+		target:       targetNode,
+		sourceColumn: mapping.Source.Column,
+		targetColumn: mapping.Target.Column,
+		sourcePath:   mapping.Source.Path,
+		targetPath:   mapping.Target.Path,
 	}
 
-	return &newMapping
+	return &newMapping, nil
 }
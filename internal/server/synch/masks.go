@@ -0,0 +1,151 @@
+package synch
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+)
+
+// FAKE_EMAIL_DOMAIN is the domain of generated emails, which is reserved for examples.
+const FAKE_EMAIL_DOMAIN = "example.com"
+
+var fakeFirstNames = []string{
+	"Alice", "Bruno", "Clara", "Daniel", "Emma", "Felix", "Grace", "Henry",
+	"Ida", "Jonas", "Kate", "Leon", "Maria", "Noah", "Olivia", "Peter",
+}
+
+var fakeLastNames = []string{
+	"Adams", "Baker", "Carter", "Dalton", "Evans", "Fisher", "Graham", "Harris",
+	"Irving", "Jensen", "Keller", "Lambert", "Morgan", "Nolan", "Owens", "Parker",
+}
+
+// mask anonymizes the values written to a target column.
+// Masks are deterministic, the same value always gets the same replacement,
+// so masked values converge with the target's values and aren't written again.
+type mask struct {
+	cfg *cfg.Mask
+}
+
+func newMask(maskCfg *cfg.Mask) (*mask, error) {
+	switch maskCfg.Function {
+	case cfg.MASK_HASH:
+		if maskCfg.Salt == "" {
+			return nil, fmt.Errorf("masking function \"%s\" needs a salt", maskCfg.Function)
+		}
+	case cfg.MASK_TRUNCATE_DATE:
+		if unit := maskCfg.GetUnit(); unit != cfg.TRUNCATE_YEAR && unit != cfg.TRUNCATE_MONTH && unit != cfg.TRUNCATE_DAY {
+			return nil, fmt.Errorf("unknown unit \"%s\"", unit)
+		}
+	case cfg.MASK_REDACT, cfg.MASK_FAKE_NAME, cfg.MASK_FAKE_EMAIL:
+	default:
+		return nil, fmt.Errorf("unknown masking function \"%s\"", maskCfg.Function)
+	}
+	return &mask{cfg: maskCfg}, nil
+}
+
+// maskKey identifies a masked column of a node.
+func maskKey(nodeName string, column string, path []cfg.PathSegment) string {
+	return nodeName + "." + column + cfg.FormatPath(path)
+}
+
+// apply masks a value of the given column. Nulls stay null. Truncated times are returned
+// in the converter's zone, so that they're read back correctly as values of columns without
+// a time zone, other values are masked as text. Errors only name the value's type, because
+// they're reported and the value mustn't leave the source unmasked.
+func (m *mask) apply(value interface{}, converter *valueConverter, col *db.Column) (interface{}, error) {
+	canonical, err := converter.canonical(value, col)
+	if err != nil {
+		return nil, fmt.Errorf("value of type %T can't be read", value)
+	}
+	if canonical == nil {
+		return nil, nil
+	}
+
+	if m.cfg.Function == cfg.MASK_TRUNCATE_DATE {
+		t, isTime := canonical.(time.Time)
+		if !isTime {
+			return nil, fmt.Errorf("value of type %T isn't a time", value)
+		}
+		return truncateTime(t.In(converter.location), m.cfg.GetUnit()), nil
+	}
+
+	text, err := maskedText(canonical)
+	if err != nil {
+		return nil, err
+	}
+	switch m.cfg.Function {
+	case cfg.MASK_HASH:
+		sum := m.seed(text)
+		return hex.EncodeToString(sum[:]), nil
+	case cfg.MASK_REDACT:
+		return redact(text, m.cfg.Keep), nil
+	case cfg.MASK_FAKE_NAME:
+		first, last := m.fakeName(text)
+		return first + " " + last, nil
+	case cfg.MASK_FAKE_EMAIL:
+		sum := m.seed(text)
+		first, last := m.fakeName(text)
+		return strings.ToLower(first+"."+last) + "." + hex.EncodeToString(sum[8:11]) + "@" + FAKE_EMAIL_DOMAIN, nil
+	}
+	return nil, fmt.Errorf("unknown masking function \"%s\"", m.cfg.Function)
+}
+
+// seed hashes the salted text, fake values are picked by the hash's bytes.
+func (m *mask) seed(text string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(m.cfg.Salt + text))
+}
+
+func (m *mask) fakeName(text string) (string, string) {
+	sum := m.seed(text)
+	first := fakeFirstNames[binary.BigEndian.Uint32(sum[0:4])%uint32(len(fakeFirstNames))]
+	last := fakeLastNames[binary.BigEndian.Uint32(sum[4:8])%uint32(len(fakeLastNames))]
+	return first, last
+}
+
+// maskedText formats a canonical scalar value as text.
+// Documents and arrays can't be masked as a whole.
+func maskedText(canonical interface{}) (string, error) {
+	switch v := canonical.(type) {
+	case string:
+		return v, nil
+	case number:
+		return formatNumber(v), nil
+	case notANumber:
+		return "NaN", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	}
+	return "", fmt.Errorf("values of type %T can't be masked, only scalar values can", canonical)
+}
+
+// redact replaces the characters of a text with asterisks, except for the last ones.
+// Texts, which aren't longer than the kept characters, are redacted completely.
+func redact(text string, keep int) string {
+	runes := []rune(text)
+	if keep < 0 || keep >= len(runes) {
+		keep = 0
+	}
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+func truncateTime(t time.Time, unit string) time.Time {
+	month, day := t.Month(), t.Day()
+	switch unit {
+	case cfg.TRUNCATE_YEAR:
+		month, day = time.January, 1
+	case cfg.TRUNCATE_MONTH:
+		day = 1
+	}
+	return time.Date(t.Year(), month, day, 0, 0, 0, 0, t.Location())
+}
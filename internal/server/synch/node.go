@@ -7,18 +7,21 @@ import (
 
 // node holds all the data necessary for
 // data exchange.
+// Schemas of the child tables are described together with the node's table.
 type node struct {
-	cfg         *cfg.NodeConfig
-	db          *db.Database
-	tbl         *table
-	matchColumn string
+	cfg          *cfg.NodeConfig
+	db           *db.Database
+	tbl          *table
+	matchColumn  string
+	childSchemas map[string]*db.TableSchema
 }
 
-func createNode(cfg *cfg.NodeConfig, db *db.Database, tbl *table) *node {
+func createNode(cfg *cfg.NodeConfig, database *db.Database, tbl *table) *node {
 	newNode := node{
-		cfg: cfg,
-		db:  db,
-		tbl: tbl,
+		cfg:          cfg,
+		db:           database,
+		tbl:          tbl,
+		childSchemas: make(map[string]*db.TableSchema),
 	}
 	return &newNode
 }
@@ -26,3 +29,29 @@ func createNode(cfg *cfg.NodeConfig, db *db.Database, tbl *table) *node {
 func (n *node) setMatchColumn(col string) {
 	n.matchColumn = col
 }
+
+// getColumn returns the description of the column or nil if it isn't known.
+// Children are described as virtual array columns.
+func (n *node) getColumn(name string) *db.Column {
+	if child := n.cfg.GetChild(name); child != nil {
+		return childColumn(child, n.childSchemas[child.Name])
+	}
+	if n.tbl.schema == nil {
+		return nil
+	}
+	return n.tbl.schema.GetColumn(name)
+}
+
+// describeValue returns the description of the column holding a value or nil if
+// it isn't known. Values nested in a column's documents aren't described.
+func (n *node) describeValue(column string, path []cfg.PathSegment) *db.Column {
+	if len(path) > 0 {
+		return nil
+	}
+	return n.getColumn(column)
+}
+
+// isChild tells whether the column holds the node's children.
+func (n *node) isChild(column string) bool {
+	return n.cfg.GetChild(column) != nil
+}
@@ -1,6 +1,12 @@
 package synch
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+)
 
 type operation interface {
 	toJSON() string
@@ -49,3 +55,53 @@ func (o *insertOperation) toJSON() string {
 	}
 	return string(operationsJSON)
 }
+
+// Phases, in which an operation can fail.
+const (
+	PHASE_COMPARE = "compare"
+	PHASE_PREPARE = "prepare"
+	PHASE_WRITE   = "write"
+)
+
+// failurePhase tells whether an operation failed while being written
+// or before, while its values were being prepared.
+func failurePhase(err error) string {
+	var wErr *writeError
+	if errors.As(err, &wErr) {
+		return PHASE_WRITE
+	}
+	return PHASE_PREPARE
+}
+
+// failedOperation is an update, insert or upsert, which couldn't be carried out.
+// Failed writes carry the attempted dto and the database's error.
+type failedOperation struct {
+	IterationId      string            `json:"iterationId"`
+	Timestamp        string            `json:"timestamp"`
+	Operation        string            `json:"operation"`
+	FailedOperation  string            `json:"failedOperation"`
+	Phase            string            `json:"phase"`
+	SourceTableName  string            `json:"sourceTableName"`
+	SourceKeyName    string            `json:"sourceKeyName"`
+	SourceKeyValue   interface{}       `json:"sourceKeyValue"`
+	SourceColumnName string            `json:"sourceColumnName"`
+	TargetTableName  string            `json:"targetTableName"`
+	TargetKeyName    string            `json:"targetKeyName,omitempty"`
+	TargetKeyValue   interface{}       `json:"targetKeyValue,omitempty"`
+	TargetColumnName string            `json:"targetColumnName"`
+	Write            string            `json:"write,omitempty"`
+	Dto              interface{}       `json:"dto,omitempty"`
+	Error            string            `json:"error"`
+	Category         apperr.Category   `json:"category"`
+	DatabaseError    *db.DatabaseError `json:"databaseError,omitempty"`
+	Attempts         int               `json:"attempts,omitempty"`
+	DeadLetterID     string            `json:"deadLetterId,omitempty"`
+}
+
+func (o *failedOperation) toJSON() string {
+	operationsJSON, err := json.MarshalIndent(o, "", "	")
+	if err != nil {
+		panic(err)
+	}
+	return string(operationsJSON)
+}
@@ -1,9 +1,12 @@
 package synch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 	"github.com/christoph-karpowicz/db_mediator/internal/util"
@@ -56,105 +59,315 @@ func createPair(link *Link, source *record, target *record) *Pair {
 
 // Synchronize carries out the synchronization of the two records.
 // Updates if this pair is complete (has both the source and the target)
-// and inserts if a target record has to be created.
-func (p Pair) Synchronize() (bool, error) {
-	if p.target != nil && util.StringSliceContains(p.Link.synch.GetConfig().Do, cfg.DB_UPDATE) {
-		sourceColumnValue := p.source.Data[p.Link.sourceColumn]
-		targetColumnValue := p.target.Data[p.Link.targetColumn]
-
-		if areEqual, err := areEqual(sourceColumnValue, targetColumnValue); err != nil {
-			log.Println(err)
+// and inserts if a target record has to be created. Upserts create
+// the target record unless another writer has created it meanwhile.
+// Failures are logged as failed operations and counted, so that
+// the remaining pairs still get synchronized.
+func (p Pair) Synchronize(ctx context.Context) (bool, error) {
+	do := p.Link.synch.GetConfig().Do
+	upsert := util.StringSliceContains(do, cfg.DB_UPSERT)
+	counters := p.Link.synch.GetCounters()
+	counters.add(func(run *Counters) {
+		if p.target != nil {
+			run.Paired++
+		} else {
+			run.Unpaired++
+		}
+	})
+
+	if p.target != nil && (upsert || util.StringSliceContains(do, cfg.DB_UPDATE)) {
+		targetColumnValue := getPathValue(p.target.Data[p.Link.targetColumn], p.Link.targetPath)
+		sourceColumn := p.Link.source.describeValue(p.Link.sourceColumn, p.Link.sourcePath)
+		targetColumn := p.Link.target.describeValue(p.Link.targetColumn, p.Link.targetPath)
+
+		// Masked values are compared, so that records, which have already been masked, aren't written again.
+		sourceColumnValue, maskErr := p.maskValue(getPathValue(p.source.Data[p.Link.sourceColumn], p.Link.sourcePath),
+			p.Link.sourceColumn, p.Link.sourcePath, p.Link.targetColumn, p.Link.targetPath)
+		if maskErr != nil {
+			p.logFailedOperation(cfg.OPERATION_UPDATE, PHASE_PREPARE, maskErr)
+		} else if areEqual, err := p.Link.synch.GetValueConverter().equal(sourceColumnValue, sourceColumn, targetColumnValue, targetColumn); err != nil {
+			p.logFailedOperation(cfg.OPERATION_UPDATE, PHASE_COMPARE, err)
 		} else if !areEqual {
-			updateErr := p.doUpdate(sourceColumnValue)
+			updateErr := p.doUpdate(ctx, sourceColumnValue)
 			if updateErr == nil {
-				p.logUpdateOrIdleOperation(cfg.OPERATION_UPDATE)
+				p.logUpdateOrIdleOperation(cfg.OPERATION_UPDATE, sourceColumnValue)
+				counters.add(func(run *Counters) { run.Updated++ })
 			} else {
-				log.Println(updateErr)
+				p.logFailedOperation(cfg.OPERATION_UPDATE, failurePhase(updateErr), updateErr)
 			}
 		} else {
 			if p.Link.synch.GetType() == ONE_OFF && p.Link.synch.IsSimulation() {
-				p.logUpdateOrIdleOperation(cfg.OPERATION_IDLE)
+				p.logUpdateOrIdleOperation(cfg.OPERATION_IDLE, sourceColumnValue)
 			}
+			counters.add(func(run *Counters) { run.Skipped++ })
+		}
+	} else if p.target == nil && (upsert || util.StringSliceContains(do, cfg.DB_INSERT)) {
+		operationType := cfg.OPERATION_INSERT
+		if upsert {
+			operationType = cfg.OPERATION_UPSERT
 		}
-	} else if p.target == nil && util.StringSliceContains(p.Link.synch.GetConfig().Do, cfg.DB_INSERT) {
-		inDto, insertErr := p.doInsert()
+		inDto, children, insertErr := p.doInsert(ctx, upsert)
 		if insertErr == nil {
-			p.logInsertOperation(inDto)
+			p.logInsertOperation(inDto, children, upsert)
+			counters.add(func(run *Counters) { run.Inserted++ })
 		} else {
-			log.Println(insertErr)
+			p.logFailedOperation(operationType, failurePhase(insertErr), insertErr)
 		}
+	} else {
+		counters.add(func(run *Counters) { run.Skipped++ })
 	}
 
 	return false, nil
 }
 
-func (p Pair) doUpdate(sourceColumnValue interface{}) error {
+func (p Pair) doUpdate(ctx context.Context, sourceColumnValue interface{}) error {
+	if p.Link.target.isChild(p.Link.targetColumn) {
+		return p.doReplaceChildren(ctx, sourceColumnValue)
+	}
+
+	keyValue, err := p.convertKeyValue()
+	if err != nil {
+		return err
+	}
+	newValue, err := p.convertValue(sourceColumnValue, p.Link.sourceColumn, p.Link.sourcePath, p.Link.targetColumn, p.Link.targetPath)
+	if err != nil {
+		return err
+	}
+
 	upDto := db.UpdateDto{
-		p.synchData.targetTableName,
-		p.synchData.targetExtIDName,
-		p.synchData.sourceKeyValue,
-		p.Link.targetColumn,
-		sourceColumnValue,
+		TableName:         p.synchData.targetTableName,
+		KeyName:           p.synchData.targetExtIDName,
+		KeyValue:          keyValue,
+		UpdatedColumnName: p.Link.targetColumn,
+		UpdatedPath:       p.Link.targetPath,
+		NewValue:          newValue,
 	}
 
 	if !p.Link.synch.IsSimulation() {
-		err := p.synchData.targetDb.Update(upDto)
-		if err != nil {
-			return err
-		}
+		return p.execute(ctx, &write{kind: WRITE_UPDATE, database: p.synchData.targetDb, dto: upDto})
 	}
 	return nil
 }
 
-func (p Pair) doInsert() (*db.InsertDto, error) {
-	inDto := p.prepareInsertValues()
+// doReplaceChildren writes the source value to the target's child table
+// in place of the target record's children.
+func (p Pair) doReplaceChildren(ctx context.Context, sourceColumnValue interface{}) error {
+	parentKey := parentKeyValue(p.target.Data[p.synchData.targetKeyName])
+	if parentKey == nil {
+		return &mappingError{errMsg: fmt.Sprintf("children \"%s\" can't be written, because the target record's key \"%s\" is null",
+			p.Link.targetColumn, p.synchData.targetKeyName)}
+	}
+	chDto, err := p.prepareChildren(sourceColumnValue, p.Link.sourceColumn, p.Link.sourcePath, p.Link.targetColumn, parentKey)
+	if err != nil {
+		return err
+	}
+
 	if !p.Link.synch.IsSimulation() {
-		err := p.synchData.targetDb.Insert(*inDto)
-		if err != nil {
-			return nil, err
+		return p.execute(ctx, &write{kind: WRITE_REPLACE_CHILDREN, database: p.synchData.targetDb, dto: *chDto})
+	}
+	return nil
+}
+
+// doInsert creates the target record, or upserts it by the match column, and then its children.
+func (p Pair) doInsert(ctx context.Context, upsert bool) (*db.InsertDto, map[string]*db.ChildrenDto, error) {
+	inDto, children, err := p.prepareInsertValues()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.Link.synch.IsSimulation() {
+		kind := WRITE_INSERT
+		if upsert {
+			kind = WRITE_UPSERT
+		}
+		if err := p.execute(ctx, &write{kind: kind, database: p.synchData.targetDb, dto: *inDto}); err != nil {
+			return nil, nil, err
+		}
+		for _, chDto := range children {
+			if err := p.execute(ctx, &write{kind: WRITE_REPLACE_CHILDREN, database: p.synchData.targetDb, dto: *chDto}); err != nil {
+				return nil, nil, err
+			}
 		}
 	}
-	return inDto, nil
+	return inDto, children, nil
 }
 
-func (p *Pair) prepareInsertValues() *db.InsertDto {
+// prepareInsertValues maps the source record's values to the target's columns.
+// Values mapped to nested paths are written to embedded documents and arrays.
+// Values mapped to children are returned separately, they reference the target
+// record by its key, so the key has to be mapped too.
+func (p *Pair) prepareInsertValues() (*db.InsertDto, map[string]*db.ChildrenDto, error) {
 	values := make(map[string]interface{})
-	for columnName, value := range p.source.Data {
-		targetColumn, err := p.findTargetColumnName(columnName)
+	childMappings := make([]*Mapping, 0)
+	for _, mapping := range p.Link.synch.GetMappings() {
+		if mapping.source != p.Link.source || mapping.target != p.Link.target {
+			continue
+		}
+		if mapping.target.isChild(mapping.targetColumn) {
+			childMappings = append(childMappings, mapping)
+			continue
+		}
+		value, err := p.maskValue(getPathValue(p.source.Data[mapping.sourceColumn], mapping.sourcePath),
+			mapping.sourceColumn, mapping.sourcePath, mapping.targetColumn, mapping.targetPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		converted, err := p.convertValue(value, mapping.sourceColumn, mapping.sourcePath, mapping.targetColumn, mapping.targetPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := setPathValue(values, mapping.targetColumn, mapping.targetPath, converted); err != nil {
+			return nil, nil, &mappingError{errMsg: err.Error()}
+		}
+	}
+
+	children := make(map[string]*db.ChildrenDto)
+	for _, mapping := range childMappings {
+		parentKey, isMapped := values[p.synchData.targetKeyName]
+		if !isMapped || parentKey == nil {
+			return nil, nil, &mappingError{errMsg: fmt.Sprintf("children \"%s\" can't be inserted, because the key \"%s\" of node \"%s\" isn't mapped",
+				mapping.targetColumn, p.synchData.targetKeyName, p.Link.target.cfg.Name)}
+		}
+		value, err := p.maskValue(getPathValue(p.source.Data[mapping.sourceColumn], mapping.sourcePath),
+			mapping.sourceColumn, mapping.sourcePath, mapping.targetColumn, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		chDto, err := p.prepareChildren(value, mapping.sourceColumn, mapping.sourcePath, mapping.targetColumn, parentKey)
 		if err != nil {
-			fmt.Println(err)
+			return nil, nil, err
 		}
-		values[targetColumn] = value
+		children[mapping.targetColumn] = chDto
+	}
+
+	keyValue, err := p.convertKeyValue()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	inDto := db.InsertDto{
-		p.synchData.targetTableName,
-		p.synchData.targetExtIDName,
-		p.synchData.sourceKeyValue,
-		values,
+		TableName: p.synchData.targetTableName,
+		KeyName:   p.synchData.targetExtIDName,
+		KeyValue:  keyValue,
+		Values:    values,
 	}
-	return &inDto
+	return &inDto, children, nil
 }
 
-func (p *Pair) findTargetColumnName(columnName string) (string, error) {
-	for _, mapping := range p.Link.synch.GetRawMappings() {
-		if columnName == mapping["sourceColumn"] {
-			return mapping["targetColumn"], nil
+// prepareChildren converts a source array of documents to the records of the target's child table.
+// Fields, which aren't embedded or which the child table doesn't have, are skipped.
+func (p *Pair) prepareChildren(value interface{}, sourceColumn string, sourcePath []cfg.PathSegment, targetColumn string, parentKey interface{}) (*db.ChildrenDto, error) {
+	child := p.Link.target.cfg.GetChild(targetColumn)
+	childCol := p.Link.target.getColumn(targetColumn)
+	converter := p.Link.synch.GetValueConverter()
+	mappingErr := func(errMsg string) error {
+		return &mappingError{errMsg: fmt.Sprintf("column \"%s%s\" can't be written to children \"%s\": %s",
+			sourceColumn, cfg.FormatPath(sourcePath), targetColumn, errMsg)}
+	}
+
+	canonical, err := converter.canonical(value, p.Link.source.describeValue(sourceColumn, sourcePath))
+	if err != nil {
+		return nil, mappingErr(err.Error())
+	}
+	elements, isArray := canonical.([]interface{})
+	if canonical != nil && !isArray {
+		return nil, mappingErr("children have to be an array of documents")
+	}
+
+	children := make([]map[string]interface{}, len(elements))
+	for i, element := range elements {
+		fields, isDocument := element.(map[string]interface{})
+		if !isDocument {
+			return nil, mappingErr(fmt.Sprintf("element %d isn't a document", i))
 		}
+		children[i] = make(map[string]interface{}, len(fields))
+		for field, fieldValue := range fields {
+			fieldCol := childCol.GetField(field)
+			if !isEmbeddedColumn(child, field) || (len(childCol.Fields) > 0 && fieldCol == nil) {
+				continue
+			}
+			if children[i][field], err = converter.fromCanonical(fieldValue, fieldCol, p.synchData.targetDb.GetConfig().Type); err != nil {
+				return nil, mappingErr(err.Error())
+			}
+		}
+	}
+
+	chDto := db.ChildrenDto{
+		TableName:      child.Table,
+		ParentKeyName:  child.ParentKey,
+		ParentKeyValue: parentKey,
+		Children:       children,
+	}
+	return &chDto, nil
+}
+
+// execute carries out a write according to the synch's retry policy and rate limit. Writes,
+// which fail permanently, are stored as dead letters, unless the run is being stopped.
+func (p *Pair) execute(ctx context.Context, w *write) error {
+	w.limiter = p.Link.synch.GetRateLimiter()
+	attempts, err := retryWrite(ctx, p.Link.synch.GetConfig().Retry, w)
+	if err == nil {
+		return nil
+	}
+	wErr := &writeError{write: w, attempts: attempts, err: err}
+	if ctx.Err() != nil || p.Link.synch.GetDeadLetters() == nil {
+		return wErr
+	}
+
+	letter, letterErr := newDeadLetter(p.Link.synch.GetConfig().Name, p.Link.synch.GetIteration().id, w, attempts, err)
+	if letterErr != nil {
+		log.Println("[dead letters] ERROR: ", letterErr)
+		return wErr
 	}
-	return "", &mappingError{errMsg: fmt.Sprintf("Mapping for column \"%s\" not found.", columnName)}
+	p.Link.synch.GetDeadLetters().Add(letter)
+	wErr.deadLetterID = letter.ID
+	return wErr
 }
 
-func (p *Pair) logUpdateOrIdleOperation(operationType string) {
+// convertValue converts a value of the source column to the target column's type.
+func (p *Pair) convertValue(value interface{}, sourceColumn string, sourcePath []cfg.PathSegment, targetColumn string, targetPath []cfg.PathSegment) (interface{}, error) {
+	converted, err := p.Link.synch.GetValueConverter().convert(
+		value,
+		p.Link.source.describeValue(sourceColumn, sourcePath),
+		p.Link.target.describeValue(targetColumn, targetPath),
+		p.synchData.targetDb.GetConfig().Type,
+	)
+	if err != nil {
+		return nil, &mappingError{errMsg: fmt.Sprintf("column \"%s%s\" can't be written to \"%s%s\": %s",
+			sourceColumn, cfg.FormatPath(sourcePath), targetColumn, cfg.FormatPath(targetPath), err)}
+	}
+	return converted, nil
+}
+
+// maskValue masks a source value written to the target column, if the column has a mask.
+func (p *Pair) maskValue(value interface{}, sourceColumn string, sourcePath []cfg.PathSegment, targetColumn string, targetPath []cfg.PathSegment) (interface{}, error) {
+	m := p.Link.synch.GetMask(p.Link.target.cfg.Name, targetColumn, targetPath)
+	if m == nil {
+		return value, nil
+	}
+	masked, err := m.apply(value, p.Link.synch.GetValueConverter(), p.Link.source.describeValue(sourceColumn, sourcePath))
+	if err != nil {
+		return nil, &mappingError{errMsg: fmt.Sprintf("column \"%s%s\" can't be masked for \"%s%s\" by \"%s\": %s",
+			sourceColumn, cfg.FormatPath(sourcePath), targetColumn, cfg.FormatPath(targetPath), m.cfg.Function, err)}
+	}
+	return masked, nil
+}
+
+// convertKeyValue converts the source's key, which is looked up in the target's match column.
+func (p *Pair) convertKeyValue() (interface{}, error) {
+	return p.convertValue(p.synchData.sourceKeyValue, p.synchData.sourceKeyName, nil, p.synchData.targetExtIDName, nil)
+}
+
+// logUpdateOrIdleOperation reports the source value, which has been written or compared.
+// Values of masked columns are reported masked.
+func (p *Pair) logUpdateOrIdleOperation(operationType string, sourceColumnValue interface{}) {
 	var targetKeyName string
 	var targetKeyValue interface{}
 	var targetColumnValue interface{}
 
-	var sourceColumnValue interface{} = p.source.Data[p.Link.sourceColumn].(interface{})
 	if p.target != nil {
 		targetKeyValue = p.target.Data[p.synchData.targetKeyName]
 		targetKeyName = p.synchData.targetKeyName
-		targetColumnValue = p.target.Data[p.Link.targetColumn].(interface{})
+		targetColumnValue = getPathValue(p.target.Data[p.Link.targetColumn], p.Link.targetPath)
 	} else {
 		targetKeyName = ""
 		targetKeyValue = nil
@@ -167,12 +380,12 @@ func (p *Pair) logUpdateOrIdleOperation(operationType string) {
 		SourceTableName:   p.synchData.sourceTableName,
 		SourceKeyName:     p.synchData.sourceKeyName,
 		SourceKeyValue:    p.source.Data[p.synchData.sourceKeyName],
-		SourceColumnName:  p.Link.sourceColumn,
+		SourceColumnName:  p.Link.sourceColumn + cfg.FormatPath(p.Link.sourcePath),
 		SourceColumnValue: sourceColumnValue,
 		TargetTableName:   p.synchData.targetTableName,
 		TargetKeyName:     targetKeyName,
 		TargetKeyValue:    targetKeyValue,
-		TargetColumnName:  p.Link.targetColumn,
+		TargetColumnName:  p.Link.targetColumn + cfg.FormatPath(p.Link.targetPath),
 		TargetColumnValue: targetColumnValue,
 	}
 
@@ -183,16 +396,73 @@ func (p *Pair) logUpdateOrIdleOperation(operationType string) {
 	p.Link.synch.GetIteration().addOperation(&operation)
 }
 
-func (p *Pair) logInsertOperation(inDto *db.InsertDto) {
+// logFailedOperation reports an operation, which failed in the given phase.
+// Failed writes are reported with their dto and the database's error.
+func (p *Pair) logFailedOperation(operationType string, phase string, err error) {
+	log.Println(err)
+
+	operation := failedOperation{
+		Operation:        cfg.OPERATION_FAILED,
+		Timestamp:        util.GetTimestamp(),
+		FailedOperation:  operationType,
+		Phase:            phase,
+		SourceTableName:  p.synchData.sourceTableName,
+		SourceKeyName:    p.synchData.sourceKeyName,
+		SourceKeyValue:   p.source.Data[p.synchData.sourceKeyName],
+		SourceColumnName: p.Link.sourceColumn + cfg.FormatPath(p.Link.sourcePath),
+		TargetTableName:  p.synchData.targetTableName,
+		TargetColumnName: p.Link.targetColumn + cfg.FormatPath(p.Link.targetPath),
+		Error:            err.Error(),
+		Category:         apperr.CategoryOf(err),
+	}
+	if p.target != nil {
+		operation.TargetKeyName = p.synchData.targetKeyName
+		operation.TargetKeyValue = p.target.Data[p.synchData.targetKeyName]
+	}
+
+	var wErr *writeError
+	if errors.As(err, &wErr) {
+		operation.Write = wErr.write.kind
+		operation.TargetTableName = wErr.write.tableName()
+		operation.Dto = wErr.write.dto
+		operation.Attempts = wErr.attempts
+		operation.DeadLetterID = wErr.deadLetterID
+	}
+	var dbErr *db.DatabaseError
+	if errors.As(err, &dbErr) {
+		operation.DatabaseError = dbErr
+	}
+
+	if !p.Link.synch.IsSimulation() {
+		operation.IterationId = p.Link.synch.GetIteration().id
+	}
+
+	p.Link.synch.GetIteration().addOperation(&operation)
+	p.Link.synch.GetCounters().add(func(run *Counters) { run.Failed++ })
+}
+
+func (p *Pair) logInsertOperation(inDto *db.InsertDto, children map[string]*db.ChildrenDto, upsert bool) {
+	operationType, values := cfg.OPERATION_INSERT, inDto.Values
+	if upsert {
+		operationType, values = cfg.OPERATION_UPSERT, inDto.GetKeyedValues()
+	}
+	insertedRow := make(map[string]interface{}, len(values)+len(children))
+	for column, value := range values {
+		insertedRow[column] = value
+	}
+	for name, chDto := range children {
+		insertedRow[name] = chDto.Children
+	}
+
 	operation := insertOperation{
-		Operation:        cfg.OPERATION_INSERT,
+		Operation:        operationType,
 		Timestamp:        util.GetTimestamp(),
 		SourceTableName:  p.synchData.sourceTableName,
 		SourceKeyName:    p.synchData.sourceKeyName,
 		SourceKeyValue:   p.source.Data[p.synchData.sourceKeyName],
 		SourceColumnName: p.Link.sourceColumn,
 		TargetTableName:  p.synchData.targetTableName,
-		InsertedRow:      inDto.Values,
+		InsertedRow:      insertedRow,
 	}
 
 	if !p.Link.synch.IsSimulation() {
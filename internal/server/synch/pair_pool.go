@@ -0,0 +1,103 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+)
+
+// PAIR_QUEUE_SIZE is the number of pairs waiting for each worker.
+const PAIR_QUEUE_SIZE = 64
+
+// pairPool synchronizes pairs with a fixed number of workers. Pairs of the same
+// target record always go to the same worker, so they're synchronized in the order
+// they've been submitted in. Each target node has a limited number of workers
+// writing to it at the same time.
+type pairPool struct {
+	ctx     context.Context
+	queues  []chan *Pair
+	targets map[string]chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newPairPool starts the workers. Once the context gets cancelled, the workers finish
+// their current pairs and skip the remaining ones.
+func newPairPool(ctx context.Context, concurrency cfg.Concurrency, links []*Link) *pairPool {
+	pool := &pairPool{
+		ctx:     ctx,
+		queues:  make([]chan *Pair, concurrency.GetWorkers()),
+		targets: make(map[string]chan struct{}),
+	}
+	for _, lnk := range links {
+		if _, found := pool.targets[lnk.target.cfg.Name]; !found {
+			pool.targets[lnk.target.cfg.Name] = make(chan struct{}, concurrency.GetPerTarget())
+		}
+	}
+
+	pool.wg.Add(len(pool.queues))
+	for i := range pool.queues {
+		pool.queues[i] = make(chan *Pair, PAIR_QUEUE_SIZE)
+		go pool.work(pool.queues[i])
+	}
+	return pool
+}
+
+// submit queues a pair for the worker synchronizing its target record.
+func (pp *pairPool) submit(ctx context.Context, pair *Pair) error {
+	hash := fnv.New32a()
+	hash.Write([]byte(pair.orderKey()))
+	queue := pp.queues[hash.Sum32()%uint32(len(pp.queues))]
+
+	select {
+	case queue <- pair:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close waits for the workers to synchronize the queued pairs.
+func (pp *pairPool) close() {
+	for _, queue := range pp.queues {
+		close(queue)
+	}
+	pp.wg.Wait()
+}
+
+func (pp *pairPool) work(queue chan *Pair) {
+	defer pp.wg.Done()
+	for pair := range queue {
+		if pp.ctx.Err() != nil {
+			continue
+		}
+
+		target := pp.targets[pair.Link.target.cfg.Name]
+		select {
+		case target <- struct{}{}:
+		case <-pp.ctx.Done():
+			continue
+		}
+		if _, err := pair.Synchronize(pp.ctx); err != nil {
+			log.Println(err)
+		}
+		<-target
+	}
+}
+
+// orderKey identifies the target record the pair writes to. Target records are
+// looked up by the source's match key, unpaired records without one are only
+// written by their own pairs.
+func (p *Pair) orderKey() string {
+	key := p.source.Data[p.Link.sourceExID]
+	if key == nil {
+		key = p.synchData.sourceKeyValue
+	}
+	if normalized, err := groupKey(key); err == nil {
+		return p.Link.target.cfg.Name + ":" + normalized
+	}
+	return p.Link.target.cfg.Name + ":" + fmt.Sprint(key)
+}
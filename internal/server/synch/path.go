@@ -0,0 +1,122 @@
+package synch
+
+import (
+	"fmt"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// getPathValue returns the value nested in a column's value. A "[*]" segment
+// returns the values found in all elements of an array. Missing values are nil.
+func getPathValue(value interface{}, path []cfg.PathSegment) interface{} {
+	for i, segment := range path {
+		switch {
+		case value == nil:
+			return nil
+		case !segment.IsIndex:
+			document, isDocument := documentFields(value)
+			if !isDocument {
+				return nil
+			}
+			value = document[segment.Field]
+		default:
+			elements, isArray := arrayElements(value)
+			if !isArray {
+				return nil
+			}
+			if segment.Index != cfg.ANY_INDEX {
+				if segment.Index >= len(elements) {
+					return nil
+				}
+				value = elements[segment.Index]
+				continue
+			}
+			values := make([]interface{}, len(elements))
+			for j, element := range elements {
+				values[j] = getPathValue(element, path[i+1:])
+			}
+			return values
+		}
+	}
+	return value
+}
+
+// setPathValue writes a value to a path nested in a document's column. Missing embedded
+// documents and arrays are created. A "[*]" segment requires an array value,
+// whose elements are written to the elements of the document's array.
+func setPathValue(document map[string]interface{}, column string, path []cfg.PathSegment, value interface{}) error {
+	nested, err := setNestedValue(document[column], path, value)
+	if err != nil {
+		return fmt.Errorf("%s%s: %s", column, cfg.FormatPath(path), err)
+	}
+	document[column] = nested
+	return nil
+}
+
+func setNestedValue(container interface{}, path []cfg.PathSegment, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	segment, rest := path[0], path[1:]
+
+	if !segment.IsIndex {
+		document, isDocument := documentFields(container)
+		if !isDocument {
+			document = make(primitive.M)
+		}
+		nested, err := setNestedValue(document[segment.Field], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		document[segment.Field] = nested
+		return document, nil
+	}
+
+	elements, _ := arrayElements(container)
+	values := []interface{}{value}
+	first := segment.Index
+	if segment.Index == cfg.ANY_INDEX {
+		var isArray bool
+		if values, isArray = arrayElements(value); !isArray {
+			return nil, fmt.Errorf("value %v written to all elements of an array isn't an array", value)
+		}
+		first = 0
+	}
+
+	array := make(primitive.A, len(elements))
+	copy(array, elements)
+	for len(array) < first+len(values) {
+		array = append(array, nil)
+	}
+	for i, element := range values {
+		nested, err := setNestedValue(array[first+i], rest, element)
+		if err != nil {
+			return nil, err
+		}
+		array[first+i] = nested
+	}
+	return array, nil
+}
+
+func documentFields(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case primitive.M:
+		return v, true
+	case primitive.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+func arrayElements(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case primitive.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
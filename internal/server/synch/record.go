@@ -1,7 +1,5 @@
 package synch
 
 type record struct {
-	Data     map[string]interface{}
-	ActiveIn []*Link
-	PairedIn []*Link
+	Data map[string]interface{}
 }
@@ -1,23 +1,96 @@
 package synch
 
 import (
-	"errors"
-	"reflect"
+	"context"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 )
 
-type records []*record
+// recordStream reads a node's records page by page. The records have
+// to be sorted by the match column, which is checked while reading.
+type recordStream struct {
+	node        *node
+	iterator    db.RecordIterator
+	matchColumn string
+	page        []*record
+	pos         int
+	lastKey     interface{}
+	done        bool
+	// selected counts the records read so far.
+	selected int
+}
+
+func newRecordStream(n *node, iterator db.RecordIterator, matchColumn string) *recordStream {
+	return &recordStream{
+		node:        n,
+		iterator:    iterator,
+		matchColumn: matchColumn,
+	}
+}
+
+// nextPage returns the records of the current page, which haven't been read yet,
+// or the next page. An empty page means there are no more records.
+func (s *recordStream) nextPage(ctx context.Context) ([]*record, error) {
+	if s.pos >= len(s.page) {
+		if err := s.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	page := s.page[s.pos:]
+	s.pos = len(s.page)
+	return page, nil
+}
 
-func (r records) findRecordPointer(searchedRecord map[string]interface{}) (*record, error) {
-	for i := range r {
-		if reflect.DeepEqual(r[i].Data, searchedRecord) {
-			return r[i], nil
+// peek returns the next record without reading it or nil if there are no more records.
+func (s *recordStream) peek(ctx context.Context) (*record, error) {
+	if s.pos >= len(s.page) {
+		if err := s.load(ctx); err != nil {
+			return nil, err
 		}
 	}
-	return nil, errors.New("[data selection] Record hasn't been found")
+	if s.pos >= len(s.page) {
+		return nil, nil
+	}
+	return s.page[s.pos], nil
 }
 
-func (r *records) setActiveIn(lnk *Link) {
-	for _, record := range *r {
-		record.ActiveIn = append(record.ActiveIn, lnk)
+// skip reads the record returned by peek.
+func (s *recordStream) skip() {
+	s.pos++
+}
+
+func (s *recordStream) load(ctx context.Context) error {
+	s.page, s.pos = nil, 0
+	if s.done {
+		return nil
+	}
+
+	rawRecords, err := s.iterator.Next(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rawRecords) == 0 {
+		s.done = true
+		return nil
 	}
+
+	s.selected += len(rawRecords)
+	s.page = make([]*record, len(rawRecords))
+	for i, rawRecord := range rawRecords {
+		key := rawRecord[s.matchColumn]
+		if s.lastKey != nil {
+			if cmp, err := compareKeys(s.lastKey, key); err != nil {
+				return &pairingError{tableName: s.node.tbl.name, errMsg: err.Error()}
+			} else if cmp > 0 {
+				return &pairingError{tableName: s.node.tbl.name, errMsg: "records aren't sorted by the match column \"" + s.matchColumn + "\""}
+			}
+		}
+		s.lastKey = key
+		s.page[i] = &record{Data: rawRecord}
+	}
+	return s.node.attachChildren(ctx, s.page)
+}
+
+func (s *recordStream) close() error {
+	return s.iterator.Close()
 }
@@ -12,6 +12,7 @@ const (
 type Result struct {
 	Message    string      `json:"message"`
 	Operations []operation `json:"operations"`
+	Counters   Counters    `json:"counters"`
 	path       string
 }
 
@@ -23,6 +24,15 @@ func (r *Result) OperationsToJSON() string {
 	return string(operationsJSON)
 }
 
+// CountersToJSON returns the run's counters as JSON.
+func (r *Result) CountersToJSON() string {
+	countersJSON, err := json.MarshalIndent(r.Counters, "", "	")
+	if err != nil {
+		panic(err)
+	}
+	return string(countersJSON)
+}
+
 func (r *Result) operationsToJSONSlice() []string {
 	operationsToJSON := make([]string, 0)
 	for _, operation := range r.Operations {
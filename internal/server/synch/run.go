@@ -0,0 +1,206 @@
+package synch
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+)
+
+// LOOP_INTERVAL is the pause between iterations of an ongoing run.
+const LOOP_INTERVAL = 1 * time.Second
+
+const (
+	RUN_STATUS_RUNNING  = "running"
+	RUN_STATUS_FINISHED = "finished"
+	RUN_STATUS_STOPPED  = "stopped"
+	RUN_STATUS_FAILED   = "failed"
+)
+
+// Run represents a single execution of a synch config.
+// Every run has its own Synch instance, so that runs of the same
+// config don't share links, counters or results.
+type Run struct {
+	mux        sync.RWMutex
+	stopOnce   sync.Once
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	ID         string
+	synch      *Synch
+	stype      synchType
+	simulation bool
+	status     string
+	startedAt  time.Time
+	finishedAt time.Time
+	result     *Result
+}
+
+// RunInfo is a serializable summary of a run.
+type RunInfo struct {
+	ID         string `json:"id"`
+	Synch      string `json:"synch"`
+	Type       string `json:"type"`
+	Simulation bool   `json:"simulation"`
+	Status     string `json:"status"`
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// NewRun creates and initializes a new instance of the synch.
+// The run gets cancelled along with the parent context.
+// Writes, which fail permanently, are stored in the dead letters.
+func (s *Synch) NewRun(parentCtx context.Context, DBMap map[string]*db.Database, deadLetters *DeadLetters, stype string, simulation bool) (*Run, error) {
+	instance := &Synch{cfg: s.cfg, initial: true, deadLetters: deadLetters}
+	instance.SetSimulation(simulation)
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	run := &Run{
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		synch:      instance,
+		simulation: simulation,
+		status:     RUN_STATUS_RUNNING,
+		startedAt:  time.Now(),
+	}
+	runID, err := instance.Init(ctx, DBMap, stype)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	run.ID = runID
+	run.stype = instance.GetType()
+
+	return run, nil
+}
+
+// GetSynch returns the synch instance the run is executing.
+func (r *Run) GetSynch() *Synch {
+	return r.synch
+}
+
+// GetStatus returns the current status of the run.
+func (r *Run) GetStatus() string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.status
+}
+
+// GetResult returns the result of a finished run.
+func (r *Run) GetResult() *Result {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.result
+}
+
+func (r *Run) getFinishedAt() time.Time {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.finishedAt
+}
+
+// IsActive checks whether the run is still in progress.
+func (r *Run) IsActive() bool {
+	return r.GetStatus() == RUN_STATUS_RUNNING
+}
+
+// Execute carries out a single, one-off run of the synch.
+// If the run gets cancelled, a partial result is returned.
+func (r *Run) Execute() (*Result, error) {
+	defer close(r.done)
+	defer r.cancel()
+
+	err := r.synch.Run(r.ctx)
+	if err != nil && r.ctx.Err() == nil {
+		r.synch.Reset()
+		r.Fail(err)
+		return nil, err
+	}
+
+	result := r.synch.Flush()
+	r.synch.Reset()
+	if err != nil {
+		r.finish(RUN_STATUS_STOPPED, result)
+	} else {
+		r.finish(RUN_STATUS_FINISHED, result)
+	}
+	return result, nil
+}
+
+// Loop runs the synch repeatedly until the run is stopped.
+func (r *Run) Loop() {
+	defer close(r.done)
+
+	for r.synch.IsInitial() || r.synch.IsRunning() {
+		if err := r.synch.Run(r.ctx); err != nil && r.ctx.Err() == nil {
+			log.Println(err)
+		}
+		r.synch.SetInitial(false)
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(LOOP_INTERVAL):
+		}
+	}
+}
+
+// Stop cancels the run, waits for the current iteration
+// to finish and returns the result. Concurrent calls wait for
+// the first one and return the same result.
+func (r *Run) Stop() *Result {
+	r.stopOnce.Do(func() {
+		r.synch.Stop()
+		r.cancel()
+		<-r.done
+
+		// One-off runs flush their own results.
+		if r.stype != ONGOING {
+			return
+		}
+
+		result := r.synch.Flush()
+		r.synch.Reset()
+		r.finish(RUN_STATUS_STOPPED, result)
+	})
+	return r.GetResult()
+}
+
+// Fail marks the run as failed.
+func (r *Run) Fail(err error) {
+	r.finish(RUN_STATUS_FAILED, &Result{Message: err.Error()})
+}
+
+func (r *Run) finish(status string, result *Result) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.status = status
+	r.result = result
+	r.finishedAt = time.Now()
+}
+
+// Info returns a summary of the run.
+func (r *Run) Info() RunInfo {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	info := RunInfo{
+		ID:         r.ID,
+		Synch:      r.synch.GetConfig().Name,
+		Type:       r.stype.String(),
+		Simulation: r.simulation,
+		Status:     r.status,
+		StartedAt:  r.startedAt.Format(time.RFC3339),
+	}
+	if !r.finishedAt.IsZero() {
+		info.FinishedAt = r.finishedAt.Format(time.RFC3339)
+	}
+	if r.result != nil {
+		info.Message = r.result.Message
+	}
+	return info
+}
@@ -0,0 +1,98 @@
+package synch
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Finished runs keep their synch and result with all operations, so only
+// MAX_FINISHED_RUNS of them are kept, each for at most FINISHED_RUN_TTL.
+const (
+	MAX_FINISHED_RUNS = 100
+	FINISHED_RUN_TTL  = 24 * time.Hour
+)
+
+// Runs is a registry of all synch runs, active and finished.
+// Finished runs are pruned whenever runs are added or listed.
+type Runs struct {
+	mux         sync.RWMutex
+	runs        map[string]*Run
+	maxFinished int
+	ttl         time.Duration
+}
+
+// CreateRuns constructor function for the Runs struct.
+func CreateRuns() *Runs {
+	return &Runs{runs: make(map[string]*Run), maxFinished: MAX_FINISHED_RUNS, ttl: FINISHED_RUN_TTL}
+}
+
+// Add registers a new run.
+func (r *Runs) Add(run *Run) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.runs[run.ID] = run
+	r.prune()
+}
+
+// Get returns a run by its ID.
+func (r *Runs) Get(id string) (*Run, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	run, found := r.runs[id]
+	return run, found
+}
+
+// FindActive returns all active runs of a synch config.
+func (r *Runs) FindActive(synchName string) []*Run {
+	active := make([]*Run, 0)
+	for _, run := range r.List() {
+		if run.GetSynch().GetConfig().Name == synchName && run.IsActive() {
+			active = append(active, run)
+		}
+	}
+	return active
+}
+
+// List returns all runs sorted by their start time.
+func (r *Runs) List() []*Run {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.prune()
+
+	runList := make([]*Run, 0, len(r.runs))
+	for _, run := range r.runs {
+		runList = append(runList, run)
+	}
+	sort.Slice(runList, func(i, j int) bool {
+		return runList[i].startedAt.Before(runList[j].startedAt)
+	})
+	return runList
+}
+
+// prune removes finished runs, which have expired, and the oldest
+// finished runs over the limit. Active runs are always kept.
+func (r *Runs) prune() {
+	finished := make([]*Run, 0)
+	for id, run := range r.runs {
+		finishedAt := run.getFinishedAt()
+		if finishedAt.IsZero() {
+			continue
+		}
+		if time.Since(finishedAt) > r.ttl {
+			delete(r.runs, id)
+			continue
+		}
+		finished = append(finished, run)
+	}
+	if len(finished) <= r.maxFinished {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].getFinishedAt().Before(finished[j].getFinishedAt())
+	})
+	for _, run := range finished[:len(finished)-r.maxFinished] {
+		delete(r.runs, run.ID)
+	}
+}
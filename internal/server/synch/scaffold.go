@@ -0,0 +1,399 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+	"gopkg.in/yaml.v3"
+)
+
+// SCAFFOLD_MIN_SIMILARITY is the minimal similarity of two column names,
+// for which a mapping is proposed.
+const SCAFFOLD_MIN_SIMILARITY = 0.8
+
+// SCAFFOLD_MATCH_COLUMN is proposed as the target's match column,
+// if the target table doesn't have a column referencing the source's key.
+const SCAFFOLD_MATCH_COLUMN = "ext_id"
+
+// matchColumnNames are normalized names of columns, which usually hold external IDs.
+var matchColumnNames = []string{"extid", "externalid", "sourceid"}
+
+// ScaffoldTable is one of the two tables a synch config is scaffolded for.
+type ScaffoldTable struct {
+	Database string
+	Table    string
+}
+
+func (t ScaffoldTable) String() string {
+	return t.Database + "." + t.Table
+}
+
+// Draft is a scaffolded synch config along with notes for the user.
+type Draft struct {
+	Config         *cfg.SynchConfig
+	source         ScaffoldTable
+	target         ScaffoldTable
+	similarities   map[string]float64
+	unmappedSource []string
+	unmappedTarget []string
+	matchMissing   bool
+}
+
+// scaffoldMapping is a proposed mapping of a source column to a target column.
+type scaffoldMapping struct {
+	source     db.Column
+	target     db.Column
+	similarity float64
+}
+
+// Scaffold describes the two tables and drafts a synch config, which synchronizes
+// the source table to the target table. Columns are mapped by the similarity of their
+// names and the compatibility of their types. Records are matched by the source's key.
+func Scaffold(ctx context.Context, dbs db.Databases, name string, source ScaffoldTable, target ScaffoldTable) (*Draft, error) {
+	sourceSchema, err := describeScaffoldTable(ctx, dbs, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(sourceSchema.Columns) == 0 {
+		return nil, &db.DatabaseError{DBName: source.Database, ErrMsg: fmt.Sprintf("table %s is empty, so its columns aren't known", source.Table), Cat: apperr.CONFIG}
+	}
+	targetSchema, err := describeScaffoldTable(ctx, dbs, target)
+	if err != nil {
+		return nil, err
+	}
+	return draftConfig(name, source, sourceSchema, target, targetSchema), nil
+}
+
+func describeScaffoldTable(ctx context.Context, dbs db.Databases, t ScaffoldTable) (*db.TableSchema, error) {
+	database, found := dbs[t.Database]
+	if !found || *database == nil {
+		return nil, &db.DatabaseError{DBName: t.Database, ErrMsg: "database hasn't been configured", Cat: apperr.CONFIG}
+	}
+	if err := (*database).Init(); err != nil {
+		return nil, err
+	}
+	return (*database).Describe(ctx, t.Table)
+}
+
+func draftConfig(name string, source ScaffoldTable, sourceSchema *db.TableSchema, target ScaffoldTable, targetSchema *db.TableSchema) *Draft {
+	sourceNode := cfg.NodeConfig{Name: scaffoldNodeName(source), Database: source.Database, Table: source.Table, Key: keyColumn(sourceSchema)}
+	targetNode := cfg.NodeConfig{Name: scaffoldNodeName(target), Database: target.Database, Table: target.Table, Key: keyColumn(targetSchema)}
+	if sourceNode.Name == targetNode.Name {
+		sourceNode.Name += "_source"
+		targetNode.Name += "_target"
+	}
+
+	draft := &Draft{
+		Config: &cfg.SynchConfig{
+			Name:  name,
+			Nodes: []cfg.NodeConfig{sourceNode, targetNode},
+			Do:    []string{cfg.DB_UPDATE},
+		},
+		source:       source,
+		target:       target,
+		similarities: make(map[string]float64),
+	}
+
+	// Nothing is known about the fields of an empty collection, so they're named like the source's columns.
+	if targetSchema.Sampled && len(targetSchema.Columns) == 0 {
+		targetSchema = &db.TableSchema{Name: targetSchema.Name, Sampled: true, Columns: sourceSchema.Columns}
+		if targetNode.Key == "" {
+			draft.Config.Nodes[1].Key = "_id"
+		}
+	}
+
+	mappings := proposeMappings(sourceSchema, targetSchema)
+	matchMapping := proposeMatch(sourceSchema, targetSchema, mappings)
+	if matchMapping == nil {
+		draft.matchMissing = true
+		key := sourceSchema.GetColumn(sourceNode.Key)
+		matchMapping = &scaffoldMapping{source: *key, target: db.Column{Name: SCAFFOLD_MATCH_COLUMN}}
+	}
+	if !containsMapping(mappings, matchMapping) {
+		mappings = append([]*scaffoldMapping{matchMapping}, mappings...)
+	}
+
+	sourceRef := func(column string) string {
+		return (&cfg.ColumnRef{Node: sourceNode.Name, Column: column}).String()
+	}
+	targetRef := func(column string) string {
+		return (&cfg.ColumnRef{Node: targetNode.Name, Column: column}).String()
+	}
+
+	for _, mapping := range mappings {
+		mappingStr := sourceRef(mapping.source.Name) + " TO " + targetRef(mapping.target.Name)
+		draft.Config.Map = append(draft.Config.Map, mappingStr)
+		if mapping.similarity > 0 && mapping.similarity < 1 {
+			draft.similarities[mappingStr] = mapping.similarity
+		}
+		if mapping != matchMapping && mapping.source.Name != sourceNode.Key {
+			draft.Config.Link = append(draft.Config.Link, "["+sourceRef(mapping.source.Name)+"] TO ["+targetRef(mapping.target.Name)+"]")
+		}
+	}
+	draft.Config.Match = cfg.Match{Method: "ids", Args: []string{sourceRef(matchMapping.source.Name), targetRef(matchMapping.target.Name)}}
+
+	draft.unmappedSource, draft.unmappedTarget = unmappedColumns(sourceSchema, targetSchema, mappings)
+	return draft
+}
+
+// proposeMappings pairs up the most similar columns with compatible types.
+// Every column is used in one mapping at most.
+func proposeMappings(sourceSchema *db.TableSchema, targetSchema *db.TableSchema) []*scaffoldMapping {
+	candidates := make([]*scaffoldMapping, 0)
+	for _, sourceCol := range sourceSchema.Columns {
+		for _, targetCol := range targetSchema.Columns {
+			if targetCol.Kind == db.KIND_OBJECT_ID || !kindsCompatible(sourceCol.Kind, targetCol.Kind) {
+				continue
+			}
+			if similarity := nameSimilarity(sourceCol.Name, targetCol.Name); similarity >= SCAFFOLD_MIN_SIMILARITY {
+				candidates = append(candidates, &scaffoldMapping{source: sourceCol, target: targetCol, similarity: similarity})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+
+	mappedSource := make(map[string]bool)
+	mappedTarget := make(map[string]bool)
+	mappings := make([]*scaffoldMapping, 0)
+	for _, candidate := range candidates {
+		if mappedSource[candidate.source.Name] || mappedTarget[candidate.target.Name] {
+			continue
+		}
+		mappedSource[candidate.source.Name] = true
+		mappedTarget[candidate.target.Name] = true
+		mappings = append(mappings, candidate)
+	}
+
+	// Keep the source table's column order.
+	order := make(map[string]int)
+	for i, column := range sourceSchema.Columns {
+		order[column.Name] = i
+	}
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return order[mappings[i].source.Name] < order[mappings[j].source.Name]
+	})
+	return mappings
+}
+
+// proposeMatch finds the target column, which holds the source's key.
+// It's either the column the key is mapped to or a column named like an external ID.
+func proposeMatch(sourceSchema *db.TableSchema, targetSchema *db.TableSchema, mappings []*scaffoldMapping) *scaffoldMapping {
+	key := sourceSchema.GetColumn(keyColumn(sourceSchema))
+	if key == nil {
+		return nil
+	}
+	for _, mapping := range mappings {
+		if mapping.source.Name == key.Name {
+			return mapping
+		}
+	}
+
+	names := append([]string{normalizeName(unqualifiedName(sourceSchema.Name)) + "id"}, matchColumnNames...)
+	for _, targetCol := range targetSchema.Columns {
+		if util.StringSliceContains(names, normalizeName(targetCol.Name)) && kindsComparable(key.Kind, targetCol.Kind) && !isMappedTarget(mappings, targetCol.Name) {
+			return &scaffoldMapping{source: *key, target: targetCol}
+		}
+	}
+	return nil
+}
+
+// keyColumn returns the table's primary key, a column named "id" or the first column.
+func keyColumn(schema *db.TableSchema) string {
+	for _, column := range schema.Columns {
+		if column.PrimaryKey {
+			return column.Name
+		}
+	}
+	for _, column := range schema.Columns {
+		if normalizeName(column.Name) == "id" {
+			return column.Name
+		}
+	}
+	if len(schema.Columns) > 0 {
+		return schema.Columns[0].Name
+	}
+	return ""
+}
+
+func unmappedColumns(sourceSchema *db.TableSchema, targetSchema *db.TableSchema, mappings []*scaffoldMapping) ([]string, []string) {
+	unmappedSource := make([]string, 0)
+	for _, column := range sourceSchema.Columns {
+		mapped := false
+		for _, mapping := range mappings {
+			mapped = mapped || mapping.source.Name == column.Name
+		}
+		if !mapped {
+			unmappedSource = append(unmappedSource, column.Name)
+		}
+	}
+
+	unmappedTarget := make([]string, 0)
+	for _, column := range targetSchema.Columns {
+		if !column.PrimaryKey && !isMappedTarget(mappings, column.Name) {
+			unmappedTarget = append(unmappedTarget, column.Name)
+		}
+	}
+	return unmappedSource, unmappedTarget
+}
+
+func isMappedTarget(mappings []*scaffoldMapping, column string) bool {
+	for _, mapping := range mappings {
+		if mapping.target.Name == column {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMapping(mappings []*scaffoldMapping, searched *scaffoldMapping) bool {
+	for _, mapping := range mappings {
+		if mapping == searched {
+			return true
+		}
+	}
+	return false
+}
+
+// scaffoldNodeName names a node after its database and table, e.g. "dvdrental_film".
+func scaffoldNodeName(t ScaffoldTable) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, t.Database+"_"+unqualifiedName(t.Table))
+}
+
+// unqualifiedName strips the schema from a table name, e.g. "public.film" becomes "film".
+func unqualifiedName(table string) string {
+	if dot := strings.LastIndex(table, "."); dot != -1 {
+		table = table[dot+1:]
+	}
+	return strings.Trim(table, `"`)
+}
+
+// normalizeName lowercases a name and removes everything except letters and digits,
+// so that e.g. "rental_duration" and "Rental Duration" are the same.
+func normalizeName(name string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+// nameSimilarity compares normalized names using the Levenshtein distance.
+// 1 means the names are the same.
+func nameSimilarity(name1 string, name2 string) float64 {
+	runes1, runes2 := []rune(normalizeName(name1)), []rune(normalizeName(name2))
+	longer := len(runes1)
+	if len(runes2) > longer {
+		longer = len(runes2)
+	}
+	if longer == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(runes1, runes2))/float64(longer)
+}
+
+func levenshtein(runes1 []rune, runes2 []rune) int {
+	previous := make([]int, len(runes2)+1)
+	current := make([]int, len(runes2)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(runes1); i++ {
+		current[0] = i
+		for j := 1; j <= len(runes2); j++ {
+			cost := 1
+			if runes1[i-1] == runes2[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(runes2)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// YAML renders the draft with comments pointing out what has to be reviewed.
+func (d *Draft) YAML() ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(d.Config); err != nil {
+		return nil, err
+	}
+	root.HeadComment = fmt.Sprintf("Draft scaffolded from %s and %s.\nReview the mappings, links and match before running it.", d.source, d.target)
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "map":
+			key.HeadComment = d.mapComment()
+			for _, entry := range value.Content {
+				entry.Style = yaml.SingleQuotedStyle
+				if similarity, found := d.similarities[entry.Value]; found {
+					entry.LineComment = fmt.Sprintf("similarity %.2f", similarity)
+				}
+			}
+		case "link":
+			key.HeadComment = "Links choose the columns, which are updated in paired records."
+			setSingleQuoted(value)
+		case "match":
+			if d.matchMissing {
+				key.HeadComment = fmt.Sprintf("TODO: no column of %s seems to hold the keys of %s.\nCreate \"%s\" or change the match arguments.", d.target, d.source, SCAFFOLD_MATCH_COLUMN)
+			}
+			if _, args := mappingValue(value, "args"); args != nil {
+				setSingleQuoted(args)
+			}
+		case "do":
+			setSingleQuoted(value)
+			value.Content[len(value.Content)-1].LineComment = fmt.Sprintf("add '%s' to create missing target records", cfg.DB_INSERT)
+		}
+	}
+
+	return yaml.Marshal(&root)
+}
+
+func (d *Draft) mapComment() string {
+	lines := make([]string, 0)
+	if len(d.unmappedSource) > 0 {
+		lines = append(lines, "Source columns without a similar target column: "+strings.Join(d.unmappedSource, ", "))
+	}
+	if len(d.unmappedTarget) > 0 {
+		lines = append(lines, "Target columns without a mapping: "+strings.Join(d.unmappedTarget, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func setSingleQuoted(sequence *yaml.Node) {
+	for _, entry := range sequence.Content {
+		entry.Style = yaml.SingleQuotedStyle
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,219 @@
+package synch
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+)
+
+// compatibleKinds lists the kinds of target columns, to which values of a source
+// column's kind can be written. Every kind is compatible with itself. Mixed and
+// unknown kinds are compatible with all kinds, because they can't be checked.
+var compatibleKinds = map[string][]string{
+	db.KIND_INTEGER: {db.KIND_DECIMAL, db.KIND_STRING},
+	db.KIND_DECIMAL: {db.KIND_STRING},
+	db.KIND_BOOL:    {db.KIND_STRING},
+	db.KIND_TIME:    {db.KIND_STRING},
+	db.KIND_UUID:    {db.KIND_STRING},
+	db.KIND_STRING:  {db.KIND_UUID},
+}
+
+func kindsCompatible(sourceKind string, targetKind string) bool {
+	if sourceKind == targetKind || isUncheckedKind(sourceKind) || isUncheckedKind(targetKind) {
+		return true
+	}
+	return util.StringSliceContains(compatibleKinds[sourceKind], targetKind)
+}
+
+// kindsComparable checks whether match keys of the given kinds can ever be equal.
+func kindsComparable(kind1 string, kind2 string) bool {
+	if kind1 == kind2 || isUncheckedKind(kind1) || isUncheckedKind(kind2) {
+		return true
+	}
+	return isNumericKind(kind1) && isNumericKind(kind2)
+}
+
+func isUncheckedKind(kind string) bool {
+	return kind == db.KIND_MIXED || kind == db.KIND_UNKNOWN
+}
+
+func isNumericKind(kind string) bool {
+	return kind == db.KIND_INTEGER || kind == db.KIND_DECIMAL
+}
+
+// validateSchemas describes the tables of all nodes and their children and checks, that the columns
+// used by the synch exist and that mapped columns have compatible types.
+// Problems found in sampled schemas are only logged as warnings,
+// because a sample doesn't have to contain every column.
+func (s *Synch) validateSchemas(ctx context.Context) error {
+	schemas := make(map[string]*db.TableSchema)
+	for _, tbl := range s.dbStore.tables {
+		schema, err := (*tbl.db).Describe(ctx, tbl.name)
+		if err != nil {
+			return err
+		}
+		schemas[tbl.id] = schema
+		tbl.schema = schema
+	}
+	for _, n := range s.dbStore.nodes {
+		for i := range n.cfg.Children {
+			schema, err := (*n.db).Describe(ctx, n.cfg.Children[i].Table)
+			if err != nil {
+				return err
+			}
+			n.childSchemas[n.cfg.Children[i].Name] = schema
+		}
+	}
+
+	v := newSchemaValidator(schemas)
+	v.validate(s)
+
+	for _, warning := range v.warnings {
+		log.Printf("[schema] %s: %s\n", s.cfg.Name, warning)
+	}
+	if len(v.problems) > 0 {
+		return &schemaError{synchName: s.cfg.Name, problems: v.problems}
+	}
+	return nil
+}
+
+// schemaValidator collects the problems found in the tables' schemas.
+type schemaValidator struct {
+	schemas  map[string]*db.TableSchema
+	problems []string
+	warnings []string
+	reported map[string]bool
+}
+
+func newSchemaValidator(schemas map[string]*db.TableSchema) *schemaValidator {
+	return &schemaValidator{
+		schemas:  schemas,
+		problems: make([]string, 0),
+		warnings: make([]string, 0),
+		reported: make(map[string]bool),
+	}
+}
+
+func (v *schemaValidator) validate(s *Synch) {
+	upsert := util.StringSliceContains(s.cfg.Do, cfg.DB_UPSERT)
+	for _, nodeCfg := range s.cfg.Nodes {
+		n := s.dbStore.nodes[nodeCfg.Name]
+		v.checkColumn(n, n.cfg.Key, "key")
+		if n.matchColumn != "" {
+			v.checkColumn(n, n.matchColumn, "match")
+		}
+		for i := range n.cfg.Children {
+			v.checkChild(n, &n.cfg.Children[i])
+		}
+	}
+
+	for _, mapping := range s.mappings {
+		description := fmt.Sprintf("mapping %s.%s%s TO %s.%s%s", mapping.source.cfg.Name, mapping.sourceColumn, cfg.FormatPath(mapping.sourcePath),
+			mapping.target.cfg.Name, mapping.targetColumn, cfg.FormatPath(mapping.targetPath))
+		v.checkMapped(description, mapping.source, mapping.sourceColumn, mapping.sourcePath, mapping.target, mapping.targetColumn, mapping.targetPath)
+	}
+
+	for _, lnk := range s.Links {
+		description := fmt.Sprintf("link [%s.%s%s] TO [%s.%s%s]", lnk.source.cfg.Name, lnk.sourceColumn, cfg.FormatPath(lnk.sourcePath),
+			lnk.target.cfg.Name, lnk.targetColumn, cfg.FormatPath(lnk.targetPath))
+		v.checkMapped(description, lnk.source, lnk.sourceColumn, lnk.sourcePath, lnk.target, lnk.targetColumn, lnk.targetPath)
+
+		for _, column := range cfg.FilterColumns(lnk.sourceFilter) {
+			v.checkColumn(lnk.source, column, "filter")
+		}
+		for _, column := range cfg.FilterColumns(lnk.targetFilter) {
+			v.checkColumn(lnk.target, column, "filter")
+		}
+
+		sourceMatch, targetMatch := v.findColumn(lnk.source, lnk.sourceExID), v.findColumn(lnk.target, lnk.targetExID)
+		if sourceMatch != nil && targetMatch != nil && !kindsComparable(sourceMatch.Kind, targetMatch.Kind) {
+			v.report(true, fmt.Sprintf("%s: match columns %s.%s (%s) and %s.%s (%s) can't have equal values, so no records would be paired",
+				description, lnk.source.cfg.Name, lnk.sourceExID, sourceMatch.Kind, lnk.target.cfg.Name, lnk.targetExID, targetMatch.Kind))
+		}
+		// Upserts are keyed by the target's match column, which PostgreSQL only allows with a unique index.
+		// Sampled schemas don't know about indexes, but MongoDB upserts don't need one.
+		if upsert && targetMatch != nil && !v.schemas[lnk.target.tbl.id].Sampled && !targetMatch.Unique {
+			v.report(true, fmt.Sprintf("%s: UPSERT needs a unique index or constraint on match column %s.%s of table %s",
+				description, lnk.target.cfg.Name, lnk.targetExID, lnk.target.tbl.id))
+		}
+	}
+}
+
+// checkMapped checks that values of the source column can be written to the target column.
+// Kinds of values nested in documents aren't known, so they aren't compared.
+// Children are replaced as a whole, so they can't be written to nested paths.
+func (v *schemaValidator) checkMapped(description string, source *node, sourceColumn string, sourcePath []cfg.PathSegment, target *node, targetColumn string, targetPath []cfg.PathSegment) {
+	if target.isChild(targetColumn) && len(targetPath) > 0 {
+		v.report(true, fmt.Sprintf("%s: children can't be written partially, use the whole \"%s\" array", description, targetColumn))
+	}
+	sourceCol := v.checkColumn(source, sourceColumn, "source")
+	targetCol := v.checkColumn(target, targetColumn, "target")
+	if sourceCol == nil || targetCol == nil || len(sourcePath) > 0 || len(targetPath) > 0 || kindsCompatible(sourceCol.Kind, targetCol.Kind) {
+		return
+	}
+
+	schema := v.schemas[target.tbl.id]
+	v.report(!schema.Sampled, fmt.Sprintf("%s: values of %s.%s (%s) can't be written to %s.%s (%s)",
+		description, source.cfg.Name, sourceColumn, sourceCol.Type, target.cfg.Name, targetColumn, targetCol.Type))
+}
+
+// checkColumn reports a column missing in the node's table.
+// Columns of empty sampled tables aren't known, so they aren't checked.
+func (v *schemaValidator) checkColumn(n *node, column string, role string) *db.Column {
+	if n.isChild(column) {
+		return n.getColumn(column)
+	}
+	schema := v.schemas[n.tbl.id]
+	if col := schema.GetColumn(column); col != nil || (schema.Sampled && len(schema.Columns) == 0) {
+		return col
+	}
+
+	v.report(!schema.Sampled, fmt.Sprintf("%s column \"%s\" of node \"%s\" doesn't exist in table %s", role, column, n.cfg.Name, n.tbl.id))
+	return nil
+}
+
+func (v *schemaValidator) findColumn(n *node, column string) *db.Column {
+	if column == "" {
+		return nil
+	}
+	if n.isChild(column) {
+		return n.getColumn(column)
+	}
+	return v.schemas[n.tbl.id].GetColumn(column)
+}
+
+// checkChild reports columns of a child's config missing in the child table.
+func (v *schemaValidator) checkChild(n *node, child *cfg.ChildConfig) {
+	v.checkChildColumn(n, child, child.ParentKey, "parent key")
+	v.checkChildColumn(n, child, child.Key, "key")
+	for _, column := range child.Columns {
+		v.checkChildColumn(n, child, column, "embedded")
+	}
+}
+
+func (v *schemaValidator) checkChildColumn(n *node, child *cfg.ChildConfig, column string, role string) {
+	schema := n.childSchemas[child.Name]
+	if schema == nil || schema.GetColumn(column) != nil || (schema.Sampled && len(schema.Columns) == 0) {
+		return
+	}
+	v.report(!schema.Sampled, fmt.Sprintf("%s column \"%s\" of child \"%s\" of node \"%s\" doesn't exist in table %s",
+		role, column, child.Name, n.cfg.Name, child.Table))
+}
+
+// report adds a problem or, if it's uncertain, a warning. Repeated messages are skipped.
+func (v *schemaValidator) report(certain bool, message string) {
+	if v.reported[message] {
+		return
+	}
+	v.reported[message] = true
+
+	if certain {
+		v.problems = append(v.problems, message)
+	} else {
+		v.warnings = append(v.warnings, message)
+	}
+}
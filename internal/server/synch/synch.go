@@ -4,7 +4,7 @@ Package synch handles all data sychronization.
 package synch
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -13,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 )
@@ -25,23 +26,31 @@ type Synch struct {
 	dbStore          *dbStore
 	mappings         []*Mapping
 	Links            []*Link
+	values           *valueConverter
+	masks            map[string]*mask
+	deadLetters      *DeadLetters
+	limiter          *db.RateLimiter
 	counters         *counters
 	stype            synchType
-	running          bool
-	initial          bool
 	simulation       bool
 	currentIteration *iteration
 	result           *Result
+	// stateMux guards the state flags, which the run's loop
+	// and the request handlers stopping it share.
+	stateMux    sync.RWMutex
+	running     bool
+	initial     bool
+	interrupted bool
 }
 
 // Init prepares the synchronization by fetching all necessary data
-// and parsing it.
-func (s *Synch) Init(DBMap map[string]*db.Database, stype string) string {
+// and parsing it. Describing the tables gets cancelled along with the context.
+func (s *Synch) Init(ctx context.Context, DBMap map[string]*db.Database, stype string) (string, error) {
 	tStart := time.Now()
 	s.id = s.getNewSynchID()
 	stypeField, err := FindSynchType(stype)
 	if err != nil {
-		panic(err)
+		return "", apperr.Wrap(apperr.REQUEST, "synch init", err)
 	}
 	s.stype = stypeField
 	s.dbStore = &dbStore{}
@@ -49,14 +58,32 @@ func (s *Synch) Init(DBMap map[string]*db.Database, stype string) string {
 
 	if s.counters == nil {
 		s.counters = newCounters()
-		s.dbStore.Init(DBMap, s.cfg.Nodes)
-		s.parseCfgLinks()
-		s.parseCfgMappings()
-		s.parseCfgMatcher()
+		s.limiter = db.NewRateLimiter(s.cfg.Name, s.cfg.RateLimit)
+		if err := s.dbStore.Init(DBMap, s.cfg.Nodes); err != nil {
+			return "", err
+		}
+		if err := s.parseCfgLinks(); err != nil {
+			return "", err
+		}
+		if err := s.parseCfgMappings(); err != nil {
+			return "", err
+		}
+		if err := s.parseCfgMatcher(); err != nil {
+			return "", err
+		}
+		if err := s.parseCfgCompare(); err != nil {
+			return "", err
+		}
+		if err := s.parseCfgMasks(); err != nil {
+			return "", err
+		}
+		if err := s.validateSchemas(ctx); err != nil {
+			return "", err
+		}
 	}
 
 	fmt.Println("Synch init finished in: ", time.Since(tStart).String())
-	return s.id
+	return s.id, nil
 }
 
 func (s *Synch) getNewSynchID() string {
@@ -79,12 +106,35 @@ func (s *Synch) GetNodes() map[string]*node {
 	return s.dbStore.nodes
 }
 
-func (s *Synch) GetRawMappings() []map[string]string {
-	rawMappings := make([]map[string]string, len(s.mappings))
-	for i, mapping := range s.mappings {
-		rawMappings[i] = mapping.raw
-	}
-	return rawMappings
+// GetMappings returns the synch's parsed mappings.
+func (s *Synch) GetMappings() []*Mapping {
+	return s.mappings
+}
+
+// GetValueConverter returns the converter, which compares
+// and converts values according to the synch's compare rules.
+func (s *Synch) GetValueConverter() *valueConverter {
+	return s.values
+}
+
+// GetMask returns the mask of a node's column, or nil if the column isn't masked.
+func (s *Synch) GetMask(nodeName string, column string, path []cfg.PathSegment) *mask {
+	return s.masks[maskKey(nodeName, column, path)]
+}
+
+// GetDeadLetters returns the store of writes, which failed permanently, or nil if they aren't stored.
+func (s *Synch) GetDeadLetters() *DeadLetters {
+	return s.deadLetters
+}
+
+// GetCounters returns the counters of the synch's current run.
+func (s *Synch) GetCounters() *counters {
+	return s.counters
+}
+
+// GetRateLimiter returns the limiter throttling the synch's writes, or nil if they aren't throttled.
+func (s *Synch) GetRateLimiter() *db.RateLimiter {
+	return s.limiter
 }
 
 // GetType returns the type of the synch.
@@ -93,146 +143,246 @@ func (s *Synch) GetType() synchType {
 }
 
 func (s *Synch) IsInitial() bool {
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
 	return s.initial
 }
 
 // SetInitial sets the initial struct field indicating whether
 // it's the first run of the synch.
 func (s *Synch) SetInitial(ini bool) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
 	s.initial = ini
 }
 
 func (s *Synch) IsRunning() bool {
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
 	return s.running
 }
 
-func (s *Synch) IsSimulation() bool {
-	return s.simulation
+func (s *Synch) setRunning(running bool) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
+	s.running = running
 }
 
-func (s *Synch) SetSimulation(sim bool) {
-	s.simulation = sim
+func (s *Synch) isInterrupted() bool {
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
+	return s.interrupted
 }
 
-// pairData pairs together records that are going to be synchronized.
-func (s *Synch) pairData() {
-	var wg sync.WaitGroup
+func (s *Synch) setInterrupted(interrupted bool) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
+	s.interrupted = interrupted
+}
 
-	for i := range s.Links {
-		var lnk *Link = s.Links[i]
+func (s *Synch) IsSimulation() bool {
+	return s.simulation
+}
 
-		wg.Add(1)
-		go lnk.createPairs(&wg)
-		wg.Wait()
-	}
+func (s *Synch) SetSimulation(sim bool) {
+	s.simulation = sim
 }
 
-func (s *Synch) parseCfgLinks() {
-	var ch chan bool
-	ch = make(chan bool)
+func (s *Synch) parseCfgLinks() error {
+	var ch chan error
+	ch = make(chan error)
 
+	// Every goroutine sets its own element, so the links keep the config's order.
+	s.Links = make([]*Link, len(s.cfg.Link))
 	for i, mapping := range s.cfg.Link {
 		go s.parseLink(mapping, i, ch)
 	}
 
+	var firstErr error
 	for i := 0; i < len(s.cfg.Link); i++ {
-		<-ch
+		if err := <-ch; err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
 }
 
-func (s *Synch) parseLink(mpngStr string, i int, c chan bool) {
-	rawLink, err := cfg.ParseLink(mpngStr)
+func (s *Synch) parseLink(mpngStr string, i int, c chan error) {
+	parsedLink, err := cfg.ParseLink(mpngStr)
 	if err != nil {
-		panic(err)
+		c <- err
+		return
 	}
 
-	in := createLink(s, rawLink)
-	s.Links = append(s.Links, in)
+	in, err := createLink(s, parsedLink)
+	if err != nil {
+		c <- err
+		return
+	}
+	s.Links[i] = in
 
-	c <- true
+	c <- nil
 }
 
-func (s *Synch) parseCfgMappings() {
-	var ch chan bool
-	ch = make(chan bool)
+func (s *Synch) parseCfgMappings() error {
+	var ch chan error
+	ch = make(chan error)
 
+	s.mappings = make([]*Mapping, len(s.cfg.Map))
 	for i, mapping := range s.cfg.Map {
 		go s.parseMapping(mapping, i, ch)
 	}
 
+	var firstErr error
 	for i := 0; i < len(s.cfg.Map); i++ {
-		<-ch
+		if err := <-ch; err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
 }
 
-func (s *Synch) parseMapping(mpngStr string, i int, c chan bool) {
-	rawMpng, err := cfg.ParseMapping(mpngStr)
+func (s *Synch) parseMapping(mpngStr string, i int, c chan error) {
+	parsedMpng, err := cfg.ParseMapping(mpngStr)
 	if err != nil {
-		panic(err)
+		c <- err
+		return
 	}
 
-	mpng := createMapping(s, rawMpng)
-	s.mappings = append(s.mappings, mpng)
+	mpng, err := createMapping(s, parsedMpng)
+	if err != nil {
+		c <- err
+		return
+	}
+	s.mappings[i] = mpng
 
-	c <- true
+	c <- nil
 }
 
-func (s *Synch) parseCfgMatcher() {
+func (s *Synch) parseCfgMatcher() error {
 	matcherMethod := s.GetConfig().Match.Method
 
 	switch matcherMethod {
 	case "ids":
 		parsedMatcher, err := cfg.ParseIdsMatcherMethod(s.GetConfig().Match.Args)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		for _, arg := range parsedMatcher {
-			node, found := s.dbStore.nodes[arg[0]]
+			node, found := s.dbStore.nodes[arg.Node]
 			if !found {
-				panic(errors.New("node name not found"))
+				return &synchInitError{method: "parseCfgMatcher", errMsg: "node \"" + arg.Node + "\" not found"}
 			}
 
-			node.setMatchColumn(arg[1])
+			node.setMatchColumn(arg.Column)
 		}
 	default:
-		panic(errors.New("unknown match method"))
+		return &synchInitError{method: "parseCfgMatcher", errMsg: "unknown match method \"" + matcherMethod + "\""}
 	}
+	return nil
 }
 
-// selectData selects all records from all tables and filters them to get the relevant records.
-func (s *Synch) selectData() {
-	for i := range s.Links {
-		var lnk *Link = s.Links[i]
+// parseCfgCompare creates the converter used to compare and write values of linked columns.
+func (s *Synch) parseCfgCompare() error {
+	values, err := newValueConverter(s.cfg.Compare)
+	if err != nil {
+		return &synchInitError{method: "parseCfgCompare", errMsg: err.Error()}
+	}
+	s.values = values
+	return nil
+}
 
-		sourceRawActiveRecords := (*lnk.source.db).Select(lnk.source.tbl.name, lnk.sourceWhere)
-		targetRawActiveRecords := (*lnk.target.db).Select(lnk.target.tbl.name, lnk.targetWhere)
+// parseCfgMasks looks up the nodes of masked columns. Match columns can't be masked,
+// because the target's records are paired with the source's by their unmasked values.
+func (s *Synch) parseCfgMasks() error {
+	s.masks = make(map[string]*mask, len(s.cfg.Mask))
+	for i := range s.cfg.Mask {
+		maskCfg := &s.cfg.Mask[i]
+		ref, err := cfg.ParseColumnRef(maskCfg.Column)
+		if err != nil {
+			return &synchInitError{method: "parseCfgMasks", errMsg: err.Error()}
+		}
+		node, found := s.dbStore.nodes[ref.Node]
+		if !found {
+			return &synchInitError{method: "parseCfgMasks", errMsg: "node \"" + ref.Node + "\" not found"}
+		}
+		if len(ref.Path) == 0 && ref.Column == node.matchColumn {
+			return &synchInitError{method: "parseCfgMasks", errMsg: fmt.Sprintf("match column %s can't be masked", ref)}
+		}
+		m, err := newMask(maskCfg)
+		if err != nil {
+			return &synchInitError{method: "parseCfgMasks", errMsg: fmt.Sprintf("column %s: %s", ref, err)}
+		}
+		s.masks[maskKey(ref.Node, ref.Column, ref.Path)] = m
+	}
+	return nil
+}
 
-		// if !s.initial {
-		// 	lnk.sourceOldActiveRecords = lnk.sourceActiveRecords
-		// 	lnk.targetOldActiveRecords = lnk.targetActiveRecords
-		// }
+// Run executes a single run of the synchronization.
+// Links are read and their pairs synchronized by a pool of workers, the number of which
+// is configured per synch. A failing link doesn't stop the other ones, the first error
+// is returned once all of them have finished.
+// When the context gets cancelled, the current pairs are finished and
+// the operations carried out so far are kept for the report.
+func (s *Synch) Run(ctx context.Context) error {
+	s.setRunning(true)
 
-		lnk.sourceTable.setActiveRecords(sourceRawActiveRecords)
-		lnk.targetTable.setActiveRecords(targetRawActiveRecords)
+	s.resetIteration()
+	defer s.finishIteration()
+	defer s.resetLinks()
 
-		lnk.sourceTable.activeRecords.setActiveIn(lnk)
-		lnk.targetTable.activeRecords.setActiveIn(lnk)
+	pool := newPairPool(ctx, s.cfg.Concurrency, s.Links)
+	linkSlots := make(chan struct{}, s.cfg.Concurrency.GetWorkers())
+	linkErrs := make(chan error, len(s.Links))
+	for i := range s.Links {
+		go func(lnk *Link) {
+			select {
+			case linkSlots <- struct{}{}:
+			case <-ctx.Done():
+				linkErrs <- ctx.Err()
+				return
+			}
+			linkErrs <- s.synchronizeLink(ctx, lnk, pool)
+			<-linkSlots
+		}(s.Links[i])
 	}
 
+	var err error
+	for range s.Links {
+		if linkErr := <-linkErrs; linkErr != nil && err == nil {
+			err = linkErr
+		}
+	}
+	pool.close()
 	s.counters.selects++
+
+	if ctx.Err() != nil {
+		s.setInterrupted(true)
+		return ctx.Err()
+	}
+	return err
 }
 
-// Run executes a single run of the synchronization.
-func (s *Synch) Run() {
-	s.running = true
+// synchronizeLink selects the link's records, pairs them and hands the pairs over to the pool
+// one page of source records at a time, so that whole tables are never kept in memory.
+func (s *Synch) synchronizeLink(ctx context.Context, lnk *Link, pool *pairPool) error {
+	if err := lnk.open(ctx); err != nil {
+		return err
+	}
 
-	s.resetIteration()
-	s.selectData()
-	s.pairData()
-	s.synchronize()
-	s.resetLinks()
-	s.finishIteration()
+	for {
+		pairs, err := lnk.createPairs(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return nil
+		}
+		if err := s.synchronize(ctx, pool, pairs); err != nil {
+			return err
+		}
+	}
 }
 
 func (s *Synch) resetIteration() {
@@ -245,22 +395,17 @@ func (s *Synch) finishIteration() {
 
 // Stop stops the synch.
 func (s *Synch) Stop() {
-	s.running = false
+	s.setRunning(false)
 }
 
-// synchronize loops over all pairs in all mappings and invokes their synchronize function.
-func (s *Synch) synchronize() {
-	for i := range s.Links {
-		var lnk *Link = s.Links[i]
-
-		for k := range lnk.pairs {
-			var pair *Pair = lnk.pairs[k]
-			_, err := pair.Synchronize()
-			if err != nil {
-				log.Println(err)
-			}
+// synchronize submits the pairs to the pool's workers.
+func (s *Synch) synchronize(ctx context.Context, pool *pairPool, pairs []*Pair) error {
+	for _, pair := range pairs {
+		if err := pool.submit(ctx, pair); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 func (s *Synch) resetLinks() {
@@ -269,18 +414,26 @@ func (s *Synch) resetLinks() {
 	}
 }
 
+// Flush completes the run's result with its counters and saves the report,
+// which starts with the counters followed by the operations.
 func (s *Synch) Flush() *Result {
-	operationsToJSON := s.result.operationsToJSONSlice()
-	operationsToJSONString := strings.Join(operationsToJSON, "\n")
+	s.result.Counters = s.counters.snapshot()
+	report := append([]string{s.result.CountersToJSON()}, s.result.operationsToJSONSlice()...)
+	reportString := strings.Join(report, "\n")
 	if s.IsSimulation() {
 		s.result.setSimulationPath(s.id)
 	} else {
 		s.result.setLogPath(s.id)
 	}
 	if s.stype == ONE_OFF {
-		if len(s.result.Operations) == 0 {
+		if len(s.result.Operations) == 0 && s.isInterrupted() {
+			s.result.Message = fmt.Sprintf("Synchronization \"%s\" has been interrupted. No database operations have been carried out.", s.cfg.Name)
+			return s.result
+		} else if len(s.result.Operations) == 0 {
 			s.result.Message = fmt.Sprintf("There are no database operations to be carried out.")
 			return s.result
+		} else if s.isInterrupted() {
+			s.result.Message = fmt.Sprintf("Synchronization \"%s\" has been interrupted. Partial report saved to file: %s", s.cfg.Name, s.result.path)
 		} else if s.IsSimulation() {
 			s.result.Message = fmt.Sprintf("Simulation report saved to file: %s", s.result.path)
 		} else {
@@ -293,9 +446,10 @@ func (s *Synch) Flush() *Result {
 		}
 		s.result.Message = fmt.Sprintf("Synchronization \"%s\" stopped. Ongoing synchronization report saved to file: %s", s.cfg.Name, s.result.path)
 	}
-	err := ioutil.WriteFile(s.result.path, []byte(operationsToJSONString), 0644)
+	err := ioutil.WriteFile(s.result.path, []byte(reportString), 0644)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		s.result.Message = fmt.Sprintf("%s Report couldn't be saved: %s", s.result.Message, err.Error())
 	}
 	return s.result
 }
@@ -303,6 +457,7 @@ func (s *Synch) Flush() *Result {
 // Reset clears data preparing the Synch for the next run.
 func (s *Synch) Reset() {
 	s.stype = 0
+	s.setInterrupted(false)
 	s.SetInitial(false)
 	for _, lnk := range s.Links {
 		lnk.reset()
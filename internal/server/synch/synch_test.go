@@ -1,13 +1,29 @@
 package synch
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
 )
 
-var synchs Synchs
+var synchs *Synchs
 
 func TestYAML(t *testing.T) {
 	os.Chdir("../../..")
@@ -28,3 +44,1170 @@ func TestDir(t *testing.T) {
 	// 	fmt.Println(f.Name())
 	// }
 }
+
+// sliceIterator returns the given pages of records.
+type sliceIterator struct {
+	pages [][]map[string]interface{}
+}
+
+func (it *sliceIterator) Next(ctx context.Context) ([]map[string]interface{}, error) {
+	if len(it.pages) == 0 {
+		return nil, nil
+	}
+	page := it.pages[0]
+	it.pages = it.pages[1:]
+	return page, nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+func TestCompareKeys(t *testing.T) {
+	cases := []struct {
+		key1     interface{}
+		key2     interface{}
+		expected int
+	}{
+		{nil, int64(1), -1},
+		{int64(2), 2.0, 0},
+		{int32(10), []byte("9.5"), 1},
+		{"10", "9", -1},
+		{"Zebra", "apple", -1},
+		{int64(100), "1", -1},
+		{primitive.ObjectID{1}, primitive.ObjectID{2}, -1},
+		{[]byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", 0},
+	}
+	for _, c := range cases {
+		cmp, err := compareKeys(c.key1, c.key2)
+		if err != nil {
+			t.Error(err)
+		} else if cmp != c.expected {
+			t.Errorf("compareKeys(%v, %v): expected %d, got %d", c.key1, c.key2, c.expected, cmp)
+		}
+	}
+	if _, err := compareKeys(map[string]interface{}{}, int64(1)); err == nil {
+		t.Error("expected documents to be incomparable")
+	}
+}
+
+func TestFindTargets(t *testing.T) {
+	targetNode := &node{cfg: &cfg.NodeConfig{Key: "_id"}, tbl: &table{name: "films"}}
+	targets := &sliceIterator{pages: [][]map[string]interface{}{
+		{{"ext_id": nil}, {"ext_id": int32(1)}, {"ext_id": int32(3)}},
+		{{"ext_id": int32(3)}, {"ext_id": int32(5)}},
+	}}
+	lnk := &Link{
+		sourceExID:    "film_id",
+		targetExID:    "ext_id",
+		target:        targetNode,
+		targetRecords: newRecordStream(targetNode, targets, "ext_id"),
+	}
+
+	expectedCounts := map[int64]int{1: 1, 2: 0, 3: 2, 4: 0, 5: 1, 6: 0}
+	for _, key := range []int64{1, 2, 3, 3, 4, 5, 6} {
+		found, err := lnk.findTargets(context.Background(), &record{Data: map[string]interface{}{"film_id": key}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != expectedCounts[key] {
+			t.Errorf("key %d: expected %d targets, got %d", key, expectedCounts[key], len(found))
+		}
+	}
+
+	unsorted := &sliceIterator{pages: [][]map[string]interface{}{{{"ext_id": int32(2)}}, {{"ext_id": int32(1)}}}}
+	lnk.targetRecords = newRecordStream(targetNode, unsorted, "ext_id")
+	lnk.targetGroupKey = nil
+	if _, err := lnk.findTargets(context.Background(), &record{Data: map[string]interface{}{"film_id": int64(5)}}); err == nil {
+		t.Error("expected unsorted records to be rejected")
+	}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	films := &node{cfg: &cfg.NodeConfig{Name: "films", Key: "film_id"}, tbl: &table{id: "dvdrental.film", name: "film"}, matchColumn: "film_id"}
+	docs := &node{cfg: &cfg.NodeConfig{Name: "docs", Key: "_id"}, tbl: &table{id: "msamp.films", name: "films"}, matchColumn: "ext_id"}
+	s := &Synch{
+		cfg:     &cfg.SynchConfig{Name: "test", Nodes: []cfg.NodeConfig{*films.cfg, *docs.cfg}},
+		dbStore: &dbStore{nodes: map[string]*node{"films": films, "docs": docs}},
+	}
+	s.mappings = []*Mapping{
+		{source: films, target: docs, sourceColumn: "length", targetColumn: "Length"},
+		{source: films, target: docs, sourceColumn: "titel", targetColumn: "Title"},
+		{source: docs, target: films, sourceColumn: "Title", targetColumn: "length"},
+	}
+	s.Links = []*Link{{
+		source:       films,
+		target:       docs,
+		sourceColumn: "rating",
+		targetColumn: "Rating",
+		sourceFilter: &cfg.Comparison{Column: "lenght", Operator: ">", Value: int64(60)},
+		sourceExID:   "film_id",
+		targetExID:   "ext_id",
+	}}
+
+	schemas := map[string]*db.TableSchema{
+		"dvdrental.film": {Name: "film", Columns: []db.Column{
+			{Name: "film_id", Type: "integer", Kind: db.KIND_INTEGER},
+			{Name: "title", Type: "text", Kind: db.KIND_STRING},
+			{Name: "length", Type: "smallint", Kind: db.KIND_INTEGER},
+			{Name: "rating", Type: "USER-DEFINED", Kind: db.KIND_UNKNOWN},
+		}},
+		"msamp.films": {Name: "films", Sampled: true, Columns: []db.Column{
+			{Name: "_id", Type: "objectID", Kind: db.KIND_OBJECT_ID},
+			{Name: "ext_id", Type: "string", Kind: db.KIND_STRING},
+			{Name: "Title", Type: "string", Kind: db.KIND_STRING},
+			{Name: "Length", Type: "32-bit integer|double", Kind: db.KIND_DECIMAL},
+		}},
+	}
+	v := newSchemaValidator(schemas)
+	v.validate(s)
+
+	expectedProblems := []string{
+		`source column "titel" of node "films" doesn't exist in table dvdrental.film`,
+		`mapping docs.Title TO films.length: values of docs.Title (string) can't be written to films.length (smallint)`,
+		`filter column "lenght" of node "films" doesn't exist in table dvdrental.film`,
+		`link [films.rating] TO [docs.Rating]: match columns films.film_id (integer) and docs.ext_id (string) can't have equal values, so no records would be paired`,
+	}
+	if strings.Join(v.problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s", strings.Join(expectedProblems, "\n"), strings.Join(v.problems, "\n"))
+	}
+	if len(v.warnings) != 1 || !strings.Contains(v.warnings[0], `target column "Rating"`) {
+		t.Errorf("expected a warning about the missing Rating field, got %v", v.warnings)
+	}
+
+	// Upserts to PostgreSQL need a unique index on the match column, MongoDB's sampled schemas aren't checked.
+	s.cfg.Do = []string{cfg.DB_UPSERT}
+	s.mappings = nil
+	s.Links = []*Link{
+		{source: docs, target: films, sourceColumn: "Title", targetColumn: "title", sourceExID: "Length", targetExID: "film_id"},
+		{source: films, target: docs, sourceColumn: "title", targetColumn: "Title", sourceExID: "title", targetExID: "ext_id"},
+	}
+	uniqueProblem := `link [docs.Title] TO [films.title]: UPSERT needs a unique index or constraint on match column films.film_id of table dvdrental.film`
+	v = newSchemaValidator(schemas)
+	v.validate(s)
+	if strings.Join(v.problems, "\n") != uniqueProblem {
+		t.Errorf("expected problem:\n%s\ngot:\n%s", uniqueProblem, strings.Join(v.problems, "\n"))
+	}
+	schemas["dvdrental.film"].Columns[0].Unique = true
+	v = newSchemaValidator(schemas)
+	v.validate(s)
+	if len(v.problems) != 0 {
+		t.Errorf("expected a unique match column to be upserted to, got %v", v.problems)
+	}
+}
+
+func TestScaffold(t *testing.T) {
+	source := ScaffoldTable{Database: "dvdrental", Table: "public.film"}
+	target := ScaffoldTable{Database: "msamp", Table: "films"}
+	sourceSchema := &db.TableSchema{Name: "public.film", Columns: []db.Column{
+		{Name: "film_id", Type: "integer", Kind: db.KIND_INTEGER, PrimaryKey: true},
+		{Name: "title", Type: "text", Kind: db.KIND_STRING},
+		{Name: "description", Type: "text", Kind: db.KIND_STRING},
+		{Name: "release_year", Type: "integer", Kind: db.KIND_INTEGER},
+		{Name: "last_update", Type: "timestamp without time zone", Kind: db.KIND_TIME},
+	}}
+	targetSchema := &db.TableSchema{Name: "films", Sampled: true, Columns: []db.Column{
+		{Name: "_id", Type: "objectID", Kind: db.KIND_OBJECT_ID, PrimaryKey: true},
+		{Name: "film_id", Type: "32-bit integer", Kind: db.KIND_INTEGER},
+		{Name: "Title", Type: "string", Kind: db.KIND_STRING},
+		{Name: "descriptions", Type: "string", Kind: db.KIND_STRING},
+		{Name: "releaseYear", Type: "string", Kind: db.KIND_STRING},
+		{Name: "notes", Type: "string", Kind: db.KIND_STRING},
+	}}
+
+	draft := draftConfig("film_to_films", source, sourceSchema, target, targetSchema)
+	expectedMap := []string{
+		"dvdrental_film.film_id TO msamp_films.film_id",
+		"dvdrental_film.title TO msamp_films.Title",
+		"dvdrental_film.description TO msamp_films.descriptions",
+		"dvdrental_film.release_year TO msamp_films.releaseYear",
+	}
+	if strings.Join(draft.Config.Map, "\n") != strings.Join(expectedMap, "\n") {
+		t.Errorf("expected mappings:\n%s\ngot:\n%s", strings.Join(expectedMap, "\n"), strings.Join(draft.Config.Map, "\n"))
+	}
+	if len(draft.Config.Link) != 3 || draft.Config.Link[0] != "[dvdrental_film.title] TO [msamp_films.Title]" {
+		t.Errorf("expected links of the 3 mapped non key columns, got %v", draft.Config.Link)
+	}
+	if strings.Join(draft.Config.Match.Args, " ") != "dvdrental_film.film_id msamp_films.film_id" || draft.matchMissing {
+		t.Errorf("expected records to be matched by film_id, got %v", draft.Config.Match.Args)
+	}
+	if draft.Config.Nodes[0].Key != "film_id" || draft.Config.Nodes[1].Key != "_id" {
+		t.Errorf("expected primary keys to be used as node keys, got %s and %s", draft.Config.Nodes[0].Key, draft.Config.Nodes[1].Key)
+	}
+
+	content, err := draft.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# Draft scaffolded from dvdrental.public.film and msamp.films.",
+		"# Source columns without a similar target column: last_update",
+		"# Target columns without a mapping: notes",
+		"'dvdrental_film.description TO msamp_films.descriptions' # similarity 0.92",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("expected the draft to contain %q, got:\n%s", expected, content)
+		}
+	}
+	var parsed cfg.SynchConfig
+	if err := yaml.Unmarshal(content, &parsed); err != nil || strings.Join(parsed.Map, "\n") != strings.Join(expectedMap, "\n") {
+		t.Errorf("expected the draft to be a valid synch config, got: %v", err)
+	}
+
+	// Without a column holding the source's key, a placeholder has to be filled in.
+	targetSchema.Columns = append(targetSchema.Columns[:1], targetSchema.Columns[2:]...)
+	draft = draftConfig("film_to_films", source, sourceSchema, target, targetSchema)
+	if !draft.matchMissing || draft.Config.Match.Args[1] != "msamp_films."+SCAFFOLD_MATCH_COLUMN {
+		t.Errorf("expected a placeholder match column, got %v", draft.Config.Match.Args)
+	}
+}
+
+func TestValueConverter(t *testing.T) {
+	values, err := newValueConverter(cfg.Compare{FloatTolerance: 0.001, TimeZone: "Europe/Warsaw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numeric := &db.Column{Name: "price", Type: "numeric", Kind: db.KIND_DECIMAL}
+	timestamp := &db.Column{Name: "updated", Type: "timestamp without time zone", Kind: db.KIND_TIME}
+	uuidColumn := &db.Column{Name: "uuid", Type: "uuid", Kind: db.KIND_UUID}
+	jsonb := &db.Column{Name: "data", Type: "jsonb", Kind: db.KIND_DOCUMENT}
+	intArray := &db.Column{Name: "ids", Type: "ARRAY", Kind: db.KIND_ARRAY, ElementKind: db.KIND_INTEGER}
+	textArray := &db.Column{Name: "tags", Type: "ARRAY", Kind: db.KIND_ARRAY, ElementKind: db.KIND_STRING}
+
+	id := primitive.NewObjectID()
+	decimal, _ := primitive.ParseDecimal128("12.50")
+	warsaw, _ := time.LoadLocation("Europe/Warsaw")
+	instant := time.Date(2020, 6, 1, 10, 0, 0, 0, warsaw)
+	uuidBytes, _ := hex.DecodeString("a0eebc999c0b4ef8bb6d6bb9bd380a11")
+
+	tests := []struct {
+		source    interface{}
+		sourceCol *db.Column
+		target    interface{}
+		targetCol *db.Column
+		expected  bool
+	}{
+		{[]byte("12.5"), numeric, decimal, nil, true},
+		{[]byte("12.5"), numeric, 12.5004, nil, true},
+		{[]byte("12.5"), numeric, 12.6, nil, false},
+		{[]byte("0.1"), numeric, 0.1, nil, true},
+		{int64(3), nil, int32(3), nil, true},
+		{uint8(3), nil, 3.0, nil, true},
+		{math.NaN(), nil, []byte("NaN"), numeric, true},
+		{nil, nil, primitive.Null{}, nil, true},
+		{nil, nil, "", nil, false},
+		{true, nil, []byte("t"), &db.Column{Kind: db.KIND_BOOL}, true},
+		{id.Hex(), nil, id, nil, true},
+		{time.Date(2020, 6, 1, 10, 0, 0, 123456000, time.UTC), timestamp, primitive.NewDateTimeFromTime(instant.Add(123 * time.Millisecond)), nil, true},
+		{instant, nil, instant.Add(time.Second), nil, false},
+		{[]byte("A0EEBC99-9C0B-4EF8-BB6D-6BB9BD380A11"), uuidColumn, primitive.Binary{Subtype: 4, Data: uuidBytes}, nil, true},
+		{[]byte(`{"a": 1, "b": [true, null]}`), jsonb, map[string]interface{}{"a": int32(1), "b": primitive.A{true, nil}}, nil, true},
+		{[]byte(`{"a": 1}`), jsonb, primitive.D{{Key: "a", Value: "1"}}, nil, false},
+		{[]byte("{1,2,NULL}"), intArray, primitive.A{int64(1), 2.0, nil}, nil, true},
+		{[]byte(`{"a,b","c \"d\"",{e}}`), textArray, primitive.A{"a,b", `c "d"`, primitive.A{"e"}}, nil, true},
+	}
+	for i, test := range tests {
+		equal, err := values.equal(test.source, test.sourceCol, test.target, test.targetCol)
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+		} else if equal != test.expected {
+			t.Errorf("test %d: expected %v == %v to be %v", i, test.source, test.target, test.expected)
+		}
+	}
+
+	if _, err := values.equal(primitive.Regex{Pattern: "a"}, nil, "a", nil); err == nil {
+		t.Error("expected an error comparing a regular expression")
+	}
+
+	converted, err := values.convert([]byte("12.50"), numeric, &db.Column{Type: "decimal", Kind: db.KIND_DECIMAL}, "mongo")
+	if _, isDecimal := converted.(primitive.Decimal128); err != nil || !isDecimal {
+		t.Errorf("expected a numeric to be written to MongoDB as a Decimal128, got %T", converted)
+	}
+	converted, _ = values.convert([]byte("{1,2}"), intArray, nil, "mongo")
+	if array, isArray := converted.(primitive.A); !isArray || len(array) != 2 || array[1] != int64(2) {
+		t.Errorf("expected an integer array to be written to MongoDB as an array of integers, got %#v", converted)
+	}
+	converted, _ = values.convert(primitive.A{"a b", nil, int32(1)}, nil, textArray, "postgres")
+	if converted != `{"a b",NULL,1}` {
+		t.Errorf("expected an array literal, got %v", converted)
+	}
+	converted, _ = values.convert(primitive.M{"a": decimal}, nil, jsonb, "postgres")
+	if converted != `{"a":12.5}` {
+		t.Errorf("expected a JSON document, got %v", converted)
+	}
+	converted, _ = values.convert(primitive.NewDateTimeFromTime(instant), nil, timestamp, "postgres")
+	if convertedTime, isTime := converted.(time.Time); !isTime || convertedTime.Hour() != 10 {
+		t.Errorf("expected a time in the configured zone, got %v", converted)
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	ref, err := cfg.ParseColumnRef("docs.meta.actors[*].name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := map[string]interface{}{
+		"meta": map[string]interface{}{
+			"rating": "PG",
+			"actors": primitive.A{
+				map[string]interface{}{"name": "Penelope"},
+				map[string]interface{}{"age": int32(30)},
+			},
+		},
+	}
+
+	names := getPathValue(document[ref.Column], ref.Path)
+	if values, isSlice := names.([]interface{}); !isSlice || len(values) != 2 || values[0] != "Penelope" || values[1] != nil {
+		t.Errorf("expected the actors' names, got %v", names)
+	}
+	if rating := getPathValue(document["meta"], []cfg.PathSegment{{Field: "rating"}}); rating != "PG" {
+		t.Errorf("expected the rating, got %v", rating)
+	}
+	if missing := getPathValue(document["meta"], []cfg.PathSegment{{Field: "rating"}, {IsIndex: true, Index: 0}}); missing != nil {
+		t.Errorf("expected a missing value, got %v", missing)
+	}
+
+	values := make(map[string]interface{})
+	if err := setPathValue(values, ref.Column, ref.Path, primitive.A{"Penelope", "Nick"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setPathValue(values, "meta", []cfg.PathSegment{{Field: "rating"}}, "PG"); err != nil {
+		t.Fatal(err)
+	}
+	if names := getPathValue(values[ref.Column], ref.Path); !reflect.DeepEqual(names, []interface{}{"Penelope", "Nick"}) {
+		t.Errorf("expected the written names, got %v", names)
+	}
+	if rating := getPathValue(values["meta"], []cfg.PathSegment{{Field: "rating"}}); rating != "PG" {
+		t.Errorf("expected the written rating next to the actors, got %v", values["meta"])
+	}
+	if err := setPathValue(values, ref.Column, ref.Path, "Penelope"); err == nil {
+		t.Error("expected an error writing a single value to all elements of an array")
+	}
+}
+
+// childDatabase returns the rows of a child table, whose parent keys are in the filter's values.
+type childDatabase struct {
+	db.Database
+	rows    []map[string]interface{}
+	selects int
+}
+
+func (d *childDatabase) Select(ctx context.Context, tableName string, filter cfg.Filter) ([]map[string]interface{}, error) {
+	d.selects++
+	in := filter.(*cfg.InFilter)
+	rows := make([]map[string]interface{}, 0)
+	for _, row := range d.rows {
+		for _, value := range in.Values {
+			if cmp, err := compareKeys(row[in.Column], value); err == nil && cmp == 0 {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func TestChildren(t *testing.T) {
+	var database db.Database = &childDatabase{rows: []map[string]interface{}{
+		{"film_id": []byte("1"), "actor_id": int64(7), "name": "Nick"},
+		{"film_id": []byte("2"), "actor_id": int64(3), "name": "Ed"},
+		{"film_id": []byte("1"), "actor_id": int64(2), "name": "Penelope"},
+	}}
+	films := createNode(&cfg.NodeConfig{Name: "films", Key: "film_id", Children: []cfg.ChildConfig{
+		{Name: "actors", Table: "film_actor", ParentKey: "film_id", Key: "actor_id", Columns: []string{"name"}},
+	}}, &database, &table{id: "dvdrental.film", name: "film"})
+	records := []*record{
+		{Data: map[string]interface{}{"film_id": int32(1)}},
+		{Data: map[string]interface{}{"film_id": int32(2)}},
+		{Data: map[string]interface{}{"film_id": int32(3)}},
+	}
+
+	if err := films.attachChildren(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{map[string]interface{}{"name": "Penelope"}, map[string]interface{}{"name": "Nick"}},
+		{map[string]interface{}{"name": "Ed"}},
+		{},
+	}
+	for i, rec := range records {
+		if !reflect.DeepEqual(rec.Data["actors"], expected[i]) {
+			t.Errorf("film %d: expected children %v, got %v", i+1, expected[i], rec.Data["actors"])
+		}
+	}
+	if selects := database.(*childDatabase).selects; selects != 1 {
+		t.Errorf("expected the children of a page to be selected at once, got %d selects", selects)
+	}
+
+	actors := films.getColumn("actors")
+	if actors.Kind != db.KIND_ARRAY || actors.ElementKind != db.KIND_DOCUMENT {
+		t.Errorf("expected children to be described as an array of documents, got %+v", actors)
+	}
+
+	films.childSchemas["actors"] = &db.TableSchema{Name: "film_actor", Columns: []db.Column{
+		{Name: "film_id", Type: "smallint", Kind: db.KIND_INTEGER},
+		{Name: "actor_id", Type: "smallint", Kind: db.KIND_INTEGER},
+	}}
+	v := newSchemaValidator(map[string]*db.TableSchema{"dvdrental.film": {Name: "film"}})
+	v.checkChild(films, &films.cfg.Children[0])
+	expectedProblem := `embedded column "name" of child "actors" of node "films" doesn't exist in table film_actor`
+	if len(v.problems) != 1 || v.problems[0] != expectedProblem {
+		t.Errorf("expected problem %q, got %v", expectedProblem, v.problems)
+	}
+}
+
+// flakyDatabase fails the given number of updates with the given error.
+type flakyDatabase struct {
+	db.Database
+	failures int
+	err      error
+	updates  []db.UpdateDto
+}
+
+func (d *flakyDatabase) GetConfig() *cfg.DbConfig {
+	return &cfg.DbConfig{Name: "dvdrental", Type: "postgres"}
+}
+
+func (d *flakyDatabase) Init() error {
+	return nil
+}
+
+func (d *flakyDatabase) Describe(ctx context.Context, tableName string) (*db.TableSchema, error) {
+	return &db.TableSchema{Name: tableName}, nil
+}
+
+func (d *flakyDatabase) Update(ctx context.Context, upDto db.UpdateDto) error {
+	if d.failures > 0 {
+		d.failures--
+		return d.err
+	}
+	d.updates = append(d.updates, upDto)
+	return nil
+}
+
+func TestRetryWrite(t *testing.T) {
+	policy := cfg.Retry{Attempts: 3, Backoff: time.Millisecond}
+	lostConnection := &db.DatabaseError{DBName: "dvdrental", ErrMsg: "connection reset", Cat: apperr.CONNECTION}
+	upDto := db.UpdateDto{TableName: "film", KeyName: "film_id", KeyValue: int64(1), UpdatedColumnName: "title", NewValue: "Alien"}
+
+	database := &flakyDatabase{failures: 2, err: lostConnection}
+	attempts, err := retryWrite(context.Background(), policy, &write{kind: WRITE_UPDATE, database: database, dto: upDto})
+	if err != nil || attempts != 3 || len(database.updates) != 1 {
+		t.Errorf("expected the update to succeed in the third attempt, got %d attempts: %v", attempts, err)
+	}
+
+	database = &flakyDatabase{failures: 5, err: lostConnection}
+	if attempts, err := retryWrite(context.Background(), policy, &write{kind: WRITE_UPDATE, database: database, dto: upDto}); err == nil || attempts != 3 {
+		t.Errorf("expected the update to fail after 3 attempts, got %d attempts", attempts)
+	}
+
+	database = &flakyDatabase{failures: 1, err: &db.DatabaseError{DBName: "dvdrental", ErrMsg: "syntax error"}}
+	if attempts, err := retryWrite(context.Background(), policy, &write{kind: WRITE_UPDATE, database: database, dto: upDto}); err == nil || attempts != 1 {
+		t.Errorf("expected a query error not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead_letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/dead_letters.json"
+
+	failedAt := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	var database db.Database = &flakyDatabase{}
+	upDto := db.UpdateDto{TableName: "film", KeyName: "film_id", KeyValue: int64(1), UpdatedColumnName: "last_update", NewValue: failedAt}
+	letter, err := newDeadLetter("films", "films-1", &write{kind: WRITE_UPDATE, database: database, dto: upDto}, 3, errors.New("connection reset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateDeadLetters(path).Add(letter)
+
+	// Dead letters survive a restart.
+	deadLetters := CreateDeadLetters(path)
+	if letters := deadLetters.List("films"); len(letters) != 1 || letters[0].Database != "dvdrental" || letters[0].Table != "film" {
+		t.Fatalf("expected the stored dead letter, got %v", letters)
+	}
+
+	if err := deadLetters.Redrive(context.Background(), map[string]*db.Database{"dvdrental": &database}, letter.ID); err != nil {
+		t.Fatal(err)
+	}
+	updates := database.(*flakyDatabase).updates
+	if len(updates) != 1 || !reflect.DeepEqual(updates[0], upDto) {
+		t.Errorf("expected the update to be repeated with the original values, got %+v", updates)
+	}
+	if letters := CreateDeadLetters(path).List(""); len(letters) != 0 {
+		t.Errorf("expected the re-driven dead letter to be removed, got %v", letters)
+	}
+}
+
+// describedDatabase is a PostgreSQL table with a known schema, whose updates block until they're released.
+type describedDatabase struct {
+	upsertingDatabase
+	schema   *db.TableSchema
+	updating chan struct{}
+	release  chan struct{}
+}
+
+func (d *describedDatabase) Init() error {
+	return nil
+}
+
+func (d *describedDatabase) Describe(ctx context.Context, tableName string) (*db.TableSchema, error) {
+	return d.schema, nil
+}
+
+func (d *describedDatabase) Update(ctx context.Context, upDto db.UpdateDto) error {
+	d.updating <- struct{}{}
+	<-d.release
+	return nil
+}
+
+func TestRedriveValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	target := &describedDatabase{schema: &db.TableSchema{Name: "film", Columns: []db.Column{
+		{Name: "film_id", Type: "integer", Kind: db.KIND_INTEGER},
+		{Name: "replacement_cost", Type: "numeric", Kind: db.KIND_DECIMAL},
+		{Name: "special_features", Type: "ARRAY", Kind: db.KIND_ARRAY, ElementKind: db.KIND_STRING},
+		{Name: "meta", Type: "jsonb", Kind: db.KIND_DOCUMENT},
+	}}}
+	var database db.Database = target
+	cost, err := primitive.ParseDecimal128("20.99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inDto := db.InsertDto{TableName: "film", KeyName: "film_id", KeyValue: int64(1), Values: map[string]interface{}{
+		"replacement_cost": cost,
+		"special_features": primitive.A{"Trailers", "Deleted Scenes"},
+		"meta":             primitive.D{{Key: "rating", Value: "PG"}, {Key: "length", Value: int32(117)}},
+	}}
+	letter, err := newDeadLetter("films", "films-1", &write{kind: WRITE_UPSERT, database: database, dto: inDto}, 3, errors.New("connection reset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters := CreateDeadLetters(path)
+	deadLetters.Add(letter)
+
+	// BSON values stored in the payload are written to PostgreSQL as the columns' types.
+	if err := deadLetters.Redrive(context.Background(), map[string]*db.Database{"dvdrental": &database}, letter.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(target.upserts) != 1 {
+		t.Fatalf("expected the upsert to be repeated, got %d upserts", len(target.upserts))
+	}
+	expected := map[string]interface{}{
+		"replacement_cost": "20.99",
+		"special_features": `{"Trailers","Deleted Scenes"}`,
+		"meta":             `{"length":117,"rating":"PG"}`,
+	}
+	if upsert := target.upserts[0]; upsert.KeyValue != int64(1) || !reflect.DeepEqual(upsert.Values, expected) {
+		t.Errorf("expected values %v keyed by 1, got %v keyed by %v", expected, upsert.Values, upsert.KeyValue)
+	}
+
+	// Dead letters can be added while a slow write is being re-driven, but the same letter can't be re-driven again.
+	upDto := db.UpdateDto{TableName: "film", KeyName: "film_id", KeyValue: int64(1), UpdatedColumnName: "replacement_cost", NewValue: cost}
+	letter, err = newDeadLetter("films", "films-2", &write{kind: WRITE_UPDATE, database: database, dto: upDto}, 3, errors.New("connection reset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters.Add(letter)
+	target.updating, target.release = make(chan struct{}), make(chan struct{})
+	redriven := make(chan error, 1)
+	go func() {
+		redriven <- deadLetters.Redrive(context.Background(), map[string]*db.Database{"dvdrental": &database}, letter.ID)
+	}()
+	<-target.updating
+
+	added := make(chan struct{})
+	go func() {
+		other, _ := newDeadLetter("actors", "actors-1", &write{kind: WRITE_UPDATE, database: database, dto: upDto}, 1, errors.New("timeout"))
+		deadLetters.Add(other)
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected dead letters to be added during a redrive")
+	}
+	if err := deadLetters.Redrive(context.Background(), map[string]*db.Database{"dvdrental": &database}, letter.ID); apperr.CategoryOf(err) != apperr.REQUEST {
+		t.Errorf("expected a letter being re-driven not to be re-driven again, got %v", err)
+	}
+
+	close(target.release)
+	if err := <-redriven; err != nil {
+		t.Fatal(err)
+	}
+	if letters := deadLetters.List(""); len(letters) != 1 || letters[0].Synch != "actors" {
+		t.Errorf("expected only the added dead letter to be left, got %v", letters)
+	}
+}
+
+func TestFailedOperations(t *testing.T) {
+	values, err := newValueConverter(cfg.Compare{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Synch{
+		cfg:      &cfg.SynchConfig{Name: "films", Do: []string{cfg.DB_UPDATE}},
+		values:   values,
+		counters: newCounters(),
+		result:   &Result{},
+		stype:    ONE_OFF,
+	}
+	s.resetIteration()
+
+	queryErr := &db.DatabaseError{DBName: "msamp", ErrMsg: "document validation failed", KeyName: "ext_id", KeyValue: int64(1)}
+	var database db.Database = &flakyDatabase{failures: 1, err: queryErr}
+	films := createNode(&cfg.NodeConfig{Name: "films", Key: "film_id"}, &database, &table{name: "film"})
+	docs := createNode(&cfg.NodeConfig{Name: "docs", Key: "_id"}, &database, &table{name: "films"})
+	docs.setMatchColumn("ext_id")
+	lnk := &Link{synch: s, source: films, target: docs, sourceColumn: "title", targetColumn: "Title", sourceExID: "film_id", targetExID: "ext_id"}
+	lnk.sourceRecords = newRecordStream(films, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"film_id": int64(1), "title": "Alien"}, {"film_id": int64(2), "title": "Heat"}, {"film_id": int64(3), "title": "Jaws"}},
+	}}, "film_id")
+	lnk.targetRecords = newRecordStream(docs, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"_id": "a", "ext_id": int64(1), "Title": "Aliens"}, {"_id": "b", "ext_id": int64(2), "Title": "Heat"}},
+	}}, "ext_id")
+
+	pairs, err := lnk.createPairs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newPairPool(context.Background(), s.cfg.Concurrency, []*Link{lnk})
+	if err := s.synchronize(context.Background(), pool, pairs); err != nil {
+		t.Fatal(err)
+	}
+	pool.close()
+	lnk.reset()
+	s.finishIteration()
+
+	expected := Counters{Selected: 5, Paired: 2, Unpaired: 1, Failed: 1, Skipped: 2}
+	if counters := s.counters.snapshot(); counters != expected {
+		t.Errorf("expected counters %+v, got %+v", expected, counters)
+	}
+	if len(s.result.Operations) != 1 {
+		t.Fatalf("expected one failed operation, got %d", len(s.result.Operations))
+	}
+	failed, isFailed := s.result.Operations[0].(*failedOperation)
+	if !isFailed || failed.Phase != PHASE_WRITE || failed.FailedOperation != cfg.OPERATION_UPDATE || failed.DatabaseError != queryErr {
+		t.Fatalf("expected an update failed while writing with the database's error, got %+v", s.result.Operations[0])
+	}
+	if upDto, isUpdate := failed.Dto.(db.UpdateDto); !isUpdate || upDto.NewValue != "Alien" || upDto.TableName != "films" {
+		t.Errorf("expected the attempted update to be reported, got %+v", failed.Dto)
+	}
+}
+
+func TestMasks(t *testing.T) {
+	values, err := newValueConverter(cfg.Compare{TimeZone: "Europe/Warsaw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := &mask{cfg: &cfg.Mask{Function: cfg.MASK_HASH, Salt: "pepper"}}
+	hashed, err := hash.apply("Alien", values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := hash.apply([]byte("Alien"), values, &db.Column{Kind: db.KIND_STRING}); again != hashed || len(hashed.(string)) != 64 {
+		t.Errorf("expected the same text to get the same hash, got %v and %v", hashed, again)
+	}
+	otherSalt := &mask{cfg: &cfg.Mask{Function: cfg.MASK_HASH, Salt: "salt"}}
+	if salted, _ := otherSalt.apply("Alien", values, nil); salted == hashed {
+		t.Errorf("expected hashes to depend on the salt")
+	}
+
+	tests := []struct {
+		mask     cfg.Mask
+		value    interface{}
+		expected interface{}
+	}{
+		{cfg.Mask{Function: cfg.MASK_REDACT}, "secret", "******"},
+		{cfg.Mask{Function: cfg.MASK_REDACT, Keep: 4}, int64(4111111111111111), "************1111"},
+		{cfg.Mask{Function: cfg.MASK_REDACT, Keep: 4}, "abc", "***"},
+		{cfg.Mask{Function: cfg.MASK_FAKE_NAME}, nil, nil},
+		{cfg.Mask{Function: cfg.MASK_TRUNCATE_DATE, Unit: cfg.TRUNCATE_MONTH}, time.Date(2020, 5, 17, 23, 30, 0, 0, time.UTC),
+			time.Date(2020, 4, 30, 22, 0, 0, 0, time.UTC)},
+		{cfg.Mask{Function: cfg.MASK_TRUNCATE_DATE}, primitive.NewDateTimeFromTime(time.Date(2020, 5, 17, 23, 30, 0, 0, time.UTC)),
+			time.Date(2020, 5, 17, 22, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		m, err := newMask(&test.mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		masked, err := m.apply(test.value, values, nil)
+		if err != nil {
+			t.Errorf("%s of %v failed: %v", test.mask.Function, test.value, err)
+			continue
+		}
+		// Truncated times are returned in the converter's zone.
+		if maskedTime, isTime := masked.(time.Time); isTime {
+			masked = maskedTime.UTC()
+		}
+		if masked != test.expected {
+			t.Errorf("expected %s of %v to be %v, got %v", test.mask.Function, test.value, test.expected, masked)
+		}
+	}
+
+	fakeEmail := &mask{cfg: &cfg.Mask{Function: cfg.MASK_FAKE_EMAIL, Salt: "pepper"}}
+	email, err := fakeEmail.apply("ripley@nostromo.space", values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := fakeEmail.apply("ripley@nostromo.space", values, nil); again != email || !strings.HasSuffix(email.(string), "@"+FAKE_EMAIL_DOMAIN) {
+		t.Errorf("expected the same email to get the same fake one, got %v and %v", email, again)
+	}
+	if _, err := fakeEmail.apply([]interface{}{"a"}, values, nil); err == nil {
+		t.Errorf("expected arrays not to be masked")
+	}
+	// Errors are reported, so they mustn't contain the unmasked value.
+	truncate := &mask{cfg: &cfg.Mask{Function: cfg.MASK_TRUNCATE_DATE}}
+	for _, value := range []interface{}{"ripley@nostromo.space", struct{ Email string }{"ripley@nostromo.space"}} {
+		if _, err := truncate.apply(value, values, nil); err == nil || strings.Contains(err.Error(), "ripley") {
+			t.Errorf("expected an error without the masked value, got %v", err)
+		}
+	}
+
+	// Masked values are compared with the target's, so records, which are already masked, are skipped.
+	s := &Synch{
+		cfg: &cfg.SynchConfig{
+			Name: "films",
+			Do:   []string{cfg.DB_UPDATE},
+			Mask: []cfg.Mask{{Column: "docs.Title", Function: cfg.MASK_HASH, Salt: "pepper"}},
+		},
+		values:     values,
+		counters:   newCounters(),
+		result:     &Result{},
+		stype:      ONE_OFF,
+		simulation: true,
+	}
+	var database db.Database = &recordingDatabase{}
+	films := createNode(&cfg.NodeConfig{Name: "films", Key: "film_id"}, &database, &table{name: "film"})
+	docs := createNode(&cfg.NodeConfig{Name: "docs", Key: "_id"}, &database, &table{name: "films"})
+	docs.setMatchColumn("ext_id")
+	s.dbStore = &dbStore{nodes: map[string]*node{"films": films, "docs": docs}}
+	if err := s.parseCfgMasks(); err != nil {
+		t.Fatal(err)
+	}
+	s.resetIteration()
+
+	lnk := &Link{synch: s, source: films, target: docs, sourceColumn: "title", targetColumn: "Title", sourceExID: "film_id", targetExID: "ext_id"}
+	lnk.sourceRecords = newRecordStream(films, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"film_id": int64(1), "title": "Alien"}, {"film_id": int64(2), "title": "Heat"}},
+	}}, "film_id")
+	lnk.targetRecords = newRecordStream(docs, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"_id": "a", "ext_id": int64(1), "Title": hashed}, {"_id": "b", "ext_id": int64(2), "Title": "Heat"}},
+	}}, "ext_id")
+
+	pairs, err := lnk.createPairs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newPairPool(context.Background(), s.cfg.Concurrency, []*Link{lnk})
+	if err := s.synchronize(context.Background(), pool, pairs); err != nil {
+		t.Fatal(err)
+	}
+	pool.close()
+	s.finishIteration()
+
+	heat, _ := hash.apply("Heat", values, nil)
+	expectedOperations := map[interface{}]string{hashed: cfg.OPERATION_IDLE, heat: cfg.OPERATION_UPDATE}
+	if len(s.result.Operations) != len(expectedOperations) {
+		t.Fatalf("expected an idle operation and an update, got %d operations", len(s.result.Operations))
+	}
+	for _, operation := range s.result.Operations {
+		reported, isReported := operation.(*updateOrIdleOperation)
+		if !isReported || expectedOperations[reported.SourceColumnValue] != reported.Operation {
+			t.Errorf("expected operations to report masked values, got %+v", operation)
+		}
+	}
+
+	s.cfg.Mask = []cfg.Mask{{Column: "docs.ext_id", Function: cfg.MASK_REDACT}}
+	if err := s.parseCfgMasks(); err == nil {
+		t.Errorf("expected the match column not to be masked")
+	}
+}
+
+// upsertingDatabase records upserts.
+type upsertingDatabase struct {
+	db.Database
+	upserts []db.InsertDto
+}
+
+func (d *upsertingDatabase) GetConfig() *cfg.DbConfig {
+	return &cfg.DbConfig{Name: "dvdrental", Type: "postgres"}
+}
+
+func (d *upsertingDatabase) Upsert(ctx context.Context, inDto db.InsertDto) error {
+	d.upserts = append(d.upserts, inDto)
+	return nil
+}
+
+func TestUpsertPairs(t *testing.T) {
+	values, err := newValueConverter(cfg.Compare{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Synch{
+		cfg:      &cfg.SynchConfig{Name: "films", Do: []string{cfg.DB_UPSERT}},
+		values:   values,
+		counters: newCounters(),
+		result:   &Result{},
+		stype:    ONE_OFF,
+	}
+	s.resetIteration()
+
+	target := &upsertingDatabase{}
+	var sourceDatabase db.Database = &recordingDatabase{}
+	var targetDatabase db.Database = target
+	docs := createNode(&cfg.NodeConfig{Name: "docs", Key: "_id"}, &sourceDatabase, &table{name: "films"})
+	films := createNode(&cfg.NodeConfig{Name: "films", Key: "film_id"}, &targetDatabase, &table{name: "film"})
+	films.setMatchColumn("ext_id")
+	s.mappings = []*Mapping{{synch: s, source: docs, target: films, sourceColumn: "Title", targetColumn: "title"}}
+	lnk := &Link{synch: s, source: docs, target: films, sourceColumn: "Title", targetColumn: "title", sourceExID: "_id", targetExID: "ext_id"}
+	lnk.sourceRecords = newRecordStream(docs, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"_id": "a", "Title": "Alien"}, {"_id": "b", "Title": "Heat"}},
+	}}, "_id")
+	lnk.targetRecords = newRecordStream(films, &sliceIterator{pages: [][]map[string]interface{}{
+		{{"film_id": int64(1), "ext_id": "a", "title": "Alien"}},
+	}}, "ext_id")
+
+	pairs, err := lnk.createPairs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newPairPool(context.Background(), s.cfg.Concurrency, []*Link{lnk})
+	if err := s.synchronize(context.Background(), pool, pairs); err != nil {
+		t.Fatal(err)
+	}
+	pool.close()
+	s.finishIteration()
+
+	if len(target.upserts) != 1 {
+		t.Fatalf("expected the unpaired record to be upserted, got %d upserts", len(target.upserts))
+	}
+	if upsert := target.upserts[0]; upsert.KeyName != "ext_id" || upsert.KeyValue != "b" || upsert.Values["title"] != "Heat" {
+		t.Errorf("expected an upsert keyed by the match column, got %+v", upsert)
+	}
+	expected := Counters{Paired: 1, Unpaired: 1, Inserted: 1, Skipped: 1}
+	if counters := s.counters.snapshot(); counters != expected {
+		t.Errorf("expected counters %+v, got %+v", expected, counters)
+	}
+	if len(s.result.Operations) != 1 {
+		t.Fatalf("expected one operation, got %d", len(s.result.Operations))
+	}
+	if upserted, isInsert := s.result.Operations[0].(*insertOperation); !isInsert || upserted.Operation != cfg.OPERATION_UPSERT {
+		t.Errorf("expected the write to be reported as an upsert, got %+v", s.result.Operations[0])
+	}
+}
+
+// recordingDatabase records updates and the greatest number of them running at once.
+type recordingDatabase struct {
+	db.Database
+	mux        sync.Mutex
+	running    int
+	maxRunning int
+	updates    []db.UpdateDto
+}
+
+func (d *recordingDatabase) GetConfig() *cfg.DbConfig {
+	return &cfg.DbConfig{Name: "msamp", Type: "mongo"}
+}
+
+func (d *recordingDatabase) Update(ctx context.Context, upDto db.UpdateDto) error {
+	d.mux.Lock()
+	d.running++
+	if d.running > d.maxRunning {
+		d.maxRunning = d.running
+	}
+	d.mux.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.running--
+	d.updates = append(d.updates, upDto)
+	return nil
+}
+
+func TestPairPool(t *testing.T) {
+	values, err := newValueConverter(cfg.Compare{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Synch{
+		cfg:      &cfg.SynchConfig{Name: "films", Do: []string{cfg.DB_UPDATE}, Concurrency: cfg.Concurrency{Workers: 4, PerTarget: 2}},
+		values:   values,
+		counters: newCounters(),
+		result:   &Result{},
+		stype:    ONGOING,
+	}
+	s.resetIteration()
+
+	recording := &recordingDatabase{}
+	var database db.Database = recording
+	films := createNode(&cfg.NodeConfig{Name: "films", Key: "film_id"}, &database, &table{name: "film"})
+	docs := createNode(&cfg.NodeConfig{Name: "docs", Key: "_id"}, &database, &table{name: "films"})
+	docs.setMatchColumn("ext_id")
+	lnk := &Link{synch: s, source: films, target: docs, sourceColumn: "title", targetColumn: "Title", sourceExID: "film_id", targetExID: "ext_id"}
+
+	// Every record is updated three times, the updates have to keep their order.
+	pairs := make([]*Pair, 0)
+	for n := 0; n < 3; n++ {
+		for key := int64(0); key < 10; key++ {
+			source := &record{Data: map[string]interface{}{"film_id": key, "title": fmt.Sprintf("%d-%d", key, n)}}
+			target := &record{Data: map[string]interface{}{"_id": key, "ext_id": key, "Title": ""}}
+			pairs = append(pairs, createPair(lnk, source, target))
+		}
+	}
+
+	pool := newPairPool(context.Background(), s.cfg.Concurrency, []*Link{lnk})
+	if err := s.synchronize(context.Background(), pool, pairs); err != nil {
+		t.Fatal(err)
+	}
+	pool.close()
+	s.finishIteration()
+
+	if len(recording.updates) != len(pairs) || len(s.result.Operations) != len(pairs) {
+		t.Fatalf("expected %d updates, got %d and %d operations", len(pairs), len(recording.updates), len(s.result.Operations))
+	}
+	if recording.maxRunning > 2 {
+		t.Errorf("expected at most 2 updates of the target at once, got %d", recording.maxRunning)
+	}
+	next := make(map[interface{}]int)
+	for _, upDto := range recording.updates {
+		expected := fmt.Sprintf("%v-%d", upDto.KeyValue, next[upDto.KeyValue])
+		if upDto.NewValue != expected {
+			t.Errorf("expected update %s, got %v", expected, upDto.NewValue)
+		}
+		next[upDto.KeyValue]++
+	}
+	if counters := s.counters.snapshot(); counters.Updated != len(pairs) {
+		t.Errorf("expected %d updated records, got %+v", len(pairs), counters)
+	}
+}
+
+// memoryDatabase serves tables from memory. Their rows have to be sorted by the match column.
+type memoryDatabase struct {
+	db.Database
+	tables map[string][]map[string]interface{}
+}
+
+func (d *memoryDatabase) GetConfig() *cfg.DbConfig {
+	return &cfg.DbConfig{Name: "memory", Type: "mongo"}
+}
+
+func (d *memoryDatabase) Init() error {
+	return nil
+}
+
+func (d *memoryDatabase) Describe(ctx context.Context, tableName string) (*db.TableSchema, error) {
+	schema := &db.TableSchema{Name: tableName}
+	for column := range d.tables[tableName][0] {
+		schema.Columns = append(schema.Columns, db.Column{Name: column, Kind: db.KIND_MIXED})
+	}
+	return schema, nil
+}
+
+func (d *memoryDatabase) Iterate(ctx context.Context, tableName string, opts db.SelectOptions) (db.RecordIterator, error) {
+	return &sliceIterator{pages: [][]map[string]interface{}{d.tables[tableName]}}, nil
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	var database db.Database = &memoryDatabase{tables: map[string][]map[string]interface{}{
+		"film": {{"film_id": int64(1), "title": "Alien"}, {"film_id": int64(2), "title": "Heat"}},
+		"docs": {{"_id": "a", "ext_id": int64(1), "Title": "Alien"}, {"_id": "b", "ext_id": int64(2), "Title": "Heat"}},
+	}}
+	DBMap := map[string]*db.Database{"memory": &database}
+
+	registry := CreateSynchs()
+	registry.Add(&cfg.SynchConfig{
+		Name: "films",
+		Nodes: []cfg.NodeConfig{
+			{Name: "films", Database: "memory", Table: "film", Key: "film_id"},
+			{Name: "docs", Database: "memory", Table: "docs", Key: "_id"},
+		},
+		Link:        []string{"[films.title] TO [docs.Title]"},
+		Match:       cfg.Match{Method: "ids", Args: []string{"films.film_id", "docs.ext_id"}},
+		Do:          []string{cfg.DB_UPDATE},
+		Concurrency: cfg.Concurrency{Workers: 2},
+	})
+	runs := CreateRuns()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(ongoing bool) {
+			defer wg.Done()
+			template, found := registry.Get("films")
+			if !found {
+				t.Error("expected the synch to be registered")
+				return
+			}
+			stype := "one-off"
+			if ongoing {
+				stype = "ongoing"
+			}
+			run, err := template.NewRun(context.Background(), DBMap, nil, stype, false)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			runs.Add(run)
+			if !ongoing {
+				if _, err := run.Execute(); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+
+			go run.Loop()
+			// Runs are listed and stopped by several handlers at once.
+			var stops sync.WaitGroup
+			for j := 0; j < 3; j++ {
+				stops.Add(1)
+				go func() {
+					defer stops.Done()
+					registry.Names()
+					for _, listed := range runs.List() {
+						listed.Info()
+					}
+					if result := run.Stop(); result == nil {
+						t.Error("expected a stopped run to have a result")
+					}
+				}()
+			}
+			stops.Wait()
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	for _, run := range runs.List() {
+		if run.IsActive() {
+			t.Errorf("expected run %s to be finished, got %s", run.ID, run.GetStatus())
+		}
+	}
+	if len(runs.List()) != 4 {
+		t.Errorf("expected 4 runs, got %d", len(runs.List()))
+	}
+}
+
+func TestRuns(t *testing.T) {
+	var database db.Database = &memoryDatabase{tables: map[string][]map[string]interface{}{
+		"film": {{"film_id": int64(1), "title": "Alien"}},
+		"docs": {{"_id": "a", "ext_id": int64(1), "Title": "Alien"}},
+	}}
+	DBMap := map[string]*db.Database{"memory": &database}
+	template := &Synch{cfg: &cfg.SynchConfig{
+		Name: "films",
+		Nodes: []cfg.NodeConfig{
+			{Name: "films", Database: "memory", Table: "film", Key: "film_id"},
+			{Name: "docs", Database: "memory", Table: "docs", Key: "_id"},
+		},
+		Link:  []string{"[films.title] TO [docs.Title]"},
+		Match: cfg.Match{Method: "ids", Args: []string{"films.film_id", "docs.ext_id"}},
+		Do:    []string{cfg.DB_UPDATE},
+	}}
+	runs := CreateRuns()
+	newRun := func(stype string) *Run {
+		run, err := template.NewRun(context.Background(), DBMap, nil, stype, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs.Add(run)
+		// Run IDs are based on the creation time.
+		time.Sleep(time.Millisecond)
+		return run
+	}
+
+	oneOff := newRun("one-off")
+	if _, err := oneOff.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	ongoing := newRun("ongoing")
+	go ongoing.Loop()
+	failed := newRun("ongoing")
+	failed.Fail(errors.New("connection lost"))
+
+	if run, found := runs.Get(ongoing.ID); !found || run != ongoing {
+		t.Errorf("expected run %s to be found", ongoing.ID)
+	}
+	if _, found := runs.Get("films-0"); found {
+		t.Errorf("expected an unknown run not to be found")
+	}
+	if active := runs.FindActive("films"); len(active) != 1 || active[0] != ongoing {
+		t.Errorf("expected only the ongoing run to be active, got %v", active)
+	}
+	if listed := runs.List(); len(listed) != 3 || listed[0] != oneOff || listed[1] != ongoing || listed[2] != failed {
+		t.Errorf("expected runs to be listed by their start time, got %v", listed)
+	}
+
+	ongoing.Stop()
+	expectedStatuses := map[*Run]string{oneOff: RUN_STATUS_FINISHED, ongoing: RUN_STATUS_STOPPED, failed: RUN_STATUS_FAILED}
+	for run, status := range expectedStatuses {
+		if run.GetStatus() != status || run.IsActive() {
+			t.Errorf("expected run %s to be %s, got %s", run.ID, status, run.GetStatus())
+		}
+	}
+	if info := failed.Info(); info.Message != "connection lost" || info.FinishedAt == "" {
+		t.Errorf("expected the failed run's error to be reported, got %+v", info)
+	}
+	if active := runs.FindActive("films"); len(active) != 0 {
+		t.Errorf("expected no active runs after the stop, got %v", active)
+	}
+
+	// Only the latest finished runs are kept, active runs are never pruned.
+	runs.maxFinished = 1
+	active := newRun("ongoing")
+	if listed := runs.List(); len(listed) != 2 || listed[0] != ongoing || listed[1] != active {
+		t.Errorf("expected the latest finished run and the active one to be kept, got %v", listed)
+	}
+	runs.ttl = 0
+	if listed := runs.List(); len(listed) != 1 || listed[0] != active {
+		t.Errorf("expected expired runs to be pruned, got %v", listed)
+	}
+	active.Fail(errors.New("stopped by the test"))
+}
+
+// slowDatabase describes tables only once the context is done, like a database,
+// which doesn't answer before its query timeout.
+type slowDatabase struct {
+	memoryDatabase
+}
+
+func (d *slowDatabase) Describe(ctx context.Context, tableName string) (*db.TableSchema, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestNewRunCancelled(t *testing.T) {
+	var database db.Database = &slowDatabase{}
+	DBMap := map[string]*db.Database{"memory": &database}
+	template := &Synch{cfg: &cfg.SynchConfig{
+		Name: "films",
+		Nodes: []cfg.NodeConfig{
+			{Name: "films", Database: "memory", Table: "film", Key: "film_id"},
+			{Name: "docs", Database: "memory", Table: "docs", Key: "_id"},
+		},
+		Link:  []string{"[films.title] TO [docs.Title]"},
+		Match: cfg.Match{Method: "ids", Args: []string{"films.film_id", "docs.ext_id"}},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := template.NewRun(ctx, DBMap, nil, "ongoing", false)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the schema validation to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the schema validation to stop along with the parent context")
+	}
+}
@@ -23,3 +23,14 @@ func FindSynchType(sType string) (synchType, error) {
 		return 0, errors.New("Synch type \"" + sType + "\" not found")
 	}
 }
+
+func (st synchType) String() string {
+	switch st {
+	case ONE_OFF:
+		return "one-off"
+	case ONGOING:
+		return "ongoing"
+	default:
+		return ""
+	}
+}
@@ -1,7 +1,10 @@
 package synch
 
 import (
+	"context"
+
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
 )
 
 // Synchronizer is implemented by structs that do the actual synchronization actions.
@@ -9,8 +12,13 @@ type Synchronizer interface {
 	GetConfig() *cfg.SynchConfig
 	GetIteration() *iteration
 	GetNodes() map[string]*node
-	GetRawMappings() []map[string]string
+	GetMappings() []*Mapping
+	GetValueConverter() *valueConverter
+	GetMask(nodeName string, column string, path []cfg.PathSegment) *mask
+	GetDeadLetters() *DeadLetters
+	GetCounters() *counters
+	GetRateLimiter() *db.RateLimiter
 	GetType() synchType
 	IsSimulation() bool
-	Run()
+	Run(ctx context.Context) error
 }
@@ -1,11 +1,18 @@
 package synch
 
 import (
+	"sort"
+	"sync"
+
 	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
 )
 
 // Synchs is a collection of all synchronizations.
-type Synchs map[string]*Synch
+// It's shared by the request handlers, so it's safe for concurrent use.
+type Synchs struct {
+	mux    sync.RWMutex
+	synchs map[string]*Synch
+}
 
 // Init loads configs from files and validates them.
 func (s *Synchs) Init() {
@@ -17,20 +24,48 @@ func (s *Synchs) getConfigs() {
 	var synchCfgs []cfg.Config = cfg.GetSynchConfigs()
 
 	for i := 0; i < len(synchCfgs); i++ {
-		synchCfg := synchCfgs[i].(*cfg.SynchConfig)
-		(*s)[synchCfg.Name] = &Synch{cfg: synchCfg, initial: true}
+		s.Add(synchCfgs[i].(*cfg.SynchConfig))
 	}
 }
 
 // validateConfigs validates data imported from a config file.
 func (s *Synchs) validateConfigs() {
-	for _, synch := range *s {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, synch := range s.synchs {
 		(*synch).GetConfig().Validate()
 	}
 }
 
+// Add creates a synch from its config.
+func (s *Synchs) Add(synchCfg *cfg.SynchConfig) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.synchs[synchCfg.Name] = &Synch{cfg: synchCfg, initial: true}
+}
+
+// Get returns a synch by its name.
+func (s *Synchs) Get(name string) (*Synch, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	synch, found := s.synchs[name]
+	return synch, found
+}
+
+// Names returns the sorted names of all synchs.
+func (s *Synchs) Names() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	names := make([]string, 0, len(s.synchs))
+	for name := range s.synchs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // CreateSynchs constructor function for the Synchs struct.
-func CreateSynchs() Synchs {
-	synchs := make(Synchs)
-	return synchs
+func CreateSynchs() *Synchs {
+	return &Synchs{synchs: make(map[string]*Synch)}
 }
@@ -2,13 +2,10 @@ package synch
 
 import "github.com/christoph-karpowicz/db_mediator/internal/server/db"
 
+// table holds a node's table. Its schema is described when the synch is initialized.
 type table struct {
-	id            string
-	db            *db.Database
-	name          string
-	activeRecords *records
-}
-
-func (t *table) setActiveRecords(records []map[string]interface{}) {
-	t.activeRecords = mapToRecords(records)
+	id     string
+	db     *db.Database
+	name   string
+	schema *db.TableSchema
 }
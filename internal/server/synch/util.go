@@ -1,89 +1,16 @@
 package synch
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
 	"reflect"
-)
-
-func areEqual(val1 interface{}, val2 interface{}) (bool, error) {
-	var val1kind reflect.Kind = reflect.TypeOf(val1).Kind()
-	var val2kind reflect.Kind = reflect.TypeOf(val2).Kind()
-
-	// Strings
-	if val1kind == reflect.String && val2kind == reflect.String {
-		return val1.(string) == val2.(string), nil
-	}
-
-	// Signed ints
-	if isSignedInt(val1kind) && isSignedInt(val2kind) {
-		var val1int64 int64
-		var val2int64 int64
-
-		switch val1kind {
-		case reflect.Int:
-			val1int64 = int64(val1.(int))
-		case reflect.Int8:
-			val1int64 = int64(val1.(int8))
-		case reflect.Int16:
-			val1int64 = int64(val1.(int16))
-		case reflect.Int32:
-			val1int64 = int64(val1.(int32))
-		case reflect.Int64:
-			val1int64 = int64(val1.(int64))
-		}
-
-		switch val2kind {
-		case reflect.Int:
-			val2int64 = int64(val2.(int))
-		case reflect.Int8:
-			val2int64 = int64(val2.(int8))
-		case reflect.Int16:
-			val2int64 = int64(val2.(int16))
-		case reflect.Int32:
-			val2int64 = int64(val2.(int32))
-		case reflect.Int64:
-			val2int64 = int64(val2.(int64))
-		}
-
-		return val1int64 == val2int64, nil
-	}
+	"strings"
+	"time"
 
-	// Unsigned ints
-	if isUnsignedInt(val1kind) && isUnsignedInt(val2kind) {
-		var val1uint64 uint64
-		var val2uint64 uint64
-
-		switch val1kind {
-		case reflect.Uint:
-			val1uint64 = uint64(val1.(uint))
-		case reflect.Uint8:
-			val1uint64 = uint64(val1.(uint8))
-		case reflect.Uint16:
-			val1uint64 = uint64(val1.(uint16))
-		case reflect.Uint32:
-			val1uint64 = uint64(val1.(uint32))
-		case reflect.Uint64:
-			val1uint64 = uint64(val1.(uint64))
-		}
-
-		switch val2kind {
-		case reflect.Uint:
-			val2uint64 = uint64(val2.(uint))
-		case reflect.Uint8:
-			val2uint64 = uint64(val2.(uint8))
-		case reflect.Uint16:
-			val2uint64 = uint64(val2.(uint16))
-		case reflect.Uint32:
-			val2uint64 = uint64(val2.(uint32))
-		case reflect.Uint64:
-			val2uint64 = uint64(val2.(uint64))
-		}
-
-		return val1uint64 == val2uint64, nil
-	}
-
-	return false, errors.New("Invalid data types")
-}
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 func isSignedInt(val reflect.Kind) bool {
 	signedIntTypes := []reflect.Kind{
@@ -117,11 +44,110 @@ func isUnsignedInt(val reflect.Kind) bool {
 	return false
 }
 
-func mapToRecords(mapRecordArray []map[string]interface{}) *records {
-	var recordArray records = make(records, 0)
-	for _, mapRecord := range mapRecordArray {
-		record := record{Data: mapRecord}
-		recordArray = append(recordArray, &record)
+// Kinds of match keys in the order, in which MongoDB sorts them.
+const (
+	KEY_NULL = iota
+	KEY_NUMBER
+	KEY_STRING
+	KEY_OBJECT_ID
+	KEY_BOOL
+	KEY_TIME
+)
+
+// compareKeys compares two match keys the same way the databases sort them:
+// nulls first, numbers by value and strings byte by byte.
+// Keys of different kinds are compared by kind.
+func compareKeys(key1 interface{}, key2 interface{}) (int, error) {
+	kind1, value1, err := normalizeKey(key1)
+	if err != nil {
+		return 0, err
+	}
+	kind2, value2, err := normalizeKey(key2)
+	if err != nil {
+		return 0, err
+	}
+	if kind1 != kind2 {
+		return compareInts(kind1, kind2), nil
+	}
+
+	switch kind1 {
+	case KEY_NUMBER:
+		return value1.(*big.Float).Cmp(value2.(*big.Float)), nil
+	case KEY_STRING:
+		return strings.Compare(value1.(string), value2.(string)), nil
+	case KEY_OBJECT_ID:
+		id1, id2 := value1.(primitive.ObjectID), value2.(primitive.ObjectID)
+		return bytes.Compare(id1[:], id2[:]), nil
+	case KEY_BOOL:
+		return compareInts(boolToInt(value1.(bool)), boolToInt(value2.(bool))), nil
+	case KEY_TIME:
+		time1, time2 := value1.(time.Time), value2.(time.Time)
+		switch {
+		case time1.Before(time2):
+			return -1, nil
+		case time1.After(time2):
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// normalizeKey returns the key's kind and its value converted to a comparable type.
+// PostgreSQL numerics are returned by the driver as bytes.
+func normalizeKey(key interface{}) (int, interface{}, error) {
+	switch k := key.(type) {
+	case nil:
+		return KEY_NULL, nil, nil
+	case string:
+		return KEY_STRING, k, nil
+	case []byte:
+		if number, _, err := big.ParseFloat(string(k), 10, 128, big.ToNearestEven); err == nil {
+			return KEY_NUMBER, number, nil
+		}
+		return KEY_STRING, string(k), nil
+	case primitive.Decimal128:
+		if number, _, err := big.ParseFloat(k.String(), 10, 128, big.ToNearestEven); err == nil {
+			return KEY_NUMBER, number, nil
+		}
+	case primitive.ObjectID:
+		return KEY_OBJECT_ID, k, nil
+	case bool:
+		return KEY_BOOL, k, nil
+	case time.Time:
+		return KEY_TIME, k, nil
+	case primitive.DateTime:
+		return KEY_TIME, time.Unix(0, int64(k)*int64(time.Millisecond)), nil
+	}
+
+	value := reflect.ValueOf(key)
+	switch {
+	case isSignedInt(value.Kind()):
+		return KEY_NUMBER, new(big.Float).SetInt64(value.Int()), nil
+	case isUnsignedInt(value.Kind()):
+		return KEY_NUMBER, new(big.Float).SetUint64(value.Uint()), nil
+	case value.Kind() == reflect.Float32 || value.Kind() == reflect.Float64:
+		if math.IsNaN(value.Float()) {
+			// NaN is sorted before all other numbers.
+			return KEY_NUMBER, new(big.Float).SetInf(true), nil
+		}
+		return KEY_NUMBER, new(big.Float).SetFloat64(value.Float()), nil
+	}
+	return 0, nil, fmt.Errorf("match key %v of type %T can't be compared", key, key)
+}
+
+func compareInts(int1 int, int2 int) int {
+	switch {
+	case int1 < int2:
+		return -1
+	case int1 > int2:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
 	}
-	return &recordArray
+	return 0
 }
@@ -0,0 +1,562 @@
+package synch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresTimeLayouts are the text formats of PostgreSQL times, e.g. in arrays.
+var postgresTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// number is a canonical numeric value. Binary floating point numbers
+// are compared with float64 precision, so that e.g. a double 0.1 equals a numeric 0.1.
+type number struct {
+	value *big.Float
+	float bool
+}
+
+// notANumber is the canonical NaN. Unlike in float arithmetic, NaN equals NaN.
+type notANumber struct{}
+
+// valueConverter compares and converts values of linked columns according to the synch's
+// compare rules. Values are converted to canonical values first: numbers, strings, bools,
+// UTC times, bytes, slices and maps, so that e.g. a PostgreSQL numeric equals a BSON Decimal128.
+type valueConverter struct {
+	tolerance float64
+	precision time.Duration
+	location  *time.Location
+}
+
+func newValueConverter(rules cfg.Compare) (*valueConverter, error) {
+	location, err := rules.GetLocation()
+	if err != nil {
+		return nil, err
+	}
+	return &valueConverter{tolerance: rules.FloatTolerance, precision: rules.GetTimePrecision(), location: location}, nil
+}
+
+// equal compares a source value with a target value.
+// The columns' descriptions can be nil if they aren't known.
+func (c *valueConverter) equal(source interface{}, sourceCol *db.Column, target interface{}, targetCol *db.Column) (bool, error) {
+	canonicalSource, err := c.canonical(source, sourceCol)
+	if err != nil {
+		return false, err
+	}
+	canonicalTarget, err := c.canonical(target, targetCol)
+	if err != nil {
+		return false, err
+	}
+	return c.canonicalEqual(canonicalSource, canonicalTarget), nil
+}
+
+// convert prepares a source value to be written to a target column in a database of the given type.
+func (c *valueConverter) convert(value interface{}, sourceCol *db.Column, targetCol *db.Column, dbType string) (interface{}, error) {
+	canonical, err := c.canonical(value, sourceCol)
+	if err != nil {
+		return nil, err
+	}
+	return c.fromCanonical(canonical, targetCol, dbType)
+}
+
+// fromCanonical converts a canonical value to the target column's type.
+func (c *valueConverter) fromCanonical(canonical interface{}, targetCol *db.Column, dbType string) (interface{}, error) {
+	if dbType == "mongo" {
+		return c.toBSON(canonical, targetCol), nil
+	}
+	return c.toPostgres(canonical, targetCol)
+}
+
+func (c *valueConverter) canonical(value interface{}, col *db.Column) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return nil, nil
+	case string:
+		if columnKind(col) == db.KIND_UUID {
+			return strings.ToLower(v), nil
+		}
+		return v, nil
+	case []byte:
+		return c.canonicalText(v, col)
+	case bool:
+		return v, nil
+	case time.Time:
+		if col != nil && col.IsLocalTime() {
+			return c.localTime(v), nil
+		}
+		return v.UTC(), nil
+	case primitive.DateTime:
+		return v.Time().UTC(), nil
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC(), nil
+	case primitive.ObjectID:
+		return v.Hex(), nil
+	case primitive.Decimal128:
+		return parseNumber(v.String()), nil
+	case json.Number:
+		return parseNumber(v.String()), nil
+	case primitive.Binary:
+		if (v.Subtype == bsontype.BinaryUUID || v.Subtype == bsontype.BinaryUUIDOld) && len(v.Data) == 16 {
+			return formatUUID(v.Data), nil
+		}
+		return v.Data, nil
+	case primitive.D:
+		return c.canonicalMap(v.Map(), col)
+	case primitive.M:
+		return c.canonicalMap(v, col)
+	case map[string]interface{}:
+		return c.canonicalMap(v, col)
+	case primitive.A:
+		return c.canonicalSlice(v, elementColumn(col))
+	case []interface{}:
+		return c.canonicalSlice(v, elementColumn(col))
+	}
+
+	reflectValue := reflect.ValueOf(value)
+	switch {
+	case isSignedInt(reflectValue.Kind()):
+		return number{value: new(big.Float).SetInt64(reflectValue.Int())}, nil
+	case isUnsignedInt(reflectValue.Kind()):
+		return number{value: new(big.Float).SetUint64(reflectValue.Uint())}, nil
+	case reflectValue.Kind() == reflect.Float32 || reflectValue.Kind() == reflect.Float64:
+		if math.IsNaN(reflectValue.Float()) {
+			return notANumber{}, nil
+		}
+		return number{value: new(big.Float).SetFloat64(reflectValue.Float()), float: true}, nil
+	}
+	return nil, fmt.Errorf("value %v of type %T can't be compared", value, value)
+}
+
+// canonicalText converts values, which the PostgreSQL driver returns as text bytes,
+// according to the column's kind. Bytes of unknown columns are numbers or strings.
+func (c *valueConverter) canonicalText(text []byte, col *db.Column) (interface{}, error) {
+	switch columnKind(col) {
+	case db.KIND_BINARY:
+		return text, nil
+	case db.KIND_STRING:
+		return string(text), nil
+	case db.KIND_UUID:
+		return strings.ToLower(string(text)), nil
+	case db.KIND_BOOL:
+		return string(text) == "t" || string(text) == "true", nil
+	case db.KIND_TIME:
+		return c.parseTime(string(text))
+	case db.KIND_DOCUMENT:
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.UseNumber()
+		var document interface{}
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("column %s holds invalid JSON: %s", col.Name, err)
+		}
+		return c.canonical(document, nil)
+	case db.KIND_ARRAY:
+		elements, err := parsePostgresArray(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("column %s holds an invalid array: %s", col.Name, err)
+		}
+		return c.canonicalArray(elements, &db.Column{Name: col.Name, Kind: col.ElementKind})
+	}
+	return parseNumber(string(text)), nil
+}
+
+// canonicalArray converts the text elements of a parsed PostgreSQL array.
+func (c *valueConverter) canonicalArray(elements []interface{}, elementCol *db.Column) (interface{}, error) {
+	canonical := make([]interface{}, len(elements))
+	for i, element := range elements {
+		var err error
+		switch e := element.(type) {
+		case string:
+			canonical[i], err = c.canonicalText([]byte(e), elementCol)
+		case []interface{}:
+			canonical[i], err = c.canonicalArray(e, elementCol)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return canonical, nil
+}
+
+func (c *valueConverter) canonicalSlice(slice []interface{}, elementCol *db.Column) (interface{}, error) {
+	canonical := make([]interface{}, len(slice))
+	for i, element := range slice {
+		var err error
+		if canonical[i], err = c.canonical(element, elementCol); err != nil {
+			return nil, err
+		}
+	}
+	return canonical, nil
+}
+
+func (c *valueConverter) canonicalMap(document map[string]interface{}, col *db.Column) (interface{}, error) {
+	canonical := make(map[string]interface{}, len(document))
+	for key, value := range document {
+		var err error
+		if canonical[key], err = c.canonical(value, fieldColumn(col, key)); err != nil {
+			return nil, err
+		}
+	}
+	return canonical, nil
+}
+
+// localTime interprets the wall clock of a time stored without a time zone in the configured zone.
+func (c *valueConverter) localTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), c.location).UTC()
+}
+
+func (c *valueConverter) parseTime(text string) (interface{}, error) {
+	for _, layout := range postgresTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			if strings.HasSuffix(layout, "07") || strings.HasSuffix(layout, "07:00") {
+				return t.UTC(), nil
+			}
+			return c.localTime(t), nil
+		}
+	}
+	return nil, fmt.Errorf("time \"%s\" can't be parsed", text)
+}
+
+func (c *valueConverter) canonicalEqual(value1 interface{}, value2 interface{}) bool {
+	switch v1 := value1.(type) {
+	case nil:
+		return value2 == nil
+	case number:
+		v2, isNumber := value2.(number)
+		return isNumber && c.numbersEqual(v1, v2)
+	case time.Time:
+		v2, isTime := value2.(time.Time)
+		return isTime && v1.Truncate(c.precision).Equal(v2.Truncate(c.precision))
+	case []byte:
+		v2, isBytes := value2.([]byte)
+		return isBytes && bytes.Equal(v1, v2)
+	case []interface{}:
+		v2, isSlice := value2.([]interface{})
+		if !isSlice || len(v1) != len(v2) {
+			return false
+		}
+		for i := range v1 {
+			if !c.canonicalEqual(v1[i], v2[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		v2, isMap := value2.(map[string]interface{})
+		if !isMap || len(v1) != len(v2) {
+			return false
+		}
+		for key, value := range v1 {
+			if other, found := v2[key]; !found || !c.canonicalEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return value1 == value2
+}
+
+// numbersEqual compares numbers with the configured tolerance.
+func (c *valueConverter) numbersEqual(number1 number, number2 number) bool {
+	if number1.float || number2.float {
+		float1, _ := number1.value.Float64()
+		float2, _ := number2.value.Float64()
+		return float1 == float2 || math.Abs(float1-float2) <= c.tolerance
+	}
+	if number1.value.IsInf() || number2.value.IsInf() || c.tolerance == 0 {
+		return number1.value.Cmp(number2.value) == 0
+	}
+	difference := new(big.Float).Sub(number1.value, number2.value)
+	return difference.Abs(difference).Cmp(big.NewFloat(c.tolerance)) <= 0
+}
+
+// toBSON converts a canonical value to a value of the target field's BSON type.
+// Numbers keep their precision in Decimal128 unless the field holds doubles or integers.
+func (c *valueConverter) toBSON(value interface{}, targetCol *db.Column) interface{} {
+	switch v := value.(type) {
+	case number:
+		kind := columnKind(targetCol)
+		switch {
+		case kind == db.KIND_STRING:
+			return formatNumber(v)
+		case v.float || (kind == db.KIND_DECIMAL && !strings.Contains(targetCol.Type, "decimal")):
+			float, _ := v.value.Float64()
+			return float
+		case v.value.IsInt() && kind != db.KIND_DECIMAL:
+			if integer, accuracy := v.value.Int64(); accuracy == big.Exact {
+				return integer
+			}
+		}
+		if decimal, err := primitive.ParseDecimal128(formatNumber(v)); err == nil {
+			return decimal
+		}
+		float, _ := v.value.Float64()
+		return float
+	case notANumber:
+		return math.NaN()
+	case string:
+		switch columnKind(targetCol) {
+		case db.KIND_OBJECT_ID:
+			if id, err := primitive.ObjectIDFromHex(v); err == nil {
+				return id
+			}
+		case db.KIND_UUID:
+			if data, err := hex.DecodeString(strings.Replace(v, "-", "", -1)); err == nil && len(data) == 16 {
+				return primitive.Binary{Subtype: bsontype.BinaryUUID, Data: data}
+			}
+		}
+		return v
+	case []interface{}:
+		array := make(primitive.A, len(v))
+		for i, element := range v {
+			array[i] = c.toBSON(element, elementColumn(targetCol))
+		}
+		return array
+	case map[string]interface{}:
+		document := make(primitive.M, len(v))
+		for key, element := range v {
+			document[key] = c.toBSON(element, fieldColumn(targetCol, key))
+		}
+		return document
+	}
+	return value
+}
+
+// toPostgres converts a canonical value to a query parameter. Numbers are passed
+// as text to keep their precision, documents as JSON and slices as array literals.
+func (c *valueConverter) toPostgres(value interface{}, targetCol *db.Column) (interface{}, error) {
+	switch v := value.(type) {
+	case number:
+		return formatNumber(v), nil
+	case notANumber:
+		return "NaN", nil
+	case time.Time:
+		// PostgreSQL ignores the offset of times written to columns without a time zone,
+		// so they're written in the configured zone.
+		if targetCol != nil && targetCol.IsLocalTime() {
+			return v.In(c.location), nil
+		}
+		return v, nil
+	case []interface{}:
+		if columnKind(targetCol) == db.KIND_ARRAY {
+			return c.formatPostgresArray(v)
+		}
+		return marshalJSON(v)
+	case map[string]interface{}:
+		return marshalJSON(v)
+	}
+	return value, nil
+}
+
+// formatPostgresArray builds an array literal like {1,"a b",NULL}.
+func (c *valueConverter) formatPostgresArray(elements []interface{}) (string, error) {
+	formatted := make([]string, len(elements))
+	for i, element := range elements {
+		switch e := element.(type) {
+		case nil:
+			formatted[i] = "NULL"
+		case []interface{}:
+			nested, err := c.formatPostgresArray(e)
+			if err != nil {
+				return "", err
+			}
+			formatted[i] = nested
+		case number:
+			formatted[i] = formatNumber(e)
+		case notANumber:
+			formatted[i] = "NaN"
+		case bool:
+			formatted[i] = strconv.FormatBool(e)
+		case time.Time:
+			formatted[i] = quoteArrayElement(e.Format(time.RFC3339Nano))
+		case string:
+			formatted[i] = quoteArrayElement(e)
+		default:
+			document, err := marshalJSON(e)
+			if err != nil {
+				return "", err
+			}
+			formatted[i] = quoteArrayElement(document)
+		}
+	}
+	return "{" + strings.Join(formatted, ",") + "}", nil
+}
+
+func quoteArrayElement(element string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element) + `"`
+}
+
+// parsePostgresArray parses an array literal into a slice of strings, nils and nested slices.
+func parsePostgresArray(text string) ([]interface{}, error) {
+	// Arrays with custom bounds are prefixed with their dimensions, e.g. [0:1]={1,2}.
+	if strings.HasPrefix(text, "[") {
+		if equals := strings.Index(text, "="); equals != -1 {
+			text = text[equals+1:]
+		}
+	}
+	elements, rest, err := parseArrayElements(text)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected \"%s\" after the array", rest)
+	}
+	return elements, nil
+}
+
+// parseArrayElements parses the array at the beginning of the text and returns the rest of the text.
+func parseArrayElements(text string) ([]interface{}, string, error) {
+	if !strings.HasPrefix(text, "{") {
+		return nil, "", fmt.Errorf("expected \"{\" in \"%s\"", text)
+	}
+	text = text[1:]
+	elements := make([]interface{}, 0)
+	if strings.HasPrefix(text, "}") {
+		return elements, text[1:], nil
+	}
+
+	for {
+		switch {
+		case strings.HasPrefix(text, "{"):
+			nested, rest, err := parseArrayElements(text)
+			if err != nil {
+				return nil, "", err
+			}
+			elements = append(elements, nested)
+			text = rest
+		case strings.HasPrefix(text, `"`):
+			var element strings.Builder
+			i := 1
+			for ; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				element.WriteByte(text[i])
+			}
+			if i == len(text) {
+				return nil, "", fmt.Errorf("unterminated quoted element")
+			}
+			elements = append(elements, element.String())
+			text = text[i+1:]
+		default:
+			end := strings.IndexAny(text, ",}")
+			if end == -1 {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+			if element := strings.TrimSpace(text[:end]); strings.EqualFold(element, "NULL") {
+				elements = append(elements, nil)
+			} else {
+				elements = append(elements, element)
+			}
+			text = text[end:]
+		}
+
+		switch {
+		case strings.HasPrefix(text, ","):
+			text = text[1:]
+		case strings.HasPrefix(text, "}"):
+			return elements, text[1:], nil
+		default:
+			return nil, "", fmt.Errorf("unterminated array")
+		}
+	}
+}
+
+// marshalJSON converts a canonical value to a JSON document.
+func marshalJSON(value interface{}) (string, error) {
+	document, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return "", err
+	}
+	return string(document), nil
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case number:
+		return json.Number(formatNumber(v))
+	case notANumber:
+		return "NaN"
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			values[i] = jsonValue(element)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			values[key] = jsonValue(element)
+		}
+		return values
+	}
+	return value
+}
+
+// parseNumber parses a number or returns the text, if it isn't one.
+func parseNumber(text string) interface{} {
+	if value, _, err := big.ParseFloat(text, 10, 128, big.ToNearestEven); err == nil {
+		return number{value: value}
+	}
+	if strings.EqualFold(text, "NaN") {
+		return notANumber{}
+	}
+	return text
+}
+
+// formatNumber formats integers without an exponent and other numbers as briefly as possible.
+func formatNumber(n number) string {
+	if n.float {
+		float, _ := n.value.Float64()
+		return strconv.FormatFloat(float, 'g', -1, 64)
+	}
+	if n.value.IsInt() && !n.value.IsInf() {
+		return n.value.Text('f', 0)
+	}
+	return n.value.Text('g', -1)
+}
+
+func formatUUID(data []byte) string {
+	encoded := hex.EncodeToString(data)
+	return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
+
+func columnKind(col *db.Column) string {
+	if col == nil {
+		return db.KIND_UNKNOWN
+	}
+	return col.Kind
+}
+
+// elementColumn describes the elements of an array column.
+func elementColumn(col *db.Column) *db.Column {
+	if col == nil || col.ElementKind == "" {
+		return nil
+	}
+	return &db.Column{Name: col.Name, Kind: col.ElementKind, Fields: col.Fields}
+}
+
+// fieldColumn describes a field of a document column.
+func fieldColumn(col *db.Column, name string) *db.Column {
+	if col == nil {
+		return nil
+	}
+	return col.GetField(name)
+}
@@ -0,0 +1,89 @@
+package synch
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/christoph-karpowicz/db_mediator/internal/server/apperr"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/cfg"
+	"github.com/christoph-karpowicz/db_mediator/internal/server/db"
+	"github.com/christoph-karpowicz/db_mediator/internal/util"
+)
+
+// Kinds of writes to target databases.
+const (
+	WRITE_UPDATE           = "update"
+	WRITE_INSERT           = "insert"
+	WRITE_UPSERT           = "upsert"
+	WRITE_REPLACE_CHILDREN = "replaceChildren"
+)
+
+// write is a single write to a target database. Its dto is the db.UpdateDto,
+// db.InsertDto or db.ChildrenDto matching its kind. Each attempt waits for its turn
+// according to the limiter, which throttles the writes of a synch.
+type write struct {
+	kind     string
+	database db.Database
+	dto      interface{}
+	limiter  *db.RateLimiter
+}
+
+func (w *write) execute(ctx context.Context) error {
+	return w.limiter.Do(ctx, func() error { return w.do(ctx) })
+}
+
+func (w *write) do(ctx context.Context) error {
+	switch w.kind {
+	case WRITE_UPDATE:
+		return w.database.Update(ctx, w.dto.(db.UpdateDto))
+	case WRITE_INSERT:
+		return w.database.Insert(ctx, w.dto.(db.InsertDto))
+	case WRITE_UPSERT:
+		return w.database.Upsert(ctx, w.dto.(db.InsertDto))
+	case WRITE_REPLACE_CHILDREN:
+		return w.database.ReplaceChildren(ctx, w.dto.(db.ChildrenDto))
+	}
+	return apperr.New(apperr.INTERNAL, "write", "unknown kind of write \""+w.kind+"\"")
+}
+
+func (w *write) tableName() string {
+	switch dto := w.dto.(type) {
+	case db.UpdateDto:
+		return dto.TableName
+	case db.InsertDto:
+		return dto.TableName
+	case db.ChildrenDto:
+		return dto.TableName
+	}
+	return ""
+}
+
+// retryWrite executes a write until it succeeds, fails with an error, which the policy
+// doesn't retry, or the attempts run out. The pauses between attempts double up to
+// the policy's maximum. It returns the number of attempts made.
+func retryWrite(ctx context.Context, policy cfg.Retry, w *write) (int, error) {
+	backoff := policy.GetBackoff()
+	for attempt := 1; ; attempt++ {
+		err := w.execute(ctx)
+		if err == nil || attempt >= policy.GetAttempts() || !isRetryable(policy, err) {
+			return attempt, err
+		}
+		log.Printf("[retry] %s of table %s failed (attempt %d), retrying in %s: %s\n", w.kind, w.tableName(), attempt, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > policy.GetMaxBackoff() {
+			backoff = policy.GetMaxBackoff()
+		}
+	}
+}
+
+// isRetryable tells whether the error belongs to one of the categories retried by the policy.
+func isRetryable(policy cfg.Retry, err error) bool {
+	return util.StringSliceContains(policy.GetRetryOn(), string(apperr.CategoryOf(err)))
+}
@@ -42,8 +42,14 @@ func YAMLStruct(structure interface{}, nullableFields []string) {
 			continue
 		}
 
+		// Nullable fields are listed by their YAML keys.
+		fieldName := strings.ToLower(fieldType.Field(i).Name)
+		if yamlKey := strings.Split(fieldType.Field(i).Tag.Get("yaml"), ",")[0]; yamlKey != "" {
+			fieldName = yamlKey
+		}
+
 		if !YAMLField(fieldValue.Field(i).Interface(), fieldType.Field(i).Name) {
-			if !util.StringSliceContains(nullableFields, strings.ToLower(fieldType.Field(i).Name)) {
+			if !util.StringSliceContains(nullableFields, fieldName) {
 				panic(fieldType.Field(i).Name + " is invalid.")
 			}
 		}